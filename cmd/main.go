@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"zai-proxy/internal"
@@ -12,8 +21,9 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Session-ID, x-goog-api-key, Last-Event-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Context-Management, X-Cache, X-Served-Model, X-Media-Warning, X-Session, Retry-After")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -54,6 +64,57 @@ func (rw *responseWriter) Flush() {
 	}
 }
 
+// Hijack 支持 WebSocket 升级
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// writeDeadlineMiddleware 写超时：非流式响应限制总时长，流式响应每次 Flush 后重新计时，
+// 长时间的 SSE 只要持续有输出就不会被中断
+func writeDeadlineMiddleware(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dw := &deadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w), timeout: timeout}
+		dw.extend()
+		next.ServeHTTP(dw, r)
+	})
+}
+
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (dw *deadlineWriter) extend() {
+	dw.rc.SetWriteDeadline(time.Now().Add(dw.timeout))
+}
+
+func (dw *deadlineWriter) Flush() {
+	dw.extend()
+	dw.rc.Flush()
+}
+
+// Hijack 升级后的连接自行管理超时，清除写超时
+func (dw *deadlineWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := dw.rc.Hijack()
+	if err == nil {
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, rw, err
+}
+
+func (dw *deadlineWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
+
 func handleRoot(w http.ResponseWriter, r *http.Request) {
 	telemetry := internal.GetTelemetryData()
 
@@ -73,6 +134,8 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 			"total_calls":         telemetry.TotalCalls,
 			"success_calls":       telemetry.SuccessCalls,
 			"success_rate":        telemetry.SuccessRate,
+			"cache_hits":          telemetry.CacheHits,
+			"cache_misses":        telemetry.CacheMisses,
 			"model_stats":         telemetry.ModelStats,
 		},
 	}
@@ -87,19 +150,149 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 func main() {
 	internal.LoadConfig()
 	internal.InitLogger()
+	internal.LoadTelemetry()
+	if err := internal.InitTokenizer(); err != nil {
+		internal.LogError("TOKENIZER_VOCAB 加载失败: %v", err)
+		os.Exit(1)
+	}
 	if err := internal.GetTokenManager().Start(); err != nil {
 		internal.LogError("TokenManager 启动失败: %v", err)
 	}
 
 	internal.StartVersionUpdater()
 	internal.StartModelFetcher()
+	internal.StartBatchRunner()
 	http.HandleFunc("/", corsMiddleware(loggingMiddleware(handleRoot)))
+	// 探针请求频繁，不记录访问日志
+	http.HandleFunc("/healthz", internal.HandleHealthz)
+	http.HandleFunc("/readyz", internal.HandleReadyz)
+	http.HandleFunc("/status", corsMiddleware(loggingMiddleware(internal.HandleStatus)))
 	http.HandleFunc("/v1/models", corsMiddleware(loggingMiddleware(internal.HandleModels)))
+	http.HandleFunc("/v1/models/", corsMiddleware(loggingMiddleware(internal.HandleModels)))
+	http.HandleFunc("/v1/images/generations", corsMiddleware(loggingMiddleware(internal.HandleImageGenerations)))
+	http.HandleFunc("/v1/images/files/", corsMiddleware(internal.HandleImageFiles))
+	http.HandleFunc("/v1/files", corsMiddleware(loggingMiddleware(internal.HandleFiles)))
+	http.HandleFunc("/v1/files/", corsMiddleware(loggingMiddleware(internal.HandleFiles)))
+	http.HandleFunc("/v1/batches", corsMiddleware(loggingMiddleware(internal.HandleBatches)))
+	http.HandleFunc("/v1/batches/", corsMiddleware(loggingMiddleware(internal.HandleBatches)))
 	http.HandleFunc("/v1/chat/completions", corsMiddleware(loggingMiddleware(internal.HandleChatCompletions)))
+	http.HandleFunc("/v1/chat/completions/", corsMiddleware(loggingMiddleware(internal.HandleChatCompletionStream)))
+	http.HandleFunc("/v1/ws", corsMiddleware(loggingMiddleware(internal.HandleWebSocket)))
+	http.HandleFunc("/v1/completions", corsMiddleware(loggingMiddleware(internal.HandleCompletions)))
+	http.HandleFunc("/api/chat", corsMiddleware(loggingMiddleware(internal.HandleOllamaChat)))
+	http.HandleFunc("/api/generate", corsMiddleware(loggingMiddleware(internal.HandleOllamaGenerate)))
+	http.HandleFunc("/api/tags", corsMiddleware(loggingMiddleware(internal.HandleOllamaTags)))
+	http.HandleFunc("/api/show", corsMiddleware(loggingMiddleware(internal.HandleOllamaShow)))
+	http.HandleFunc("/v1beta/models/", corsMiddleware(loggingMiddleware(internal.HandleGemini)))
 	addr := ":" + internal.Cfg.Port
-	internal.LogInfo("Server starting on %s", addr)
-	internal.LogInfo("API docs available at http://localhost:%s/v1/models", internal.Cfg.Port)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           writeDeadlineMiddleware(http.DefaultServeMux, time.Duration(internal.Cfg.WriteTimeout)*time.Second),
+		ReadHeaderTimeout: time.Duration(internal.Cfg.ReadHeaderTimeout) * time.Second,
+		IdleTimeout:       time.Duration(internal.Cfg.IdleTimeout) * time.Second,
+	}
+	tlsConfig, err := internal.ServerTLSConfig()
+	if err != nil {
+		internal.LogError("TLS 配置失败: %v", err)
+		os.Exit(1)
+	}
+	srv.TLSConfig = tlsConfig
+	if !internal.Cfg.HTTP2Enabled {
+		// 非 nil 的空映射阻止 http.Server 自动启用 HTTP/2
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
 		internal.LogError("Server failed: %v", err)
+		os.Exit(1)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
 	}
+	internal.LogInfo("Server starting on %s (%s)", addr, scheme)
+	internal.LogInfo("API docs available at %s://localhost:%s/v1/models", scheme, internal.Cfg.Port)
+
+	serveErr := make(chan error, 3)
+	go func() {
+		if tlsConfig != nil {
+			serveErr <- srv.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+
+	// Unix socket 通常供本机反向代理使用，不启用 TLS
+	if internal.Cfg.UnixSocket != "" {
+		unixLn, err := internal.ListenUnix(internal.Cfg.UnixSocket, internal.Cfg.UnixSocketMode)
+		if err != nil {
+			internal.LogError("Unix socket 监听失败: %v", err)
+			os.Exit(1)
+		}
+		internal.LogInfo("Server listening on unix:%s", internal.Cfg.UnixSocket)
+		go func() {
+			serveErr <- srv.Serve(unixLn)
+		}()
+	}
+
+	var acmeSrv *http.Server
+	if handler := internal.ACMEHTTPHandler(); handler != nil && internal.Cfg.ACMEHTTPPort != "" {
+		acmeSrv = &http.Server{
+			Addr:              ":" + internal.Cfg.ACMEHTTPPort,
+			Handler:           handler,
+			ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		}
+		internal.LogInfo("ACME HTTP-01 challenge listening on %s", acmeSrv.Addr)
+		go func() {
+			serveErr <- acmeSrv.ListenAndServe()
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			internal.LogError("Server failed: %v", err)
+		}
+	case sig := <-stop:
+		internal.LogInfo("Received %v, shutting down", sig)
+	}
+	if acmeSrv != nil {
+		acmeSrv.Close()
+	}
+	shutdown(srv)
+}
+
+// shutdown 优雅关闭：停止接收新连接并等待进行中的请求完成，
+// 超过 SHUTDOWN_TIMEOUT 后中断剩余的流式响应（以错误事件结束），最后停止后台任务并保存统计数据
+func shutdown(srv *http.Server) {
+	// 中断后留给处理函数写出错误事件的时间
+	const cutGrace = 5 * time.Second
+
+	internal.BeginDrain()
+	drained := make(chan struct{})
+	go func() {
+		srv.Shutdown(context.Background())
+		internal.WaitHijacked()
+		internal.WaitBatchRunner()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(time.Duration(internal.Cfg.ShutdownTimeout) * time.Second):
+		internal.CutInFlight()
+		select {
+		case <-drained:
+		case <-time.After(cutGrace):
+			internal.LogWarn("Shutdown grace period exceeded, closing remaining connections")
+			srv.Close()
+		}
+	}
+
+	internal.StopBackgroundWorkers()
+	internal.SaveTelemetry()
+	internal.LogInfo("Server stopped")
 }
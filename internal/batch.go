@@ -0,0 +1,870 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const batchesPath = "/v1/batches"
+
+// 批处理状态
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// batchCompletionWindow 目前仅支持 24h
+const batchCompletionWindow = 24 * time.Hour
+
+// batchEndpoints 批处理支持的端点
+var batchEndpoints = map[string]http.HandlerFunc{
+	"/v1/chat/completions": HandleChatCompletions,
+	"/v1/completions":      HandleCompletions,
+}
+
+// internalCallerKey 内部请求的 context 键
+type internalCallerKey struct{}
+
+// internalCaller 服务端内部发起的请求（如批处理任务），沿用发起者的 API Key 和文件归属，不再校验 Authorization
+type internalCaller struct {
+	apiKey       string
+	owner        string
+	citationMode string // 覆盖 CITATION_MODE，为空时使用全局配置
+	background   bool   // 批处理等后台任务发起的请求，不使用会话模式，排队时不受超时和队列长度限制
+}
+
+// BatchRequestCounts 请求计数
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchError 批处理校验错误
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// BatchErrors 批处理错误列表
+type BatchErrors struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+// Batch OpenAI 批处理对象
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        int64              `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+// BatchListResponse 批处理列表响应
+type BatchListResponse struct {
+	Object  string  `json:"object"`
+	Data    []Batch `json:"data"`
+	FirstID *string `json:"first_id"`
+	LastID  *string `json:"last_id"`
+	HasMore bool    `json:"has_more"`
+}
+
+// CreateBatchRequest 创建批处理请求
+type CreateBatchRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// batchRequestLine 输入文件中的一行
+type batchRequestLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// batchResultLine 输出/错误文件中的一行
+type batchResultLine struct {
+	ID       string            `json:"id"`
+	CustomID string            `json:"custom_id"`
+	Response *batchResponse    `json:"response"`
+	Error    *batchResultError `json:"error"`
+}
+
+type batchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type batchResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// storedBatch 持久化的批处理，owner 为创建者的文件归属
+type storedBatch struct {
+	Batch
+	Owner string `json:"owner"`
+}
+
+func (b *storedBatch) terminal() bool {
+	switch b.Status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// batchManager 持久化的批处理队列：批处理按创建顺序逐个执行，批内请求并发执行
+type batchManager struct {
+	mu      sync.Mutex
+	dir     string
+	batches map[string]*storedBatch
+	pending []string
+	wake    chan struct{}
+	started bool
+	stop    chan struct{} // 关闭后不再派发新请求
+	stopped chan struct{} // 执行队列退出后关闭
+}
+
+var (
+	batchManagerInstance *batchManager
+	batchManagerOnce     sync.Once
+)
+
+// getBatchManager 获取全局批处理管理器
+func getBatchManager() *batchManager {
+	batchManagerOnce.Do(func() {
+		batchManagerInstance = &batchManager{
+			dir:     Cfg.BatchDir,
+			batches: make(map[string]*storedBatch),
+			wake:    make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+			stopped: make(chan struct{}),
+		}
+	})
+	return batchManagerInstance
+}
+
+// StartBatchRunner 加载已有批处理并启动执行队列，未完成的批处理从中断处继续
+func StartBatchRunner() {
+	m := getBatchManager()
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		LogError("[Batch] create dir %s failed: %v", m.dir, err)
+		return
+	}
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		LogError("[Batch] read dir failed: %v", err)
+		return
+	}
+	var resume []*storedBatch
+	m.mu.Lock()
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "batch_") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.dir, name))
+		if err != nil {
+			continue
+		}
+		var b storedBatch
+		if err := json.Unmarshal(data, &b); err != nil {
+			LogError("[Batch] corrupt batch %s: %v", name, err)
+			continue
+		}
+		m.batches[b.ID] = &b
+		if !b.terminal() {
+			resume = append(resume, &b)
+		}
+	}
+	sort.Slice(resume, func(i, j int) bool { return resume[i].CreatedAt < resume[j].CreatedAt })
+	for _, b := range resume {
+		m.pending = append(m.pending, b.ID)
+	}
+	m.started = true
+	m.mu.Unlock()
+	if len(resume) > 0 {
+		LogInfo("[Batch] Resuming %d unfinished batches", len(resume))
+	}
+	go m.loop()
+}
+
+func (m *batchManager) enqueue(id string) {
+	m.mu.Lock()
+	m.pending = append(m.pending, id)
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *batchManager) loop() {
+	defer close(m.stopped)
+	for !m.stopping() {
+		m.mu.Lock()
+		var id string
+		if len(m.pending) > 0 {
+			id = m.pending[0]
+			m.pending = m.pending[1:]
+		}
+		m.mu.Unlock()
+		if id == "" {
+			select {
+			case <-m.wake:
+			case <-m.stop:
+			}
+			continue
+		}
+		recordWorkerRun(WorkerBatchRunner, m.run(id))
+	}
+}
+
+// pendingCount 等待执行的批处理数
+func (m *batchManager) pendingCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+// stopDispatch 停止派发新请求，进行中的批处理保持 in_progress，重启后继续
+func (m *batchManager) stopDispatch() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+}
+
+func (m *batchManager) stopping() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitStopped 等待执行队列退出；执行队列未启动时直接返回
+func (m *batchManager) waitStopped() {
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+	if started {
+		<-m.stopped
+	}
+}
+
+func (m *batchManager) metaPath(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+func (m *batchManager) partialPath(id, kind string) string {
+	return filepath.Join(m.dir, id+"."+kind+".partial.jsonl")
+}
+
+// saveLocked 持久化批处理元数据
+func (m *batchManager) saveLocked(b *storedBatch) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return
+	}
+	tmp := m.metaPath(b.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		LogError("[Batch] save %s failed: %v", b.ID, err)
+		return
+	}
+	if err := os.Rename(tmp, m.metaPath(b.ID)); err != nil {
+		os.Remove(tmp)
+		LogError("[Batch] save %s failed: %v", b.ID, err)
+	}
+}
+
+// update 在锁内修改批处理并持久化，返回修改后的快照
+func (m *batchManager) update(id string, fn func(b *storedBatch)) Batch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.batches[id]
+	fn(b)
+	m.saveLocked(b)
+	return b.Batch
+}
+
+// get 获取 owner 的批处理快照
+func (m *batchManager) get(id, owner string) (Batch, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.batches[id]
+	if !ok || b.Owner != owner {
+		return Batch{}, false
+	}
+	return b.Batch, true
+}
+
+func (m *batchManager) status(id string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.batches[id].Status
+}
+
+func nowUnix() *int64 {
+	t := time.Now().Unix()
+	return &t
+}
+
+// create 创建批处理并加入队列
+func (m *batchManager) create(owner string, req CreateBatchRequest) Batch {
+	now := time.Now()
+	b := &storedBatch{
+		Batch: Batch{
+			ID:               "batch_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+			Object:           "batch",
+			Endpoint:         req.Endpoint,
+			InputFileID:      req.InputFileID,
+			CompletionWindow: req.CompletionWindow,
+			Status:           BatchStatusValidating,
+			CreatedAt:        now.Unix(),
+			ExpiresAt:        now.Add(batchCompletionWindow).Unix(),
+			Metadata:         req.Metadata,
+		},
+		Owner: owner,
+	}
+	m.mu.Lock()
+	m.batches[b.ID] = b
+	m.saveLocked(b)
+	snapshot := b.Batch
+	m.mu.Unlock()
+	m.enqueue(b.ID)
+	return snapshot
+}
+
+// cancel 请求取消批处理，正在执行的请求完成后停止
+func (m *batchManager) cancel(id, owner string) (Batch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.batches[id]
+	if !ok || b.Owner != owner {
+		return Batch{}, ErrFileNotFound
+	}
+	if b.terminal() || b.Status == BatchStatusCancelling {
+		return b.Batch, fmt.Errorf("批处理状态为 %s，无法取消", b.Status)
+	}
+	b.Status = BatchStatusCancelling
+	b.CancellingAt = nowUnix()
+	m.saveLocked(b)
+	return b.Batch, nil
+}
+
+// list 按创建时间倒序列出 owner 的批处理
+func (m *batchManager) list(owner, after string, limit int) ([]Batch, bool) {
+	m.mu.Lock()
+	var all []Batch
+	for _, b := range m.batches {
+		if b.Owner == owner {
+			all = append(all, b.Batch)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].CreatedAt != all[j].CreatedAt {
+			return all[i].CreatedAt > all[j].CreatedAt
+		}
+		return all[i].ID > all[j].ID
+	})
+	if after != "" {
+		for i, b := range all {
+			if b.ID == after {
+				all = all[i+1:]
+				break
+			}
+		}
+	}
+	if len(all) > limit {
+		return all[:limit], true
+	}
+	return all, false
+}
+
+// batchWorkers 批内并发数：不超过 BATCH_CONCURRENCY 和 有效 token 数 × BATCH_PER_TOKEN_CONCURRENCY，
+// 在每个批处理开始执行时计算；请求仍由 token 管理器分配，不跟踪单个 token 实际进行中的请求数
+func batchWorkers() int {
+	workers := Cfg.BatchConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	if perToken := Cfg.BatchPerTokenConcurrency; perToken > 0 {
+		tokens := GetTokenManager().GetStats().ValidTokenCount
+		if tokens == 0 {
+			tokens = 1
+		}
+		workers = min(workers, tokens*perToken)
+	}
+	return workers
+}
+
+// apiKeyForOwner 根据归属找回配置中的 API Key，用于按 Key 应用功能策略
+func apiKeyForOwner(owner string) string {
+	for _, key := range Cfg.AuthTokens {
+		if fileOwner(key) == owner {
+			return key
+		}
+	}
+	return ""
+}
+
+// loadBatchRequests 读取并校验输入文件
+func loadBatchRequests(b *storedBatch) ([]batchRequestLine, []BatchError) {
+	_, data, err := getFileStore().content(b.InputFileID, b.Owner)
+	if err != nil {
+		return nil, []BatchError{{Code: "file_not_found", Message: fmt.Sprintf("输入文件 %s 不存在", b.InputFileID), Param: "input_file_id"}}
+	}
+	var requests []batchRequestLine
+	var errs []BatchError
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req batchRequestLine
+		switch {
+		case json.Unmarshal(line, &req) != nil:
+			errs = append(errs, BatchError{Code: "invalid_json_line", Message: "无效的 JSON", Line: lineNo})
+		case req.CustomID == "":
+			errs = append(errs, BatchError{Code: "missing_custom_id", Message: "缺少 custom_id", Line: lineNo})
+		case seen[req.CustomID]:
+			errs = append(errs, BatchError{Code: "duplicate_custom_id", Message: fmt.Sprintf("重复的 custom_id: %s", req.CustomID), Line: lineNo})
+		case req.Method != http.MethodPost:
+			errs = append(errs, BatchError{Code: "invalid_method", Message: "method 必须为 POST", Line: lineNo})
+		case req.URL != b.Endpoint:
+			errs = append(errs, BatchError{Code: "mismatched_endpoint", Message: fmt.Sprintf("url 必须与批处理端点 %s 一致", b.Endpoint), Line: lineNo})
+		case len(req.Body) == 0 || req.Body[0] != '{':
+			errs = append(errs, BatchError{Code: "invalid_body", Message: "body 必须为 JSON 对象", Line: lineNo})
+		default:
+			seen[req.CustomID] = true
+			requests = append(requests, req)
+		}
+		if len(errs) >= 100 {
+			break
+		}
+	}
+	if len(errs) == 0 && len(requests) == 0 {
+		errs = append(errs, BatchError{Code: "empty_file", Message: "输入文件没有请求"})
+	}
+	if max := Cfg.BatchMaxRequests; max > 0 && len(requests) > max {
+		errs = append(errs, BatchError{Code: "too_many_requests", Message: fmt.Sprintf("请求数超过上限 %d", max)})
+	}
+	return requests, errs
+}
+
+// loadBatchProgress 读取已完成的结果，截断崩溃时写了一半的行，返回已完成的 custom_id 数量
+func loadBatchProgress(path string, done map[string]bool) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	if idx := bytes.LastIndexByte(data, '\n'); idx+1 != len(data) {
+		data = data[:idx+1]
+		os.Truncate(path, int64(len(data)))
+	}
+	count := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var r batchResultLine
+		if len(line) == 0 || json.Unmarshal(line, &r) != nil {
+			continue
+		}
+		done[r.CustomID] = true
+		count++
+	}
+	return count
+}
+
+// run 执行单个批处理
+func (m *batchManager) run(id string) error {
+	m.mu.Lock()
+	b, ok := m.batches[id]
+	var snapshot storedBatch
+	if ok {
+		snapshot = *b
+	}
+	m.mu.Unlock()
+	if !ok || snapshot.terminal() {
+		return nil
+	}
+
+	requests, errs := loadBatchRequests(&snapshot)
+	if len(errs) > 0 {
+		LogWarn("[Batch] %s validation failed: %s", id, errs[0].Message)
+		m.update(id, func(b *storedBatch) {
+			b.Status = BatchStatusFailed
+			b.FailedAt = nowUnix()
+			b.Errors = &BatchErrors{Object: "list", Data: errs}
+		})
+		return nil
+	}
+
+	done := make(map[string]bool)
+	completed := loadBatchProgress(m.partialPath(id, "output"), done)
+	failed := loadBatchProgress(m.partialPath(id, "errors"), done)
+	m.update(id, func(b *storedBatch) {
+		if b.Status == BatchStatusValidating {
+			b.Status = BatchStatusInProgress
+			b.InProgressAt = nowUnix()
+		}
+		b.RequestCounts = BatchRequestCounts{Total: len(requests), Completed: completed, Failed: failed}
+	})
+	LogInfo("[Batch] %s started: %d requests (%d already done)", id, len(requests), completed+failed)
+
+	outFile, err := os.OpenFile(m.partialPath(id, "output"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		LogError("[Batch] open output failed: %v", err)
+		return err
+	}
+	defer outFile.Close()
+	errFile, err := os.OpenFile(m.partialPath(id, "errors"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		LogError("[Batch] open errors failed: %v", err)
+		return err
+	}
+	defer errFile.Close()
+
+	caller := &internalCaller{apiKey: apiKeyForOwner(snapshot.Owner), owner: snapshot.Owner, background: true}
+	handler := batchEndpoints[snapshot.Endpoint]
+	expiresAt := time.Unix(snapshot.ExpiresAt, 0)
+	record := func(result batchResultLine, ok bool) {
+		if !ok && m.stopping() {
+			// 关闭时被中断的请求不记录结果，重启后重新执行
+			return
+		}
+		line, _ := json.Marshal(result)
+		line = append(line, '\n')
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		b := m.batches[id]
+		if ok {
+			outFile.Write(line)
+			b.RequestCounts.Completed++
+		} else {
+			errFile.Write(line)
+			b.RequestCounts.Failed++
+		}
+		m.saveLocked(b)
+	}
+
+	sem := make(chan struct{}, batchWorkers())
+	var wg sync.WaitGroup
+	expired := false
+	for _, req := range requests {
+		if done[req.CustomID] {
+			continue
+		}
+		if m.status(id) == BatchStatusCancelling || m.stopping() {
+			break
+		}
+		if time.Now().After(expiresAt) {
+			expired = true
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(req batchRequestLine) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			record(executeBatchRequest(handler, snapshot.Endpoint, caller, req))
+		}(req)
+		done[req.CustomID] = true
+	}
+	wg.Wait()
+	if m.stopping() && m.status(id) != BatchStatusCancelling {
+		LogInfo("[Batch] %s paused for shutdown, will resume on restart", id)
+		return nil
+	}
+
+	if expired {
+		for _, req := range requests {
+			if !done[req.CustomID] {
+				record(batchResultLine{
+					ID:       "batch_req_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+					CustomID: req.CustomID,
+					Error:    &batchResultError{Code: "batch_expired", Message: "批处理在完成窗口内未执行该请求"},
+				}, false)
+			}
+		}
+	}
+	m.finalize(id, expired)
+	return nil
+}
+
+// executeBatchRequest 通过与在线请求相同的处理函数执行单个请求
+func executeBatchRequest(handler http.HandlerFunc, endpoint string, caller *internalCaller, line batchRequestLine) (batchResultLine, bool) {
+	result := batchResultLine{
+		ID:       "batch_req_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+		CustomID: line.CustomID,
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(line.Body, &body); err != nil {
+		result.Error = &batchResultError{Code: "invalid_body", Message: err.Error()}
+		return result, false
+	}
+	body["stream"] = false
+	payload, _ := json.Marshal(body)
+
+	ctx := context.WithValue(context.Background(), internalCallerKey{}, caller)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		result.Error = &batchResultError{Code: "internal_error", Message: err.Error()}
+		return result, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "batch"
+
+	buf := newResponseBuffer()
+	handler(buf, req)
+
+	respBody := bytes.TrimSpace(buf.body.Bytes())
+	if !json.Valid(respBody) {
+		respBody, _ = json.Marshal(string(respBody))
+	}
+	result.Response = &batchResponse{
+		StatusCode: buf.statusCode,
+		RequestID:  "req_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+		Body:       respBody,
+	}
+	if buf.statusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.Unmarshal(respBody, &errResp)
+		code := errResp.Error.Code
+		if code == "" {
+			code = strconv.Itoa(buf.statusCode)
+		}
+		result.Error = &batchResultError{Code: code, Message: errResp.Error.Message}
+		return result, false
+	}
+	return result, true
+}
+
+// finalize 将部分结果写入输出/错误文件并更新最终状态
+func (m *batchManager) finalize(id string, expired bool) {
+	cancelled := m.status(id) == BatchStatusCancelling
+	if !cancelled && !expired {
+		m.update(id, func(b *storedBatch) {
+			b.Status = BatchStatusFinalizing
+			b.FinalizingAt = nowUnix()
+		})
+	}
+
+	m.mu.Lock()
+	owner := m.batches[id].Owner
+	m.mu.Unlock()
+	// 保存失败时保留部分结果文件，批处理标记为失败，避免结果丢失
+	var storeErrs []BatchError
+	outputID, err := m.storeResultFile(id, owner, "output")
+	if err != nil {
+		storeErrs = append(storeErrs, BatchError{Code: "output_store_failed", Message: fmt.Sprintf("保存输出文件失败: %v", err)})
+	}
+	errorID, err := m.storeResultFile(id, owner, "errors")
+	if err != nil {
+		storeErrs = append(storeErrs, BatchError{Code: "error_file_store_failed", Message: fmt.Sprintf("保存错误文件失败: %v", err)})
+	}
+
+	final := m.update(id, func(b *storedBatch) {
+		b.OutputFileID = outputID
+		b.ErrorFileID = errorID
+		switch {
+		case len(storeErrs) > 0:
+			b.Status = BatchStatusFailed
+			b.FailedAt = nowUnix()
+			b.Errors = &BatchErrors{Object: "list", Data: storeErrs}
+		case cancelled:
+			b.Status = BatchStatusCancelled
+			b.CancelledAt = nowUnix()
+		case expired:
+			b.Status = BatchStatusExpired
+			b.ExpiredAt = nowUnix()
+		default:
+			b.Status = BatchStatusCompleted
+			b.CompletedAt = nowUnix()
+		}
+	})
+	LogInfo("[Batch] %s %s: completed=%d, failed=%d", id, final.Status, final.RequestCounts.Completed, final.RequestCounts.Failed)
+}
+
+// storeResultFile 将部分结果保存到文件存储，成功后删除部分结果文件；没有内容时返回 nil
+func (m *batchManager) storeResultFile(id, owner, kind string) (*string, error) {
+	path := m.partialPath(id, kind)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		LogError("[Batch] open %s file for %s failed: %v", kind, id, err)
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		f.Close()
+		if err != nil {
+			LogError("[Batch] stat %s file for %s failed: %v", kind, id, err)
+			return nil, err
+		}
+		os.Remove(path)
+		return nil, nil
+	}
+	stored, err := getFileStore().create(owner, id+"_"+kind+".jsonl", "batch_output", "application/jsonl", f)
+	f.Close()
+	if err != nil {
+		LogError("[Batch] store %s file for %s failed, partial results kept at %s: %v", kind, id, path, err)
+		return nil, err
+	}
+	os.Remove(path)
+	return &stored.ID, nil
+}
+
+// HandleBatches OpenAI Batch API：创建、列表、查询和取消
+func HandleBatches(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	owner := fileOwner(apiKey)
+	m := getBatchManager()
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, batchesPath), "/")
+	parts := strings.Split(rest, "/")
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			limit := 20
+			if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+				limit = min(v, 100)
+			}
+			data, hasMore := m.list(owner, r.URL.Query().Get("after"), limit)
+			resp := BatchListResponse{Object: "list", Data: data, HasMore: hasMore}
+			if resp.Data == nil {
+				resp.Data = []Batch{}
+			}
+			if len(data) > 0 {
+				resp.FirstID = &data[0].ID
+				resp.LastID = &data[len(data)-1].ID
+			}
+			writeJSON(w, resp)
+		case http.MethodPost:
+			handleCreateBatch(w, r, m, owner)
+		default:
+			writeInvalidRequestError(w, "Only GET and POST methods are allowed")
+		}
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			writeInvalidRequestError(w, "Only GET method is allowed")
+			return
+		}
+		b, ok := m.get(parts[0], owner)
+		if !ok {
+			writeBatchNotFoundError(w, parts[0])
+			return
+		}
+		writeJSON(w, b)
+	case len(parts) == 2 && parts[1] == "cancel":
+		if r.Method != http.MethodPost {
+			writeInvalidRequestError(w, "Only POST method is allowed")
+			return
+		}
+		b, err := m.cancel(parts[0], owner)
+		if err == ErrFileNotFound {
+			writeBatchNotFoundError(w, parts[0])
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, ErrTypeInvalidRequest, err.Error(), "batch_not_cancellable")
+			return
+		}
+		LogInfo("[Batch] %s cancelling", b.ID)
+		writeJSON(w, b)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func handleCreateBatch(w http.ResponseWriter, r *http.Request, m *batchManager, owner string) {
+	var req CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidRequestError(w, "无效的请求格式")
+		return
+	}
+	if req.InputFileID == "" {
+		writeInvalidRequestError(w, "缺少 input_file_id")
+		return
+	}
+	if _, ok := batchEndpoints[req.Endpoint]; !ok {
+		writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, fmt.Sprintf("不支持的端点: %s", req.Endpoint), "invalid_endpoint")
+		return
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+	if req.CompletionWindow != "24h" {
+		writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, "completion_window 仅支持 24h", "invalid_completion_window")
+		return
+	}
+	if _, err := getFileStore().get(req.InputFileID, owner); err != nil {
+		writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, fmt.Sprintf("文件 '%s' 不存在", req.InputFileID), "file_not_found")
+		return
+	}
+	b := m.create(owner, req)
+	LogInfo("[Batch] Created %s from %s", b.ID, b.InputFileID)
+	writeJSON(w, b)
+}
+
+// writeBatchNotFoundError 批处理不存在错误
+func writeBatchNotFoundError(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, ErrTypeNotFound, fmt.Sprintf("批处理 '%s' 不存在", id), "batch_not_found")
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBatchManager(t *testing.T) *batchManager {
+	t.Helper()
+	return &batchManager{
+		dir:     t.TempDir(),
+		batches: make(map[string]*storedBatch),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func TestBatchFinalize(t *testing.T) {
+	// 结果文件超过上传上限时仍需完整保存
+	large := bytes.Repeat([]byte(`{"custom_id":"x"}`+"\n"), (2<<20)/18)
+
+	tests := []struct {
+		name        string
+		status      string
+		expired     bool
+		output      []byte
+		errors      []byte
+		storeFails  bool
+		wantStatus  string
+		wantOutput  bool
+		wantErrFile bool
+		wantPartial bool
+	}{
+		{name: "completed", status: BatchStatusInProgress, output: []byte("{\"custom_id\":\"a\"}\n"), errors: []byte("{\"custom_id\":\"b\"}\n"), wantStatus: BatchStatusCompleted, wantOutput: true, wantErrFile: true},
+		{name: "output larger than upload limit", status: BatchStatusInProgress, output: large, wantStatus: BatchStatusCompleted, wantOutput: true},
+		{name: "no results", status: BatchStatusInProgress, output: []byte{}, wantStatus: BatchStatusCompleted},
+		{name: "cancelled", status: BatchStatusCancelling, output: []byte("{\"custom_id\":\"a\"}\n"), wantStatus: BatchStatusCancelled, wantOutput: true},
+		{name: "expired", status: BatchStatusInProgress, expired: true, errors: []byte("{\"custom_id\":\"b\"}\n"), wantStatus: BatchStatusExpired, wantErrFile: true},
+		{name: "store failure keeps partial results", status: BatchStatusInProgress, output: []byte("{\"custom_id\":\"a\"}\n"), storeFails: true, wantStatus: BatchStatusFailed, wantPartial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadMax := Cfg.UploadMaxFileMB
+			Cfg.UploadMaxFileMB = 1
+			defer func() { Cfg.UploadMaxFileMB = uploadMax }()
+			store := getFileStore()
+			if tt.storeFails {
+				dir := store.dir
+				store.dir = filepath.Join(t.TempDir(), "missing")
+				defer func() { store.dir = dir }()
+			}
+
+			m := newTestBatchManager(t)
+			id := "batch_test"
+			m.batches[id] = &storedBatch{Batch: Batch{ID: id, Object: "batch", Status: tt.status}, Owner: "owner"}
+			if tt.output != nil {
+				os.WriteFile(m.partialPath(id, "output"), tt.output, 0644)
+			}
+			if tt.errors != nil {
+				os.WriteFile(m.partialPath(id, "errors"), tt.errors, 0644)
+			}
+
+			m.finalize(id, tt.expired)
+
+			b, _ := m.get(id, "owner")
+			if b.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s", b.Status, tt.wantStatus)
+			}
+			if (b.OutputFileID != nil) != tt.wantOutput {
+				t.Errorf("output_file_id = %v, want present=%v", b.OutputFileID, tt.wantOutput)
+			}
+			if (b.ErrorFileID != nil) != tt.wantErrFile {
+				t.Errorf("error_file_id = %v, want present=%v", b.ErrorFileID, tt.wantErrFile)
+			}
+			if b.OutputFileID != nil {
+				f, data, err := store.content(*b.OutputFileID, "owner")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Purpose != "batch_output" || !bytes.Equal(data, tt.output) {
+					t.Errorf("stored output purpose=%s bytes=%d, want batch_output bytes=%d", f.Purpose, len(data), len(tt.output))
+				}
+			}
+			if tt.storeFails && (b.Errors == nil || len(b.Errors.Data) == 0) {
+				t.Error("failed batch has no errors")
+			}
+			_, err := os.Stat(m.partialPath(id, "output"))
+			if partial := err == nil; partial != tt.wantPartial {
+				t.Errorf("partial output exists = %v, want %v", partial, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestMaxFileBytes(t *testing.T) {
+	uploadMax, batchMax := Cfg.UploadMaxFileMB, Cfg.BatchMaxFileMB
+	Cfg.UploadMaxFileMB, Cfg.BatchMaxFileMB = 20, 200
+	defer func() { Cfg.UploadMaxFileMB, Cfg.BatchMaxFileMB = uploadMax, batchMax }()
+	tests := []struct {
+		purpose string
+		want    int64
+	}{
+		{"user_data", 20 << 20},
+		{"vision", 20 << 20},
+		{"batch", 200 << 20},
+		{"batch_output", 0},
+	}
+	for _, tt := range tests {
+		if got := maxFileBytes(tt.purpose); got != tt.want {
+			t.Errorf("maxFileBytes(%s) = %d, want %d", tt.purpose, got, tt.want)
+		}
+	}
+}
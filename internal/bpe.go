@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed assets
+var assetsFS embed.FS
+
+// glmVocabAsset 内置 GLM 词表路径（tiktoken 格式：每行 base64 token + 空格 + rank）。
+// 词表为可选资源，构建前放入 internal/assets 即打包进二进制
+const glmVocabAsset = "assets/glm_tokenizer.model"
+
+// errNoVocab 未内置词表且未设置 TOKENIZER_VOCAB
+var errNoVocab = errors.New("未内置 GLM 词表且未设置 TOKENIZER_VOCAB")
+
+// bpeMaxPieceLen 单个预分词片段的最大字节数，超长片段分段合并以避免 O(n^2) 退化
+const bpeMaxPieceLen = 512
+
+// glmPreTokenizePattern GLM-4 预分词规则
+// 原始规则中的 \s+(?!\S) 分支 RE2 不支持，由 splitPreTokens 补偿
+var glmPreTokenizePattern = regexp.MustCompile(`^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+)`)
+
+// BPETokenizer 基于 GLM 词表的 BPE 分词器（仅用于计数）
+type BPETokenizer struct {
+	ranks map[string]int
+}
+
+var (
+	bpeTokenizer *BPETokenizer
+	bpeLoadErr   error
+	bpeOnce      sync.Once
+)
+
+// InitTokenizer 启动时加载 GLM 词表。TOKENIZER_VOCAB 指定的文件无法加载时返回错误；
+// 没有可用词表时回退到估算计数
+func InitTokenizer() error {
+	GetBPETokenizer()
+	if bpeLoadErr != nil && Cfg.TokenizerVocab != "" {
+		return bpeLoadErr
+	}
+	return nil
+}
+
+// GetBPETokenizer 获取 GLM 分词器，词表不可用时返回 nil
+func GetBPETokenizer() *BPETokenizer {
+	bpeOnce.Do(func() {
+		tok, source, err := loadGLMTokenizer()
+		if errors.Is(err, errNoVocab) {
+			bpeLoadErr = err
+			LogInfo("未找到 GLM 词表，token 计数使用估算值（可选词表见 internal/assets/README.md）")
+			return
+		}
+		if err != nil {
+			bpeLoadErr = err
+			LogError("GLM 词表加载失败，token 计数回退到估算值: %v", err)
+			return
+		}
+		bpeTokenizer = tok
+		LogInfo("已加载 GLM 词表: %s (%d tokens)", source, len(tok.ranks))
+	})
+	return bpeTokenizer
+}
+
+// loadGLMTokenizer 优先加载 TOKENIZER_VOCAB 指定的文件，其次加载内置词表
+func loadGLMTokenizer() (*BPETokenizer, string, error) {
+	if Cfg != nil && Cfg.TokenizerVocab != "" {
+		f, err := os.Open(Cfg.TokenizerVocab)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		tok, err := NewBPETokenizer(f)
+		return tok, Cfg.TokenizerVocab, err
+	}
+
+	f, err := assetsFS.Open(glmVocabAsset)
+	if err != nil {
+		return nil, "", errNoVocab
+	}
+	defer f.Close()
+	tok, err := NewBPETokenizer(f)
+	return tok, glmVocabAsset, err
+}
+
+// NewBPETokenizer 从 tiktoken 格式的词表创建分词器
+func NewBPETokenizer(r io.Reader) (*BPETokenizer, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		sep := bytes.IndexByte(line, ' ')
+		if sep == -1 {
+			return nil, fmt.Errorf("词表第 %d 行格式错误", lineNo)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(line[:sep]))
+		if err != nil {
+			return nil, fmt.Errorf("词表第 %d 行 base64 解码失败: %v", lineNo, err)
+		}
+		rank, err := strconv.Atoi(string(line[sep+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("词表第 %d 行 rank 无效: %v", lineNo, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("词表为空")
+	}
+	return &BPETokenizer{ranks: ranks}, nil
+}
+
+// Count 计算文本的 token 数
+func (t *BPETokenizer) Count(text string) int64 {
+	var total int64
+	for _, piece := range splitPreTokens(text) {
+		if _, ok := t.ranks[piece]; ok {
+			total++
+			continue
+		}
+		data := []byte(piece)
+		for len(data) > bpeMaxPieceLen {
+			cut := bpeMaxPieceLen
+			for cut > 0 && !utf8.RuneStart(data[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = bpeMaxPieceLen
+			}
+			total += int64(t.bytePairMerge(data[:cut]))
+			data = data[cut:]
+		}
+		total += int64(t.bytePairMerge(data))
+	}
+	return total
+}
+
+// bytePairMerge 按 rank 从小到大合并相邻字节对，返回最终 token 数
+func (t *BPETokenizer) bytePairMerge(piece []byte) int {
+	if len(piece) <= 1 {
+		return len(piece)
+	}
+	const noRank = int(^uint(0) >> 1)
+
+	// parts[i] 为第 i 个分片的起始位置，rank[i] 为 parts[i]..parts[i+2] 合并后的 rank
+	parts := make([]int, len(piece)+1)
+	rank := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	pairRank := func(i int) int {
+		if i+2 >= len(parts) {
+			return noRank
+		}
+		if r, ok := t.ranks[string(piece[parts[i]:parts[i+2]])]; ok {
+			return r
+		}
+		return noRank
+	}
+	for i := range rank {
+		rank[i] = pairRank(i)
+	}
+
+	for len(parts) > 2 {
+		minRank, minIdx := noRank, -1
+		for i := 0; i < len(parts)-2; i++ {
+			if rank[i] < minRank {
+				minRank, minIdx = rank[i], i
+			}
+		}
+		if minIdx == -1 {
+			break
+		}
+		parts = append(parts[:minIdx+1], parts[minIdx+2:]...)
+		rank = append(rank[:minIdx+1], rank[minIdx+2:]...)
+		rank[minIdx] = pairRank(minIdx)
+		if minIdx > 0 {
+			rank[minIdx-1] = pairRank(minIdx - 1)
+		}
+	}
+	return len(parts) - 1
+}
+
+// splitPreTokens 按 GLM 规则切分文本
+func splitPreTokens(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		end := 0
+		if loc := glmPreTokenizePattern.FindStringIndex(text); loc != nil {
+			end = loc[1]
+		}
+		if end == 0 {
+			_, size := utf8.DecodeRuneInString(text)
+			end = size
+		}
+		// 模拟 \s+(?!\S)：连续空白后紧跟非空白字符时，最后一个空白留给下一个片段
+		if end < len(text) && isAllSpace(text[:end]) {
+			if _, lastSize := utf8.DecodeLastRuneInString(text[:end]); end > lastSize {
+				end -= lastSize
+			}
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+func isAllSpace(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) || r == '\r' || r == '\n' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,493 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedCompletion 缓存的补全结果，可回放为普通响应或 SSE 流
+type CachedCompletion struct {
+	Model        string      `json:"model"`
+	Message      MessageResp `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+	Usage        Usage       `json:"usage"`
+	CreatedAt    int64       `json:"created_at"`
+}
+
+// ResponseCache 响应缓存后端
+type ResponseCache interface {
+	Get(key string) (*CachedCompletion, bool)
+	Set(key string, value *CachedCompletion)
+}
+
+var (
+	responseCache     ResponseCache
+	responseCacheOnce sync.Once
+)
+
+// GetResponseCache 获取响应缓存，未启用时返回 nil
+func GetResponseCache() ResponseCache {
+	responseCacheOnce.Do(func() {
+		if !Cfg.CacheEnabled {
+			return
+		}
+		ttl := time.Duration(Cfg.CacheTTL) * time.Second
+		switch strings.ToLower(Cfg.CacheBackend) {
+		case "disk":
+			cache, err := newDiskCache(Cfg.CacheDir, Cfg.CacheMaxEntries, ttl)
+			if err != nil {
+				LogError("[Cache] 初始化磁盘缓存失败: %v", err)
+				return
+			}
+			responseCache = cache
+		default:
+			responseCache = newMemoryCache(Cfg.CacheMaxEntries, ttl)
+		}
+		LogInfo("[Cache] 响应缓存已启用: backend=%s, ttl=%v", Cfg.CacheBackend, ttl)
+	})
+	return responseCache
+}
+
+// CacheKey 根据规范化后的请求生成缓存键（stream、user 等不影响结果的字段不参与计算）
+// 缓存按调用方隔离，请求可能引用只有调用方自己可见的文件
+func CacheKey(req *ChatRequest, owner string, policy *FeaturePolicy, citationMode string) string {
+	normalized := struct {
+		Owner            string            `json:"owner"`
+		Model            string            `json:"model"`
+		Messages         []Message         `json:"messages"`
+		Tools            []Tool            `json:"tools,omitempty"`
+		ToolChoice       interface{}       `json:"tool_choice,omitempty"`
+		Temperature      *float64          `json:"temperature,omitempty"`
+		TopP             *float64          `json:"top_p,omitempty"`
+		MaxTokens        *int              `json:"max_tokens,omitempty"`
+		PresencePenalty  *float64          `json:"presence_penalty,omitempty"`
+		FrequencyPenalty *float64          `json:"frequency_penalty,omitempty"`
+		Stop             interface{}       `json:"stop,omitempty"`
+		Seed             *int              `json:"seed,omitempty"`
+		WebSearchOptions *WebSearchOptions `json:"web_search_options,omitempty"`
+		MCPServers       []string          `json:"mcp_servers,omitempty"`
+		Features         *RequestFeatures  `json:"features,omitempty"`
+		Policy           string            `json:"policy,omitempty"`
+		CitationMode     string            `json:"citation_mode,omitempty"`
+	}{
+		Owner:            owner,
+		Model:            strings.TrimSpace(req.Model),
+		Messages:         req.Messages,
+		Tools:            req.Tools,
+		ToolChoice:       req.ToolChoice,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		MaxTokens:        req.MaxTokens,
+		PresencePenalty:  req.PresencePenalty,
+		FrequencyPenalty: req.FrequencyPenalty,
+		Stop:             req.Stop,
+		Seed:             req.Seed,
+		WebSearchOptions: req.WebSearchOptions,
+		MCPServers:       req.MCPServers,
+		Features:         req.Features,
+		Policy:           policy.String(),
+		CitationMode:     strings.ToLower(citationMode),
+	}
+	data, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IsCacheBypassed 检查请求是否要求跳过缓存
+func IsCacheBypassed(r *http.Request) bool {
+	if v := strings.ToLower(r.Header.Get("X-Cache-Bypass")); v == "1" || v == "true" || v == "yes" {
+		return true
+	}
+	cc := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store")
+}
+
+// IsCacheable 检查请求结果是否可复用：temperature 为 0 或指定了 seed 的请求视为确定性请求，
+// 其余请求需通过 X-Cache-Force: true 显式要求缓存
+func IsCacheable(r *http.Request, req *ChatRequest) bool {
+	if v := strings.ToLower(r.Header.Get("X-Cache-Force")); v == "1" || v == "true" || v == "yes" {
+		return true
+	}
+	return req.Seed != nil || (req.Temperature != nil && *req.Temperature == 0)
+}
+
+// memoryCache 带 TTL 的内存 LRU 缓存
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     *CachedCompletion
+	expiresAt time.Time
+}
+
+func newMemoryCache(maxEntries int, ttl time.Duration) *memoryCache {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &memoryCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) Get(key string) (*CachedCompletion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expiresAt) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(key string, value *CachedCompletion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*memoryCacheEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&memoryCacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// diskCache 磁盘缓存，每个条目一个 JSON 文件，超出条目上限时淘汰最久未访问的条目
+type diskCache struct {
+	dir        string
+	ttl        time.Duration
+	maxEntries int
+	entries    int64 // 当前条目数
+	setCount   int64
+	sweeping   int32
+}
+
+type diskCacheEntry struct {
+	ExpiresAt int64             `json:"expires_at"`
+	Value     *CachedCompletion `json:"value"`
+}
+
+// diskCacheSweepEvery 每写入多少次清理一次过期文件
+const diskCacheSweepEvery = 100
+
+func newDiskCache(dir string, maxEntries int, ttl time.Duration) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	c := &diskCache{dir: dir, ttl: ttl, maxEntries: maxEntries}
+	c.sweep()
+	return c, nil
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *diskCache) Get(key string) (*CachedCompletion, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Value == nil {
+		c.remove(key)
+		return nil, false
+	}
+	if c.ttl > 0 && time.Now().Unix() > entry.ExpiresAt {
+		c.remove(key)
+		return nil, false
+	}
+	// 修改时间作为最近访问时间，淘汰时使用
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	return entry.Value, true
+}
+
+func (c *diskCache) remove(key string) {
+	if err := os.Remove(c.path(key)); err == nil {
+		atomic.AddInt64(&c.entries, -1)
+	}
+}
+
+func (c *diskCache) Set(key string, value *CachedCompletion) {
+	data, err := json.Marshal(diskCacheEntry{
+		ExpiresAt: time.Now().Add(c.ttl).Unix(),
+		Value:     value,
+	})
+	if err != nil {
+		return
+	}
+	_, statErr := os.Stat(c.path(key))
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		LogError("[Cache] 写入缓存失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		LogError("[Cache] 写入缓存失败: %v", err)
+		os.Remove(tmp)
+		return
+	}
+	if statErr != nil {
+		atomic.AddInt64(&c.entries, 1)
+	}
+	overLimit := atomic.LoadInt64(&c.entries) > int64(c.maxEntries)
+	if (atomic.AddInt64(&c.setCount, 1)%diskCacheSweepEvery == 0 || overLimit) && atomic.CompareAndSwapInt32(&c.sweeping, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&c.sweeping, 0)
+			c.sweep()
+		}()
+	}
+}
+
+// sweep 清理过期的缓存文件，超出条目上限时删除最久未访问的条目
+func (c *diskCache) sweep() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type cacheFile struct {
+		key     string
+		modTime time.Time
+	}
+	var files []cacheFile
+	expired := 0
+	now := time.Now().Unix()
+	for _, e := range dirEntries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		key := strings.TrimSuffix(e.Name(), ".json")
+		data, err := os.ReadFile(c.path(key))
+		if err != nil {
+			continue
+		}
+		var entry diskCacheEntry
+		if json.Unmarshal(data, &entry) != nil || entry.Value == nil || (c.ttl > 0 && now > entry.ExpiresAt) {
+			os.Remove(c.path(key))
+			expired++
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{key: key, modTime: info.ModTime()})
+	}
+
+	evicted := 0
+	if len(files) > c.maxEntries {
+		sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+		for _, f := range files[:len(files)-c.maxEntries] {
+			os.Remove(c.path(f.key))
+			evicted++
+		}
+		files = files[len(files)-c.maxEntries:]
+	}
+	atomic.StoreInt64(&c.entries, int64(len(files)))
+	if expired > 0 || evicted > 0 {
+		LogDebug("[Cache] 已清理 %d 个过期缓存，淘汰 %d 个条目", expired, evicted)
+	}
+}
+
+// cacheRecorder 在写入客户端的同时记录响应体，用于写入缓存
+type cacheRecorder struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (r *cacheRecorder) Write(p []byte) (int, error) {
+	r.buf.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *cacheRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// assembleCachedCompletion 从已写出的响应体还原完整结果
+func assembleCachedCompletion(data []byte, stream bool) (*CachedCompletion, bool) {
+	if !stream {
+		var resp ChatCompletionResponse
+		if err := json.Unmarshal(data, &resp); err != nil || len(resp.Choices) == 0 || resp.Choices[0].Message == nil {
+			return nil, false
+		}
+		cached := &CachedCompletion{
+			Model:     resp.Model,
+			Message:   *resp.Choices[0].Message,
+			CreatedAt: time.Now().Unix(),
+		}
+		if resp.Choices[0].FinishReason != nil {
+			cached.FinishReason = *resp.Choices[0].FinishReason
+		}
+		if resp.Usage != nil {
+			cached.Usage = *resp.Usage
+		}
+		return cached, true
+	}
+
+	cached := &CachedCompletion{
+		Message:   MessageResp{Role: "assistant"},
+		CreatedAt: time.Now().Unix(),
+	}
+	var content, reasoning strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			break
+		}
+		var chunk ChatCompletionChunkResponse
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			continue
+		}
+		cached.Model = chunk.Model
+		if chunk.Usage != nil {
+			cached.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				cached.FinishReason = *choice.FinishReason
+			}
+			if choice.Delta == nil {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			reasoning.WriteString(choice.Delta.ReasoningContent)
+			for _, tc := range choice.Delta.ToolCalls {
+				tc.Index = 0
+				cached.Message.ToolCalls = append(cached.Message.ToolCalls, tc)
+			}
+			cached.Message.Annotations = append(cached.Message.Annotations, choice.Delta.Annotations...)
+			cached.Message.Attachments = append(cached.Message.Attachments, choice.Delta.Attachments...)
+			cached.Message.Images = append(cached.Message.Images, choice.Delta.Images...)
+		}
+	}
+	if cached.FinishReason == "" {
+		return nil, false
+	}
+	cached.Message.Content = content.String()
+	cached.Message.ReasoningContent = reasoning.String()
+	return cached, true
+}
+
+// writeCachedCompletion 回放缓存结果，stream 时按 SSE 格式逐块输出
+func writeCachedCompletion(w http.ResponseWriter, cached *CachedCompletion, completionID string, stream, includeUsage bool) {
+	finishReason := cached.FinishReason
+	created := time.Now().Unix()
+	usage := cached.Usage
+
+	if !stream {
+		message := cached.Message
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", completionID)
+		json.NewEncoder(w).Encode(ChatCompletionResponse{
+			ID:      completionID,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   cached.Model,
+			Choices: []Choice{{
+				Index:        0,
+				Message:      &message,
+				FinishReason: &finishReason,
+			}},
+			Usage:             &usage,
+			SystemFingerprint: "openai",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	writeChunk := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	newChunk := func(delta *Delta, finish *string) ChatCompletionChunk {
+		return ChatCompletionChunk{
+			ID:      completionID,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   cached.Model,
+			Choices: []Choice{{Index: 0, Delta: delta, FinishReason: finish}},
+		}
+	}
+
+	writeChunk(newChunk(&Delta{Role: "assistant"}, nil))
+	if cached.Message.ReasoningContent != "" {
+		writeChunk(newChunk(&Delta{ReasoningContent: cached.Message.ReasoningContent}, nil))
+	}
+	if len(cached.Message.Attachments) > 0 {
+		writeChunk(newChunk(&Delta{Attachments: cached.Message.Attachments}, nil))
+	}
+	if cached.Message.Content != "" || len(cached.Message.Annotations) > 0 || len(cached.Message.Images) > 0 {
+		writeChunk(newChunk(&Delta{Content: cached.Message.Content, Annotations: cached.Message.Annotations, Images: cached.Message.Images}, nil))
+	}
+	for i, tc := range cached.Message.ToolCalls {
+		tc.Index = i
+		writeChunk(newChunk(&Delta{ToolCalls: []ToolCall{tc}}, nil))
+	}
+	writeChunk(newChunk(&Delta{}, &finishReason))
+	if includeUsage {
+		writeChunk(ChatCompletionChunkResponse{
+			ID:      completionID,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   cached.Model,
+			Choices: []Choice{},
+			Usage:   &usage,
+		})
+	}
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	zero, warm := 0.0, 0.7
+	seed, otherSeed := 1, 2
+	base := func() *ChatRequest {
+		return &ChatRequest{Model: "GLM-4.7", Messages: []Message{{Role: "user", Content: "你好"}}, Temperature: &zero}
+	}
+	baseKey := CacheKey(base(), "owner", nil, "")
+
+	tests := []struct {
+		name   string
+		mutate func(req *ChatRequest)
+		owner  string
+		same   bool
+	}{
+		{name: "identical", owner: "owner", same: true},
+		{name: "stream and user ignored", mutate: func(r *ChatRequest) { r.Stream = true; r.User = "u" }, owner: "owner", same: true},
+		{name: "model whitespace ignored", mutate: func(r *ChatRequest) { r.Model = " GLM-4.7 " }, owner: "owner", same: true},
+		{name: "different owner", owner: "other"},
+		{name: "different message", mutate: func(r *ChatRequest) { r.Messages[0].Content = "再见" }, owner: "owner"},
+		{name: "different temperature", mutate: func(r *ChatRequest) { r.Temperature = &warm }, owner: "owner"},
+		{name: "seed set", mutate: func(r *ChatRequest) { r.Seed = &seed }, owner: "owner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base()
+			if tt.mutate != nil {
+				tt.mutate(req)
+			}
+			if got := CacheKey(req, tt.owner, nil, "") == baseKey; got != tt.same {
+				t.Errorf("key equal = %v, want %v", got, tt.same)
+			}
+		})
+	}
+
+	a, b := base(), base()
+	a.Seed, b.Seed = &seed, &otherSeed
+	if CacheKey(a, "owner", nil, "") == CacheKey(b, "owner", nil, "") {
+		t.Error("requests with different seeds share a cache key")
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	zero, warm := 0.0, 0.7
+	seed := 42
+	tests := []struct {
+		name        string
+		temperature *float64
+		seed        *int
+		force       string
+		want        bool
+	}{
+		{name: "default temperature", want: false},
+		{name: "nonzero temperature", temperature: &warm, want: false},
+		{name: "zero temperature", temperature: &zero, want: true},
+		{name: "seed", temperature: &warm, seed: &seed, want: true},
+		{name: "forced", temperature: &warm, force: "true", want: true},
+		{name: "force disabled", force: "0", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			if tt.force != "" {
+				r.Header.Set("X-Cache-Force", tt.force)
+			}
+			req := &ChatRequest{Temperature: tt.temperature, Seed: tt.seed}
+			if got := IsCacheable(r, req); got != tt.want {
+				t.Errorf("IsCacheable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// responseBuffer 内存中的 http.ResponseWriter，用于在服务端内部复用响应处理逻辑
+type responseBuffer struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	b.statusCode = code
+}
+
+func (b *responseBuffer) Flush() {}
+
+// newInternalCaller 以已认证的调用方身份发起内部请求
+func newInternalCaller(r *http.Request, apiKey string) *internalCaller {
+	return &internalCaller{apiKey: apiKey, owner: requestOwner(r, apiKey)}
+}
+
+// newInternalChatRequest 构造内部对话请求：沿用原请求的请求头和 context，以调用方身份跳过重复认证
+func newInternalChatRequest(r *http.Request, caller *internalCaller, chatReq *ChatRequest) *http.Request {
+	payload, _ := json.Marshal(chatReq)
+	req := r.Clone(context.WithValue(r.Context(), internalCallerKey{}, caller))
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+	req.ContentLength = int64(len(payload))
+	return req
+}
+
+// copyResponseHeaders 复制内部响应的附加响应头（X-Served-Model 等），内容类型由调用方决定
+func copyResponseHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if k == "Content-Type" || k == "Content-Length" {
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// writeBufferedError 内部请求失败时原样返回错误响应
+func writeBufferedError(w http.ResponseWriter, buf *responseBuffer) bool {
+	if buf.statusCode == http.StatusOK {
+		return false
+	}
+	copyResponseHeaders(w.Header(), buf.header)
+	w.Header().Set("Content-Type", buf.header.Get("Content-Type"))
+	w.WriteHeader(buf.statusCode)
+	w.Write(buf.body.Bytes())
+	return true
+}
+
+// chatStreamWriter 解析对话接口输出的 SSE，逐个 chunk 交给 onChunk 转换为其他协议的流式格式。
+// 非流式响应（如错误）原样透传。
+type chatStreamWriter struct {
+	w            http.ResponseWriter
+	header       http.Header
+	contentType  string
+	onChunk      func(chunk *ChatCompletionChunkResponse)
+	onDone       func()
+	onData       func(payload []byte)                 // 可选：直接接收原始 chunk JSON，设置后不再调用 onChunk
+	onError      func(payload []byte)                 // 可选：接收流中途的错误事件（之后仍会收到 [DONE]）
+	convertError func(status int, body []byte) []byte // 可选：转换透传的错误响应
+	status       int
+	buf          []byte
+	wroteHeader  bool
+	passthrough  bool
+}
+
+func newChatStreamWriter(w http.ResponseWriter, contentType string, onChunk func(*ChatCompletionChunkResponse), onDone func()) *chatStreamWriter {
+	return &chatStreamWriter{w: w, header: make(http.Header), contentType: contentType, onChunk: onChunk, onDone: onDone}
+}
+
+func (sw *chatStreamWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *chatStreamWriter) WriteHeader(code int) {
+	if sw.wroteHeader {
+		return
+	}
+	sw.wroteHeader = true
+	sw.status = code
+	sw.passthrough = code != http.StatusOK || !strings.HasPrefix(sw.header.Get("Content-Type"), "text/event-stream")
+	copyResponseHeaders(sw.w.Header(), sw.header)
+	if sw.passthrough {
+		sw.w.Header().Set("Content-Type", sw.header.Get("Content-Type"))
+	} else {
+		sw.w.Header().Set("Content-Type", sw.contentType)
+	}
+	sw.w.WriteHeader(code)
+}
+
+func (sw *chatStreamWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.passthrough {
+		if sw.convertError != nil {
+			sw.w.Write(sw.convertError(sw.status, p))
+			return len(p), nil
+		}
+		return sw.w.Write(p)
+	}
+	sw.buf = append(sw.buf, p...)
+	for {
+		i := bytes.Index(sw.buf, []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		event := string(sw.buf[:i])
+		sw.buf = sw.buf[i+2:]
+		sw.handleEvent(event)
+	}
+	return len(p), nil
+}
+
+func (sw *chatStreamWriter) Flush() {
+	if flusher, ok := sw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *chatStreamWriter) handleEvent(event string) {
+	for _, line := range strings.Split(event, "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			sw.onDone()
+			continue
+		}
+		if sw.onError != nil && isStreamErrorEvent([]byte(payload)) {
+			sw.onError([]byte(payload))
+			continue
+		}
+		if sw.onData != nil {
+			sw.onData([]byte(payload))
+			continue
+		}
+		var chunk ChatCompletionChunkResponse
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			continue
+		}
+		sw.onChunk(&chunk)
+	}
+}
+
+// isStreamErrorEvent 判断 SSE 数据是否为 writeStreamError 写出的错误事件
+func isStreamErrorEvent(payload []byte) bool {
+	var event struct {
+		Error *APIError `json:"error"`
+	}
+	return json.Unmarshal(payload, &event) == nil && event.Error != nil
+}
@@ -3,7 +3,9 @@ package internal
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -43,6 +45,7 @@ const (
 	ErrTypeNotFound       = "not_found_error"
 	ErrTypeServer         = "server_error"
 	ErrTypeUpstream       = "upstream_error"
+	ErrTypeRateLimit      = "rate_limit_error"
 )
 
 // writeError 写入错误响应
@@ -58,6 +61,20 @@ func writeError(w http.ResponseWriter, statusCode int, errType, message, code st
 	})
 }
 
+// writeStreamError 流式响应已开始后以错误事件结束流
+func writeStreamError(w http.ResponseWriter, flusher http.Flusher, errType, message, code string) {
+	data, _ := json.Marshal(ErrorResponse{
+		Error: APIError{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
 // writeErrorResponse 统一错误响应（通用请求失败）
 func writeErrorResponse(w http.ResponseWriter, statusCode int) {
 	writeError(w, statusCode, ErrTypeServer, "请求失败", "")
@@ -120,7 +137,8 @@ func extractAllMediaURLs(messages []Message) (imageURLs, videoURLs []string) {
 	return imageURLs, videoURLs
 }
 
-func makeUpstreamRequest(token string, messages []Message, model string, imageURLs, videoURLs []string, hasTools bool) (*http.Response, string, error) {
+// makeUpstreamRequest 发起上游请求；chat 不为 nil 时复用或记录上游对话标识
+func makeUpstreamRequest(token string, messages []Message, model string, uploads []UploadResult, hasTools bool, opts *FeatureOptions, chat *upstreamChat) (*http.Response, string, error) {
 	payload, err := DecodeJWTPayload(token)
 	if err != nil || payload == nil {
 		return nil, "", fmt.Errorf("invalid token")
@@ -128,45 +146,21 @@ func makeUpstreamRequest(token string, messages []Message, model string, imageUR
 
 	userID := payload.ID
 	chatID := uuid.New().String()
+	if chat != nil && chat.ChatID != "" {
+		chatID = chat.ChatID
+	}
 	timestamp := time.Now().UnixMilli()
 	requestID := uuid.New().String()
 	userMsgID := uuid.New().String()
+	messageID := uuid.New().String()
 
-	// 使用新的模型映射系统
 	mapping := GetUpstreamConfig(model)
-	var targetModel string
-	var enableThinking, autoWebSearch bool
-	var mcpServers []string
-
-	if mapping != nil {
-		targetModel = mapping.UpstreamModelID
-		enableThinking = mapping.EnableThinking
-		autoWebSearch = mapping.AutoWebSearch
-		mcpServers = mapping.MCPServers
-		LogDebug("Model mapping: %s -> %s (thinking=%v, search=%v)", model, targetModel, enableThinking, autoWebSearch)
-	} else {
-		// 回退到老的逻辑
-		targetModel = GetTargetModel(model)
-		enableThinking = IsThinkingModel(model)
-		autoWebSearch = IsSearchModel(model)
-		LogDebug("Using fallback model mapping: %s -> %s", model, targetModel)
-	}
-
-	if targetModel == "glm-4.5v" || targetModel == "glm-4.6v" {
-		autoWebSearch = false
-	}
-
-	// 所有请求添加图片处理MCP服务器
-	vlmServers := []string{"vlm-image-search", "vlm-image-recognition", "vlm-image-processing"}
-	existingSet := make(map[string]bool)
-	for _, s := range mcpServers {
-		existingSet[s] = true
-	}
-	for _, s := range vlmServers {
-		if !existingSet[s] {
-			mcpServers = append(mcpServers, s)
-		}
+	if mapping == nil {
+		return nil, "", fmt.Errorf("model not found: %s", model)
 	}
+	targetModel := mapping.UpstreamModelID
+	features := resolveUpstreamFeatures(mapping, opts, hasTools)
+	LogDebug("Model mapping: %s -> %s (thinking=%v, search=%v, mcp=%v)", model, targetModel, features.EnableThinking, features.AutoWebSearch, features.MCPServers)
 
 	latestUserContent := extractLatestUserContent(messages)
 
@@ -181,54 +175,26 @@ func makeUpstreamRequest(token string, messages []Message, model string, imageUR
 	urlToFileID := make(map[string]string)
 	var filesData []map[string]interface{}
 
-	// 上传图片
-	if len(imageURLs) > 0 {
-		LogDebug("[Upstream] Uploading %d images...", len(imageURLs))
-		imageFiles, _ := UploadImages(token, imageURLs)
-		LogDebug("[Upstream] Image upload result: %d files", len(imageFiles))
-		for i, f := range imageFiles {
-			if i < len(imageURLs) {
-				urlToFileID[imageURLs[i]] = f.ID
-			}
-			filesData = append(filesData, map[string]interface{}{
-				"type":            f.Type,
-				"file":            f.File,
-				"id":              f.ID,
-				"url":             f.URL,
-				"name":            f.Name,
-				"status":          f.Status,
-				"size":            f.Size,
-				"error":           f.Error,
-				"itemId":          f.ItemID,
-				"media":           f.Media,
-				"ref_user_msg_id": userMsgID,
-			})
-		}
-	}
-
-	// 上传视频
-	if len(videoURLs) > 0 {
-		LogDebug("[Upstream] Uploading %d videos...", len(videoURLs))
-		videoFiles, _ := UploadVideos(token, videoURLs)
-		LogDebug("[Upstream] Video upload result: %d files", len(videoFiles))
-		for i, f := range videoFiles {
-			if i < len(videoURLs) {
-				urlToFileID[videoURLs[i]] = f.ID
-			}
-			filesData = append(filesData, map[string]interface{}{
-				"type":            f.Type,
-				"file":            f.File,
-				"id":              f.ID,
-				"url":             f.URL,
-				"name":            f.Name,
-				"status":          f.Status,
-				"size":            f.Size,
-				"error":           f.Error,
-				"itemId":          f.ItemID,
-				"media":           f.Media,
-				"ref_user_msg_id": userMsgID,
-			})
+	// 附加已上传的图片和视频
+	for _, u := range uploads {
+		f := u.File
+		if f == nil {
+			continue
 		}
+		urlToFileID[u.URL] = f.ID
+		filesData = append(filesData, map[string]interface{}{
+			"type":            f.Type,
+			"file":            f.File,
+			"id":              f.ID,
+			"url":             f.URL,
+			"name":            f.Name,
+			"status":          f.Status,
+			"size":            f.Size,
+			"error":           f.Error,
+			"itemId":          f.ItemID,
+			"media":           f.Media,
+			"ref_user_msg_id": userMsgID,
+		})
 	}
 	var upstreamMessages []map[string]interface{}
 	for _, msg := range messages {
@@ -242,19 +208,29 @@ func makeUpstreamRequest(token string, messages []Message, model string, imageUR
 		"signature_prompt": latestUserContent,
 		"params":           map[string]interface{}{},
 		"features": map[string]interface{}{
-			"image_generation": true,
-			"web_search":       true,
-			"auto_web_search":  autoWebSearch && !hasTools,
-			"preview_mode":     false,
+			"image_generation": features.ImageGeneration,
+			"web_search":       features.WebSearch,
+			"auto_web_search":  features.AutoWebSearch,
+			"preview_mode":     features.PreviewMode,
 			"flags":            []string{},
-			"enable_thinking":  enableThinking,
+			"enable_thinking":  features.EnableThinking,
 		},
 		"chat_id": chatID,
-		"id":      uuid.New().String(),
+		"id":      messageID,
+	}
+
+	if chat != nil {
+		chat.ChatID = chatID
+		chat.UserMsgID = userMsgID
+		chat.MessageID = messageID
+		body["current_user_message_id"] = userMsgID
+		if chat.ParentID != "" {
+			body["current_user_message_parent_id"] = chat.ParentID
+		}
 	}
 
-	if len(mcpServers) > 0 {
-		body["mcp_servers"] = mcpServers
+	if len(features.MCPServers) > 0 {
+		body["mcp_servers"] = features.MCPServers
 	}
 
 	if len(filesData) > 0 {
@@ -446,13 +422,29 @@ func (f *ThinkingFilter) ResetForNewRound() {
 	f.hasSeenFirstThinking = false
 }
 
-func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
-	// 只接受 POST 请求
-	if r.Method != http.MethodPost {
-		writeInvalidRequestError(w, "Only POST method is allowed")
-		return
+// acquireToken 获取上游 token：优先 TokenManager，其次备用 token，最后匿名 token
+func acquireToken() (string, error) {
+	if tmToken := GetTokenManager().GetToken(); tmToken != "" {
+		LogDebug("Using token from TokenManager")
+		return tmToken, nil
+	}
+	if backupToken := GetBackupToken(); backupToken != "" {
+		LogDebug("Using backup token")
+		return backupToken, nil
 	}
+	anonymousToken, err := GetAnonymousToken()
+	if err != nil {
+		return "", err
+	}
+	LogDebug("Using anonymous token: %s...", anonymousToken[:min(10, len(anonymousToken))])
+	return anonymousToken, nil
+}
 
+// authenticateRequest 校验 Authorization 中的 API Key，失败时写入 401 响应
+func authenticateRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if caller, ok := r.Context().Value(internalCallerKey{}).(*internalCaller); ok {
+		return caller.apiKey, true
+	}
 	apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 
 	// API Key 认证
@@ -460,41 +452,40 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		if apiKey == "" {
 			LogDebug("Missing Authorization header")
 			writeError(w, http.StatusUnauthorized, ErrTypeAuthentication, "Missing or invalid Authorization header", "invalid_api_key")
-			return
+			return "", false
 		}
 		// 验证 API Key
 		if !ValidateAuthToken(apiKey) {
 			LogDebug("Invalid API key: %s...", apiKey[:min(8, len(apiKey))])
 			writeError(w, http.StatusUnauthorized, ErrTypeAuthentication, "Invalid API key", "invalid_api_key")
-			return
+			return "", false
 		}
 		LogDebug("API key validated: %s...", apiKey[:min(8, len(apiKey))])
 	} else {
 		LogDebug("SKIP_AUTH_TOKEN enabled, skipping API key validation")
 	}
-	clientIP := GetClientIP(r)
-	isMultimodal := false
+	return apiKey, true
+}
 
-	var token string
-	// 优先使用 TokenManager 中的 token
-	if tmToken := GetTokenManager().GetToken(); tmToken != "" {
-		token = tmToken
-		LogDebug("Using token from TokenManager")
-	} else if backupToken := GetBackupToken(); backupToken != "" {
-		token = backupToken
-		LogDebug("Using backup token")
-	} else {
-		anonymousToken, err := GetAnonymousToken()
-		if err != nil {
-			LogError("Failed to get anonymous token: %v", err)
-			GetTokenManager().RecordCall(false, false)
-			writeErrorResponse(w, http.StatusInternalServerError)
-			return
-		}
-		token = anonymousToken
-		LogDebug("Using anonymous token: %s...", token[:min(10, len(token))])
+func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	// 只接受 POST 请求
+	if r.Method != http.MethodPost {
+		writeInvalidRequestError(w, "Only POST method is allowed")
+		return
 	}
 
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	caller, isInternal := r.Context().Value(internalCallerKey{}).(*internalCaller)
+	// 断线重连：Last-Event-ID 指向仍在缓冲的响应时直接续传
+	if !isInternal && resumeFromLastEventID(w, r, requestOwner(r, apiKey)) {
+		return
+	}
+	clientIP := GetClientIP(r)
+	isMultimodal := false
+
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeInvalidRequestError(w, "无效的请求格式")
@@ -505,13 +496,144 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		req.Model = "GLM-4.6"
 	}
 
+	// 解析模型别名
+	if resolved := ResolveModelAlias(req.Model); resolved != req.Model {
+		LogDebug("Model alias: %s -> %s", req.Model, resolved)
+		req.Model = resolved
+	}
+
 	// 验证模型是否存在
 	if !IsValidModel(req.Model) {
 		writeModelNotFoundError(w, req.Model)
 		return
 	}
+
+	// 全局并发限制（会话重建沿用已获取的名额）
+	if _, replay := r.Context().Value(sessionReplayKey{}).(*sessionTurn); !replay {
+		release, ok := acquireRequestSlot(w, r, requestOwner(r, apiKey))
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	completionID := fmt.Sprintf("chatcmpl-%s", uuid.New().String()[:29])
+	// 可续传的流式响应：客户端断开后上游继续生成，重连时从回放缓冲区续传；
+	// 超过 STREAM_RESUME_GRACE 无人续传时中止
+	if resumable, ok := w.(*resumableWriter); ok {
+		// 会话重建时沿用已返回给客户端的 ID
+		completionID = resumable.stream.id
+	} else if store := getStreamStore(); store != nil && req.Stream && !isInternal {
+		rs := store.start(completionID, requestOwner(r, apiKey))
+		defer store.finish(rs)
+		w = newResumableWriter(w, rs)
+		ctx, cancel := rs.outliveClient(r.Context(), time.Duration(Cfg.StreamResumeGrace)*time.Second)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	citationMode := Cfg.CitationMode
+	if isInternal && caller.citationMode != "" {
+		citationMode = caller.citationMode
+	}
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	featureOpts := &FeatureOptions{
+		WebSearch:  req.WebSearchOptions,
+		MCPServers: req.MCPServers,
+		Features:   req.Features,
+		Policy:     GetFeaturePolicy(apiKey),
+	}
+
+	// 会话模式：复用上游对话，只发送新消息（批处理请求彼此独立，不使用会话）
+	owner := requestOwner(r, apiKey)
+	var turn *sessionTurn
+	if replay, ok := r.Context().Value(sessionReplayKey{}).(*sessionTurn); ok {
+		turn = replay
+	} else if store := getSessionStore(); store != nil && !(isInternal && caller.background) {
+		if key, explicit := sessionKey(r, &req); key != "" {
+			turn = store.begin(owner, key, req.Model, req.Messages, explicit)
+			defer turn.Release()
+		}
+	}
+	if turn != nil {
+		req.Messages = turn.Messages()
+		w.Header().Set("X-Session", turn.State)
+		LogDebug("[Session] state=%s, history=%d, new=%d", turn.State, len(turn.History), len(turn.New))
+	}
+
+	// 响应缓存（会话请求不使用缓存）
+	var cacheKey string
+	cache := GetResponseCache()
+	if cache != nil && turn == nil {
+		if IsCacheBypassed(r) {
+			w.Header().Set("X-Cache", "BYPASS")
+			cache = nil
+		} else if !IsCacheable(r, &req) {
+			w.Header().Set("X-Cache", "SKIP")
+			cache = nil
+		} else {
+			cacheKey = CacheKey(&req, owner, featureOpts.Policy, citationMode)
+			if cached, ok := cache.Get(cacheKey); ok {
+				RecordCacheLookup(true)
+				w.Header().Set("X-Cache", "HIT")
+				writeCachedCompletion(w, cached, completionID, req.Stream, includeUsage)
+				RecordRequest(cached.Usage.PromptTokens, cached.Usage.CompletionTokens, req.Model)
+				LogDebug("Cache hit: model=%s, key=%s, ip=%s", req.Model, cacheKey[:12], clientIP)
+				return
+			}
+			RecordCacheLookup(false)
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	// 解析文档附件：不需上传的文档在此提取为文本，参与上下文长度计算
+	docMessages, documents, err := prepareDocuments(req.Messages, owner)
+	if err != nil {
+		LogWarn("[Document] %v", err)
+		code := "invalid_file"
+		if errors.Is(err, ErrFileNotFound) {
+			code = "file_not_found"
+		} else if errors.Is(err, ErrMediaTooLarge) {
+			code = "media_too_large"
+		}
+		writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, err.Error(), code)
+		return
+	}
+	req.Messages = docMessages
+
+	token := ""
+	if turn != nil {
+		token = turn.Token()
+	}
+	if token == "" {
+		token, err = acquireToken()
+	}
+	if err != nil {
+		LogError("Failed to get anonymous token: %v", err)
+		GetTokenManager().RecordCall(false, false)
+		writeErrorResponse(w, http.StatusInternalServerError)
+		return
+	}
+	// 上下文窗口管理
+	messages, ctxResult, err := ApplyContextStrategy(token, &req)
+	if ctxResult != nil {
+		w.Header().Set("X-Context-Management", ctxResult.HeaderValue())
+		LogInfo("[Context] model=%s %s", req.Model, ctxResult.HeaderValue())
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, err.Error(), "context_length_exceeded")
+		return
+	}
+
 	// 检测多模态
-	reqImageURLs, reqVideoURLs := extractAllMediaURLs(req.Messages)
+	reqImageURLs, reqVideoURLs := extractAllMediaURLs(messages)
+	for _, u := range append(append([]string{}, reqImageURLs...), reqVideoURLs...) {
+		if isFileID(u) {
+			if _, err := getFileStore().get(u, owner); err != nil {
+				writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, fmt.Sprintf("文件 '%s' 不存在", u), "file_not_found")
+				return
+			}
+		}
+	}
 	if len(reqImageURLs) > 0 || len(reqVideoURLs) > 0 {
 		isMultimodal = true
 		LogDebug("[Request] Multimodal detected: images=%d, videos=%d", len(reqImageURLs), len(reqVideoURLs))
@@ -532,7 +654,6 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 处理工具调用
-	messages := req.Messages
 	if len(req.Tools) > 0 {
 		messages = ProcessMessagesWithTools(messages, req.Tools, req.ToolChoice)
 	}
@@ -541,29 +662,101 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 	LogDebug("Chat request: model=%s, messages=%d, stream=%v, input_tokens=%d, ip=%s, multimodal=%v, tools=%d",
 		req.Model, len(messages), req.Stream, inputTokens, clientIP, isMultimodal, len(req.Tools))
 
-	completionID := fmt.Sprintf("chatcmpl-%s", uuid.New().String()[:29])
-	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+	var recorder *cacheRecorder
+	rw := w
+	if cache != nil || turn != nil {
+		recorder = &cacheRecorder{ResponseWriter: w}
+		w = recorder
+	}
 
 	var outputTokens int64
 	var lastError string
+	var chat *upstreamChat
 	success := false
+	streamStarted := false
+	sessionLost := false
+
+	// 降级链：每个模型最多重试 MaxRetries 次，仍失败时切换到后续模型
+	chain := GetFallbackChain(req.Model)
+	chainIdx := 0
+	servedModel := chain[0]
+	modelAttempts := 0 // 当前模型已尝试次数
+	skipModel := false // 非 5xx 错误重试同一模型无意义，直接切换
+	maxAttempts := len(chain) * (MaxRetries + 1)
 
 	// 重试循环
-	for attempt := 0; attempt <= MaxRetries; attempt++ {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if err := r.Context().Err(); err != nil {
+			lastError = "request canceled"
+			break
+		}
+		if cutoffCtx.Err() != nil {
+			lastError = "server shutting down"
+			break
+		}
 		if attempt > 0 {
+			if skipModel || modelAttempts > MaxRetries {
+				if chainIdx+1 >= len(chain) {
+					break
+				}
+				chainIdx++
+				modelAttempts = 0
+				skipModel = false
+				LogInfo("Fallback %s -> %s", servedModel, chain[chainIdx])
+			}
 			// 重试时获取新 token
 			if newToken := GetTokenManager().GetToken(); newToken != "" && newToken != token {
 				token = newToken
-				LogInfo("Retry %d/%d with new token", attempt, MaxRetries)
+				LogInfo("Retry %d/%d with new token", attempt, maxAttempts-1)
 			} else {
-				LogInfo("Retry %d/%d with same token", attempt, MaxRetries)
+				LogInfo("Retry %d/%d with same token", attempt, maxAttempts-1)
+			}
+		}
+		servedModel = chain[chainIdx]
+		modelAttempts++
+
+		// 上传媒体（文件 ID 与 token 绑定，换 token 后需重新上传，相同内容会命中上传缓存）
+		var uploads []UploadResult
+		if isMultimodal {
+			uploads = UploadMediaResults(token, owner, reqImageURLs, reqVideoURLs)
+		}
+		// 上传文档，失败时回退到本地提取的文本
+		attemptMessages := messages
+		if len(documents) > 0 {
+			docUploads := UploadDocuments(token, owner, documents)
+			attemptMessages = applyDocumentUploads(messages, documents, docUploads)
+			uploads = append(uploads, docUploads...)
+		}
+		if failed := failedUploads(uploads); len(failed) > 0 {
+			warning := describeUploadFailures(uploads)
+			if Cfg.MediaStrict {
+				LogWarn("[Upload] Rejecting request in strict mode: %s", warning)
+				GetTokenManager().RecordCall(false, isMultimodal)
+				code := "media_upload_failed"
+				if errors.Is(failed[0].Err, ErrMediaTooLarge) {
+					code = "media_too_large"
+				} else if errors.Is(failed[0].Err, ErrFetchBlocked) {
+					code = "media_url_blocked"
+				}
+				writeError(w, http.StatusBadRequest, ErrTypeInvalidRequest, "媒体处理失败: "+warning, code)
+				return
 			}
+			LogWarn("[Upload] %s", warning)
+			w.Header().Set("X-Media-Warning", warning)
 		}
 
-		resp, modelName, err := makeUpstreamRequest(token, messages, req.Model, reqImageURLs, reqVideoURLs, len(req.Tools) > 0)
+		if turn != nil {
+			chat = turn.Chat()
+		}
+		resp, modelName, err := makeUpstreamRequest(token, attemptMessages, servedModel, uploads, len(req.Tools) > 0, featureOpts, chat)
 		if err != nil {
 			LogError("Upstream request failed (attempt %d): %v", attempt+1, err)
 			lastError = err.Error()
+			recordUpstreamResult(lastError)
+			if turn != nil && turn.State == SessionStateContinue {
+				sessionLost = true
+				break
+			}
 			continue
 		}
 
@@ -572,27 +765,43 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 			resp.Body.Close()
 			LogError("Upstream error (attempt %d): status=%d, body=%s", attempt+1, resp.StatusCode, string(body)[:min(500, len(body))])
 			lastError = fmt.Sprintf("status %d", resp.StatusCode)
-			// 非 5xx 错误不重试
-			if resp.StatusCode < 500 {
+			recordUpstreamResult(lastError)
+			if turn != nil && turn.State == SessionStateContinue {
+				sessionLost = true
+				break
+			}
+			// 非 5xx 错误不重试，除非还有降级模型可用
+			if resp.StatusCode < 500 && chainIdx+1 >= len(chain) {
 				GetTokenManager().RecordCall(false, isMultimodal)
 				writeUpstreamError(w, resp.StatusCode, body)
 				return
 			}
+			skipModel = resp.StatusCode < 500
 			continue
 		}
 
+		// 客户端断开或取消请求时关闭上游响应，中止生成
+		stopAbort := context.AfterFunc(r.Context(), func() { resp.Body.Close() })
+		// 服务关闭超过排空期限时同样中断
+		stopCut := context.AfterFunc(cutoffCtx, func() { resp.Body.Close() })
+
+		w.Header().Set("X-Served-Model", servedModel)
 		var result UpstreamResult
 		if req.Stream {
-			result = handleStreamResponseWithRetry(w, resp.Body, completionID, modelName, inputTokens, includeUsage, req.Tools, attempt == 0)
+			result = handleStreamResponseWithRetry(w, resp.Body, completionID, modelName, inputTokens, includeUsage, req.Tools, !streamStarted, Cfg.ImageOutputFormat, citationMode)
+			streamStarted = true
 		} else {
-			result = handleNonStreamResponseWithRetry(w, resp.Body, completionID, modelName, inputTokens, req.Tools)
+			result = handleNonStreamResponseWithRetry(w, resp.Body, completionID, modelName, inputTokens, req.Tools, Cfg.ImageOutputFormat, citationMode)
 		}
+		stopAbort()
+		stopCut()
 		resp.Body.Close()
 
 		outputTokens = result.OutputTokens
 
 		if result.Success && result.HasContent {
 			success = true
+			recordUpstreamResult("")
 			break
 		}
 
@@ -600,18 +809,31 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		if result.ErrorMessage != "" {
 			lastError = result.ErrorMessage
 			LogWarn("Upstream returned error (attempt %d): %s", attempt+1, result.ErrorMessage)
+			recordUpstreamResult(lastError)
 		} else if !result.HasContent {
 			lastError = "empty response"
 			LogWarn("Upstream returned empty content (attempt %d)", attempt+1)
 		}
 
+		if turn != nil && turn.State == SessionStateContinue && !streamStarted {
+			sessionLost = true
+			break
+		}
+
 		// 流式请求已开始写入，无法重试
-		if req.Stream && attempt == 0 {
+		if streamStarted {
 			LogDebug("Stream response already started, cannot retry")
 			break
 		}
 	}
 
+	// 上游对话失效，使用本地保存的完整历史新建对话
+	if sessionLost {
+		LogInfo("[Session] 上游对话不可用 (%s)，使用本地历史重建: history=%d", lastError, len(turn.History))
+		replaySessionTurn(rw, r, &req, turn)
+		return
+	}
+
 	if !success && !req.Stream {
 		// 非流式请求失败，返回错误
 		GetTokenManager().RecordCall(false, isMultimodal)
@@ -619,11 +841,26 @@ func HandleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if success && recorder != nil {
+		if cached, ok := assembleCachedCompletion(recorder.buf.Bytes(), req.Stream); ok {
+			// 媒体处理失败的结果不完整，不写入缓存
+			if cache != nil && w.Header().Get("X-Media-Warning") == "" {
+				if cached.Usage.TotalTokens == 0 {
+					cached.Usage = *NewUsage(inputTokens, outputTokens, CountTokens(cached.Message.ReasoningContent))
+				}
+				cache.Set(cacheKey, cached)
+			}
+			if turn != nil {
+				turn.Commit(req.Model, token, chat, cached.Message)
+			}
+		}
+	}
+
 	// 记录遥测数据
-	RecordRequest(inputTokens, outputTokens, req.Model)
+	RecordRequest(inputTokens, outputTokens, servedModel)
 	GetTokenManager().RecordCall(success, isMultimodal)
-	LogDebug("Chat completed: model=%s, input_tokens=%d, output_tokens=%d, ip=%s, success=%v",
-		req.Model, inputTokens, outputTokens, clientIP, success)
+	LogDebug("Chat completed: model=%s, served=%s, input_tokens=%d, output_tokens=%d, ip=%s, success=%v",
+		req.Model, servedModel, inputTokens, outputTokens, clientIP, success)
 }
 
 func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, inputTokens int64, includeUsage bool, tools []Tool) int64 {
@@ -651,6 +888,7 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 			Delta:        &Delta{Role: "assistant"},
 			FinishReason: nil,
 		}},
+		Warnings: mediaWarnings(w),
 	}
 	data, _ := json.Marshal(firstChunk)
 	fmt.Fprintf(w, "data: %s\n\n", data)
@@ -659,7 +897,7 @@ func handleStreamResponse(w http.ResponseWriter, body io.ReadCloser, completionI
 	scanner := bufio.NewScanner(body)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 	hasContent := false
-	searchRefFilter := NewSearchRefFilter()
+	searchRefFilter := NewSearchRefFilter(Cfg.CitationMode)
 	thinkingFilter := &ThinkingFilter{}
 	pendingSourcesMarkdown := ""
 	pendingImageSearchMarkdown := ""
@@ -1091,7 +1329,7 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 	var chunks []string
 	var reasoningChunks []string
 	thinkingFilter := &ThinkingFilter{}
-	searchRefFilter := NewSearchRefFilter()
+	searchRefFilter := NewSearchRefFilter(Cfg.CitationMode)
 	hasThinking := false
 	pendingSourcesMarkdown := ""
 	pendingImageSearchMarkdown := ""
@@ -1260,10 +1498,12 @@ func handleNonStreamResponse(w http.ResponseWriter, body io.ReadCloser, completi
 	json.NewEncoder(w).Encode(response)
 	return outputTokens
 }
-func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, inputTokens int64, includeUsage bool, tools []Tool, isFirstAttempt bool) UpstreamResult {
+func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, inputTokens int64, includeUsage bool, tools []Tool, isFirstAttempt bool, imageFormat, citationMode string) UpstreamResult {
 	result := UpstreamResult{Success: true, HasContent: false}
 	var outputTokens int64
 	var fullContent strings.Builder
+	var fullReasoning strings.Builder
+	var sourceTokens int64 // 插入思考内容的搜索来源，计入输出但不算作推理 token
 	var upstreamError string
 	if isFirstAttempt {
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -1296,10 +1536,12 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 	scanner := bufio.NewScanner(body)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 	hasContent := false
-	searchRefFilter := NewSearchRefFilter()
+	searchRefFilter := NewSearchRefFilter(citationMode)
 	thinkingFilter := &ThinkingFilter{}
 	pendingSourcesMarkdown := ""
 	pendingImageSearchMarkdown := ""
+	var pendingImageAttachments []Attachment
+	seenImages := make(map[string]bool)
 	totalContentOutputLength := 0
 	hasTools := len(tools) > 0
 
@@ -1369,6 +1611,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 
 				if reasoningContent != "" {
 					hasContent = true
+					fullReasoning.WriteString(reasoningContent)
 					chunk := ChatCompletionChunk{
 						ID:      completionID,
 						Object:  "chat.completion.chunk",
@@ -1400,10 +1643,42 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 			}
 			continue
 		}
+		if editContent != "" && IsImageGenerationContent(editContent) {
+			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
+			if textBeforeBlock != "" {
+				textBeforeBlock = searchRefFilter.ProcessContent(textBeforeBlock)
+			}
+			var images []ContentPart
+			for _, imageURL := range ParseGeneratedImages(editContent) {
+				if seenImages[imageURL] {
+					continue
+				}
+				seenImages[imageURL] = true
+				images = append(images, ResolveGeneratedImage(imageURL, imageFormat))
+			}
+			if textBeforeBlock != "" || len(images) > 0 {
+				hasContent = true
+				chunk := ChatCompletionChunk{
+					ID:      completionID,
+					Object:  "chat.completion.chunk",
+					Created: time.Now().Unix(),
+					Model:   modelName,
+					Choices: []Choice{{
+						Index:        0,
+						Delta:        &Delta{Content: textBeforeBlock, Annotations: searchRefFilter.TakeAnnotations(), Images: images},
+						FinishReason: nil,
+					}},
+				}
+				chunkData, _ := json.Marshal(chunk)
+				fmt.Fprintf(w, "data: %s\n\n", chunkData)
+				flusher.Flush()
+			}
+			continue
+		}
 		if editContent != "" && strings.Contains(editContent, `"search_image"`) {
 			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
 			if textBeforeBlock != "" {
-				textBeforeBlock = searchRefFilter.Process(textBeforeBlock)
+				textBeforeBlock = searchRefFilter.ProcessContent(textBeforeBlock)
 				if textBeforeBlock != "" {
 					hasContent = true
 					chunk := ChatCompletionChunk{
@@ -1413,7 +1688,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 						Model:   modelName,
 						Choices: []Choice{{
 							Index:        0,
-							Delta:        &Delta{Content: textBeforeBlock},
+							Delta:        &Delta{Content: textBeforeBlock, Annotations: searchRefFilter.TakeAnnotations()},
 							FinishReason: nil,
 						}},
 					}
@@ -1423,14 +1698,18 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 				}
 			}
 			if results := ParseImageSearchResults(editContent); len(results) > 0 {
-				pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				if searchRefFilter.AnnotationsEnabled() {
+					pendingImageAttachments = ImageSearchAttachments(results)
+				} else {
+					pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				}
 			}
 			continue
 		}
 		if editContent != "" && strings.Contains(editContent, `"mcp"`) {
 			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
 			if textBeforeBlock != "" {
-				textBeforeBlock = searchRefFilter.Process(textBeforeBlock)
+				textBeforeBlock = searchRefFilter.ProcessContent(textBeforeBlock)
 				if textBeforeBlock != "" {
 					hasContent = true
 					chunk := ChatCompletionChunk{
@@ -1440,7 +1719,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 						Model:   modelName,
 						Choices: []Choice{{
 							Index:        0,
-							Delta:        &Delta{Content: textBeforeBlock},
+							Delta:        &Delta{Content: textBeforeBlock, Annotations: searchRefFilter.TakeAnnotations()},
 							FinishReason: nil,
 						}},
 					}
@@ -1491,6 +1770,24 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 			flusher.Flush()
 			pendingImageSearchMarkdown = ""
 		}
+		if len(pendingImageAttachments) > 0 {
+			hasContent = true
+			chunk := ChatCompletionChunk{
+				ID:      completionID,
+				Object:  "chat.completion.chunk",
+				Created: time.Now().Unix(),
+				Model:   modelName,
+				Choices: []Choice{{
+					Index:        0,
+					Delta:        &Delta{Attachments: pendingImageAttachments},
+					FinishReason: nil,
+				}},
+			}
+			chunkData, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", chunkData)
+			flusher.Flush()
+			pendingImageAttachments = nil
+		}
 
 		content := ""
 		reasoningContent := ""
@@ -1500,6 +1797,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 			processedRemaining := searchRefFilter.Process(thinkingRemaining)
 			if processedRemaining != "" {
 				hasContent = true
+				fullReasoning.WriteString(processedRemaining)
 				chunk := ChatCompletionChunk{
 					ID:      completionID,
 					Object:  "chat.completion.chunk",
@@ -1519,6 +1817,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 
 		if pendingSourcesMarkdown != "" && thinkingFilter.hasSeenFirstThinking {
 			hasContent = true
+			sourceTokens += CountTokens(pendingSourcesMarkdown)
 			chunk := ChatCompletionChunk{
 				ID:      completionID,
 				Object:  "chat.completion.chunk",
@@ -1569,6 +1868,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 		}
 		if reasoningContent != "" {
 			hasContent = true
+			fullReasoning.WriteString(reasoningContent)
 			chunk := ChatCompletionChunk{
 				ID:      completionID,
 				Object:  "chat.completion.chunk",
@@ -1589,7 +1889,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 			continue
 		}
 
-		content = searchRefFilter.Process(content)
+		content = searchRefFilter.ProcessContent(content)
 		if content == "" {
 			continue
 		}
@@ -1611,7 +1911,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 			Model:   modelName,
 			Choices: []Choice{{
 				Index:        0,
-				Delta:        &Delta{Content: content},
+				Delta:        &Delta{Content: content, Annotations: searchRefFilter.TakeAnnotations()},
 				FinishReason: nil,
 			}},
 		}
@@ -1622,7 +1922,25 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 		flusher.Flush()
 	}
 
-	if remaining := searchRefFilter.Flush(); remaining != "" {
+	// 服务关闭超过排空期限，上游响应已被中断：以错误事件结束流
+	if cutoffCtx.Err() != nil && scanner.Err() != nil {
+		writeStreamError(w, flusher, ErrTypeServer, "服务正在重启，响应已中断，请重试", "server_shutdown")
+		result.Success = false
+		result.HasContent = hasContent
+		result.OutputTokens = outputTokens
+		result.ErrorMessage = "server shutting down"
+		return result
+	}
+
+	// 上游中途报错：错误信息已作为正文输出，以错误事件结束流而不是正常的 stop
+	if upstreamError != "" {
+		writeStreamError(w, flusher, ErrTypeUpstream, "上游服务错误: "+upstreamError, "upstream_error")
+		result.HasContent = hasContent
+		result.OutputTokens = outputTokens
+		return result
+	}
+
+	if remaining := searchRefFilter.FlushContent(); remaining != "" {
 		hasContent = true
 		fullContent.WriteString(remaining)
 		if !hasTools {
@@ -1633,7 +1951,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 				Model:   modelName,
 				Choices: []Choice{{
 					Index:        0,
-					Delta:        &Delta{Content: remaining},
+					Delta:        &Delta{Content: remaining, Annotations: searchRefFilter.TakeAnnotations()},
 					FinishReason: nil,
 				}},
 			}
@@ -1648,6 +1966,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 		toolCalls = ExtractToolInvocations(fullContent.String())
 		if len(toolCalls) > 0 {
 			stopReason = "tool_calls"
+			searchRefFilter.ResetAnnotations()
 			for i, tc := range toolCalls {
 				toolChunk := ChatCompletionChunk{
 					ID:      completionID,
@@ -1674,6 +1993,10 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 		} else {
 			// 未检测到工具调用，将缓冲的 content 作为普通内容发送
 			bufferedContent := RemoveToolJSONContent(fullContent.String())
+			if bufferedContent != fullContent.String() {
+				// 内容被改写，引用位置失效
+				searchRefFilter.ResetAnnotations()
+			}
 			if bufferedContent != "" {
 				chunk := ChatCompletionChunk{
 					ID:      completionID,
@@ -1682,7 +2005,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 					Model:   modelName,
 					Choices: []Choice{{
 						Index:        0,
-						Delta:        &Delta{Content: bufferedContent},
+						Delta:        &Delta{Content: bufferedContent, Annotations: searchRefFilter.TakeAnnotations()},
 						FinishReason: nil,
 					}},
 				}
@@ -1693,6 +2016,23 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 		}
 	}
 
+	// 尚未随正文输出的引用
+	if annots := searchRefFilter.TakeAnnotations(); len(annots) > 0 {
+		chunk := ChatCompletionChunk{
+			ID:      completionID,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   modelName,
+			Choices: []Choice{{
+				Index:        0,
+				Delta:        &Delta{Annotations: annots},
+				FinishReason: nil,
+			}},
+		}
+		chunkData, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", chunkData)
+	}
+
 	finalChunk := ChatCompletionChunk{
 		ID:      completionID,
 		Object:  "chat.completion.chunk",
@@ -1707,6 +2047,8 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 	finalData, _ := json.Marshal(finalChunk)
 	fmt.Fprintf(w, "data: %s\n\n", finalData)
 
+	reasoningTokens := CountTokens(fullReasoning.String())
+	outputTokens += reasoningTokens + sourceTokens
 	if includeUsage {
 		usageChunk := ChatCompletionChunkResponse{
 			ID:      completionID,
@@ -1714,11 +2056,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 			Created: time.Now().Unix(),
 			Model:   modelName,
 			Choices: []Choice{},
-			Usage: &Usage{
-				PromptTokens:     inputTokens,
-				CompletionTokens: outputTokens,
-				TotalTokens:      inputTokens + outputTokens,
-			},
+			Usage:   NewUsage(inputTokens, outputTokens, reasoningTokens),
 		}
 		usageData, _ := json.Marshal(usageChunk)
 		fmt.Fprintf(w, "data: %s\n\n", usageData)
@@ -1736,7 +2074,7 @@ func handleStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, co
 }
 
 // handleNonStreamResponseWithRetry 非流式响应处理（带重试支持，不立即写入响应）
-func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, inputTokens int64, tools []Tool) UpstreamResult {
+func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser, completionID, modelName string, inputTokens int64, tools []Tool, imageFormat, citationMode string) UpstreamResult {
 	result := UpstreamResult{Success: true, HasContent: false}
 	var outputTokens int64
 	var upstreamError string
@@ -1746,10 +2084,14 @@ func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser,
 	var chunks []string
 	var reasoningChunks []string
 	thinkingFilter := &ThinkingFilter{}
-	searchRefFilter := NewSearchRefFilter()
+	searchRefFilter := NewSearchRefFilter(citationMode)
 	hasThinking := false
 	pendingSourcesMarkdown := ""
+	var sourceTokens int64
 	pendingImageSearchMarkdown := ""
+	var attachments []Attachment
+	var imageURLs []string
+	seenImages := make(map[string]bool)
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -1813,13 +2155,30 @@ func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser,
 			}
 			continue
 		}
+		if editContent != "" && IsImageGenerationContent(editContent) {
+			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
+			if textBeforeBlock != "" {
+				chunks = append(chunks, textBeforeBlock)
+			}
+			for _, imageURL := range ParseGeneratedImages(editContent) {
+				if !seenImages[imageURL] {
+					seenImages[imageURL] = true
+					imageURLs = append(imageURLs, imageURL)
+				}
+			}
+			continue
+		}
 		if editContent != "" && strings.Contains(editContent, `"search_image"`) {
 			textBeforeBlock := ExtractTextBeforeGlmBlock(editContent)
 			if textBeforeBlock != "" {
 				chunks = append(chunks, textBeforeBlock)
 			}
 			if results := ParseImageSearchResults(editContent); len(results) > 0 {
-				pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				if searchRefFilter.AnnotationsEnabled() {
+					attachments = append(attachments, ImageSearchAttachments(results)...)
+				} else {
+					pendingImageSearchMarkdown = FormatImageSearchResults(results)
+				}
 			}
 			continue
 		}
@@ -1837,6 +2196,7 @@ func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser,
 		if pendingSourcesMarkdown != "" {
 			if hasThinking {
 				reasoningChunks = append(reasoningChunks, pendingSourcesMarkdown)
+				sourceTokens += CountTokens(pendingSourcesMarkdown)
 			} else {
 				chunks = append(chunks, pendingSourcesMarkdown)
 			}
@@ -1876,12 +2236,18 @@ func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser,
 	}
 
 	fullContent := strings.Join(chunks, "")
-	fullContent = searchRefFilter.Process(fullContent) + searchRefFilter.Flush()
+	fullContent = searchRefFilter.ProcessContent(fullContent) + searchRefFilter.FlushContent()
 	fullReasoning := strings.Join(reasoningChunks, "")
 	fullReasoning = searchRefFilter.Process(fullReasoning) + searchRefFilter.Flush()
 
 	// 检查是否有内容
-	if fullContent == "" && fullReasoning == "" {
+	var images []ContentPart
+	for _, imageURL := range imageURLs {
+		images = append(images, ResolveGeneratedImage(imageURL, imageFormat))
+	}
+
+	// 检查是否有内容
+	if fullContent == "" && fullReasoning == "" && len(attachments) == 0 && len(images) == 0 {
 		result.HasContent = false
 		result.ErrorMessage = "empty response"
 		return result
@@ -1897,11 +2263,14 @@ func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser,
 		if len(toolCalls) > 0 {
 			stopReason = "tool_calls"
 			fullContent = RemoveToolJSONContent(fullContent)
+			searchRefFilter.ResetAnnotations()
 		}
 	}
 
-	// 计算输出 token
-	outputTokens = CountTokens(fullContent) + CountTokens(fullReasoning)
+	// 计算输出 token：插入思考内容的搜索来源不算作推理 token
+	reasoningTokens := CountTokens(fullReasoning)
+	outputTokens = CountTokens(fullContent) + reasoningTokens + CountToolCallTokens(toolCalls)
+	reasoningTokens = max(0, reasoningTokens-sourceTokens)
 	result.OutputTokens = outputTokens
 
 	// 写入响应
@@ -1920,21 +2289,41 @@ func handleNonStreamResponseWithRetry(w http.ResponseWriter, body io.ReadCloser,
 				Content:          fullContent,
 				ReasoningContent: fullReasoning,
 				ToolCalls:        toolCalls,
+				Annotations:      searchRefFilter.TakeAnnotations(),
+				Attachments:      attachments,
+				Images:           images,
 			},
 			FinishReason: &stopReason,
 		}},
-		Usage: &Usage{
-			PromptTokens:     inputTokens,
-			CompletionTokens: outputTokens,
-			TotalTokens:      inputTokens + outputTokens,
-		},
+		Usage:             NewUsage(inputTokens, outputTokens, reasoningTokens),
 		SystemFingerprint: "openai",
+		Warnings:          mediaWarnings(w),
 	}
 	json.NewEncoder(w).Encode(response)
 	return result
 }
 
+// mediaWarnings 返回 X-Media-Warning 响应头中的媒体处理警告，同时写入响应体，供读不到响应头的客户端使用
+func mediaWarnings(w http.ResponseWriter) []string {
+	if warning := w.Header().Get("X-Media-Warning"); warning != "" {
+		return []string{warning}
+	}
+	return nil
+}
+
+// HandleModels 处理 /v1/models 和 /v1/models/{id}
 func HandleModels(w http.ResponseWriter, r *http.Request) {
+	if modelID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/"); modelID != "" {
+		info, ok := GetModelInfo(modelID)
+		if !ok {
+			writeModelNotFoundError(w, modelID)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+		return
+	}
+
 	models := GetAvailableModels()
 
 	response := ModelsResponse{
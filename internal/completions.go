@@ -0,0 +1,362 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// completionMaxStops 旧版接口允许的最大停止序列数
+const completionMaxStops = 4
+
+// CompletionRequest 旧版文本补全请求 (/v1/completions)
+type CompletionRequest struct {
+	Model         string      `json:"model"`
+	Prompt        interface{} `json:"prompt"` // string 或只含一个元素的 []string
+	Suffix        string      `json:"suffix,omitempty"`
+	Echo          bool        `json:"echo,omitempty"`
+	Stop          interface{} `json:"stop,omitempty"` // string 或 []string
+	Stream        bool        `json:"stream"`
+	MaxTokens     *int        `json:"max_tokens,omitempty"`
+	Temperature   *float64    `json:"temperature,omitempty"`
+	TopP          *float64    `json:"top_p,omitempty"`
+	N             *int        `json:"n,omitempty"`
+	Logprobs      *int        `json:"logprobs,omitempty"` // 不支持，始终返回 null
+	User          string      `json:"user,omitempty"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage,omitempty"`
+	} `json:"stream_options,omitempty"`
+}
+
+// CompletionChoice 文本补全结果
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// CompletionResponse 文本补全响应，流式输出时每个 chunk 也使用该结构
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+// promptText 解析 prompt，仅支持单个文本
+func (req *CompletionRequest) promptText() (string, error) {
+	switch p := req.Prompt.(type) {
+	case string:
+		return p, nil
+	case []interface{}:
+		if len(p) != 1 {
+			return "", fmt.Errorf("prompt 仅支持单个文本")
+		}
+		if s, ok := p[0].(string); ok {
+			return s, nil
+		}
+	case nil:
+		return "", fmt.Errorf("缺少 prompt")
+	}
+	return "", fmt.Errorf("prompt 必须为字符串")
+}
+
+// parseStopSequences 解析 stop 参数
+func parseStopSequences(v interface{}) ([]string, error) {
+	var stops []string
+	switch s := v.(type) {
+	case nil:
+	case string:
+		stops = append(stops, s)
+	case []interface{}:
+		for _, item := range s {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("stop 必须为字符串或字符串数组")
+			}
+			stops = append(stops, str)
+		}
+	default:
+		return nil, fmt.Errorf("stop 必须为字符串或字符串数组")
+	}
+	var result []string
+	for _, s := range stops {
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+	if len(result) > completionMaxStops {
+		return nil, fmt.Errorf("stop 最多支持 %d 个", completionMaxStops)
+	}
+	return result, nil
+}
+
+// cutAtStop 在第一个停止序列处截断文本
+func cutAtStop(text string, stops []string) (string, bool) {
+	cut := -1
+	for _, s := range stops {
+		if i := strings.Index(text, s); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut < 0 {
+		return text, false
+	}
+	return text[:cut], true
+}
+
+// suffixInstruction 带 suffix 时的续写说明
+func suffixInstruction(suffix string) string {
+	return "续写用户给出的文本，只输出续写内容。续写内容之后紧接着以下文本，请保证衔接自然：\n" + suffix
+}
+
+// completionMessages 将 prompt 包装为对话消息
+func completionMessages(prompt, suffix string) []Message {
+	var messages []Message
+	if suffix != "" {
+		messages = append(messages, Message{Role: "system", Content: suffixInstruction(suffix)})
+	}
+	return append(messages, Message{Role: "user", Content: prompt})
+}
+
+// HandleCompletions 旧版文本补全接口，转换为对话请求后复用对话处理流程
+func HandleCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeInvalidRequestError(w, "Only POST method is allowed")
+		return
+	}
+
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidRequestError(w, "无效的请求格式")
+		return
+	}
+	prompt, err := req.promptText()
+	if err != nil {
+		writeInvalidRequestError(w, err.Error())
+		return
+	}
+	stops, err := parseStopSequences(req.Stop)
+	if err != nil {
+		writeInvalidRequestError(w, err.Error())
+		return
+	}
+	if req.N != nil && *req.N != 1 {
+		writeInvalidRequestError(w, "n 仅支持 1")
+		return
+	}
+
+	chatReq := ChatRequest{
+		Model:         req.Model,
+		Messages:      completionMessages(prompt, req.Suffix),
+		Stream:        req.Stream,
+		MaxTokens:     req.MaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		User:          req.User,
+		StreamOptions: req.StreamOptions,
+	}
+	chatHTTPReq := newInternalChatRequest(r, newInternalCaller(r, apiKey), &chatReq)
+
+	echo := ""
+	if req.Echo {
+		echo = prompt
+	}
+
+	if req.Stream {
+		cs := newCompletionStream(w, echo, stops)
+		sw := newChatStreamWriter(w, "text/event-stream", cs.onChunk, cs.onDone)
+		sw.onError = cs.onError
+		HandleChatCompletions(sw, chatHTTPReq)
+		return
+	}
+
+	buf := newResponseBuffer()
+	HandleChatCompletions(buf, chatHTTPReq)
+	if writeBufferedError(w, buf) {
+		return
+	}
+	copyResponseHeaders(w.Header(), buf.header)
+
+	var chatResp ChatCompletionResponse
+	if err := json.Unmarshal(buf.body.Bytes(), &chatResp); err != nil || len(chatResp.Choices) == 0 || chatResp.Choices[0].Message == nil {
+		writeError(w, http.StatusBadGateway, ErrTypeUpstream, "无法解析上游响应", "upstream_error")
+		return
+	}
+	choice := chatResp.Choices[0]
+	text, stopped := cutAtStop(choice.Message.Content, stops)
+	finishReason := completionFinishReason(choice.FinishReason)
+	if stopped {
+		finishReason = "stop"
+	}
+	writeJSON(w, CompletionResponse{
+		ID:      completionIDFromChat(chatResp.ID),
+		Object:  "text_completion",
+		Created: chatResp.Created,
+		Model:   chatResp.Model,
+		Choices: []CompletionChoice{{
+			Text:         echo + text,
+			Index:        0,
+			FinishReason: &finishReason,
+		}},
+		Usage: chatResp.Usage,
+	})
+}
+
+// completionIDFromChat 将 chatcmpl- 前缀替换为 cmpl-
+func completionIDFromChat(id string) string {
+	return "cmpl-" + strings.TrimPrefix(id, "chatcmpl-")
+}
+
+// completionFinishReason 旧版接口只有 stop 和 length
+func completionFinishReason(reason *string) string {
+	if reason != nil && *reason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// completionStream 将对话接口的流式 chunk 转换为 text_completion 格式
+type completionStream struct {
+	w        http.ResponseWriter
+	echo     string
+	stops    []string
+	holdBack int // 为匹配跨 chunk 的停止序列而暂缓输出的字节数
+	pending  string
+	started  bool
+	finished bool
+	failed   bool // 流中途出错，不再输出正常结束
+	id       string
+	model    string
+	created  int64
+}
+
+func newCompletionStream(w http.ResponseWriter, echo string, stops []string) *completionStream {
+	cs := &completionStream{w: w, echo: echo, stops: stops}
+	for _, s := range stops {
+		if len(s)-1 > cs.holdBack {
+			cs.holdBack = len(s) - 1
+		}
+	}
+	return cs
+}
+
+func (cs *completionStream) onChunk(chunk *ChatCompletionChunkResponse) {
+	if cs.id == "" {
+		cs.id = completionIDFromChat(chunk.ID)
+		cs.created = chunk.Created
+	}
+	cs.model = chunk.Model
+	if !cs.started {
+		cs.started = true
+		if cs.echo != "" {
+			cs.writeChunk(cs.echo, nil, nil)
+		}
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Delta != nil && choice.Delta.Content != "" {
+			cs.addText(choice.Delta.Content)
+		}
+		if choice.FinishReason != nil {
+			cs.finish(completionFinishReason(choice.FinishReason))
+		}
+	}
+	if chunk.Usage != nil {
+		cs.writeChunk("", nil, chunk.Usage)
+	}
+}
+
+func (cs *completionStream) onDone() {
+	if !cs.failed {
+		cs.finish("stop")
+	}
+	fmt.Fprintf(cs.w, "data: [DONE]\n\n")
+	cs.flush()
+}
+
+// onError 原样转发错误事件（与对话接口格式相同），已输出的正文保留，不再输出 finish_reason
+func (cs *completionStream) onError(payload []byte) {
+	if cs.finished {
+		return
+	}
+	cs.failed = true
+	if cs.pending != "" {
+		cs.writeChunk(cs.pending, nil, nil)
+		cs.pending = ""
+	}
+	fmt.Fprintf(cs.w, "data: %s\n\n", payload)
+	cs.flush()
+}
+
+// addText 输出正文，命中停止序列后丢弃后续内容
+func (cs *completionStream) addText(text string) {
+	if cs.finished {
+		return
+	}
+	cs.pending += text
+	if cut, stopped := cutAtStop(cs.pending, cs.stops); stopped {
+		cs.pending = cut
+		cs.finish("stop")
+		return
+	}
+	n := len(cs.pending) - cs.holdBack
+	// 不在多字节字符中间截断；没有暂缓字节时 n 等于长度，可以整体输出
+	for n > 0 && n < len(cs.pending) && !utf8.RuneStart(cs.pending[n]) {
+		n--
+	}
+	if n <= 0 {
+		return
+	}
+	cs.writeChunk(cs.pending[:n], nil, nil)
+	cs.pending = cs.pending[n:]
+}
+
+// finish 输出剩余正文和结束原因，只执行一次
+func (cs *completionStream) finish(reason string) {
+	if cs.finished || !cs.started {
+		return
+	}
+	cs.finished = true
+	if cs.pending != "" {
+		cs.writeChunk(cs.pending, nil, nil)
+		cs.pending = ""
+	}
+	cs.writeChunk("", &reason, nil)
+}
+
+func (cs *completionStream) writeChunk(text string, finishReason *string, usage *Usage) {
+	chunk := CompletionResponse{
+		ID:      cs.id,
+		Object:  "text_completion",
+		Created: cs.created,
+		Model:   cs.model,
+		Choices: []CompletionChoice{},
+		Usage:   usage,
+	}
+	if usage == nil {
+		chunk.Choices = append(chunk.Choices, CompletionChoice{Text: text, Index: 0, FinishReason: finishReason})
+	}
+	if chunk.Created == 0 {
+		chunk.Created = time.Now().Unix()
+	}
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(cs.w, "data: %s\n\n", data)
+	cs.flush()
+}
+
+func (cs *completionStream) flush() {
+	if flusher, ok := cs.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
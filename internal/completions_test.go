@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCompletionPromptText(t *testing.T) {
+	tests := []struct {
+		prompt  interface{}
+		want    string
+		wantErr bool
+	}{
+		{prompt: "hello", want: "hello"},
+		{prompt: []interface{}{"hello"}, want: "hello"},
+		{prompt: []interface{}{"a", "b"}, wantErr: true},
+		{prompt: []interface{}{1.0}, wantErr: true},
+		{prompt: nil, wantErr: true},
+		{prompt: 42.0, wantErr: true},
+	}
+	for _, tt := range tests {
+		req := CompletionRequest{Prompt: tt.prompt}
+		got, err := req.promptText()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("promptText(%v) = %q, %v; want %q, err=%v", tt.prompt, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseStopSequences(t *testing.T) {
+	tests := []struct {
+		stop    interface{}
+		want    []string
+		wantErr bool
+	}{
+		{stop: nil},
+		{stop: "\n", want: []string{"\n"}},
+		{stop: []interface{}{"a", "", "b"}, want: []string{"a", "b"}},
+		{stop: []interface{}{"a", 1.0}, wantErr: true},
+		{stop: 1.0, wantErr: true},
+		{stop: []interface{}{"1", "2", "3", "4", "5"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStopSequences(tt.stop)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStopSequences(%v) = %v, %v; want %v, err=%v", tt.stop, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCutAtStop(t *testing.T) {
+	tests := []struct {
+		text    string
+		stops   []string
+		want    string
+		stopped bool
+	}{
+		{"hello world", nil, "hello world", false},
+		{"hello world", []string{"wor"}, "hello ", true},
+		{"a.b;c", []string{";", "."}, "a", true},
+		{"abc", []string{"x"}, "abc", false},
+	}
+	for _, tt := range tests {
+		got, stopped := cutAtStop(tt.text, tt.stops)
+		if got != tt.want || stopped != tt.stopped {
+			t.Errorf("cutAtStop(%q, %v) = %q, %v; want %q, %v", tt.text, tt.stops, got, stopped, tt.want, tt.stopped)
+		}
+	}
+}
+
+// chatSSE 构造对话接口的流式输出
+func chatSSE(events ...string) string {
+	var b strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&b, "data: %s\n\n", e)
+	}
+	return b.String()
+}
+
+func chatDelta(content string) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{"content":%q}}]}`, content)
+}
+
+const chatStop = `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`
+
+// completionEvents 解析 text_completion 流，返回正文、结束原因、错误事件数和是否以 [DONE] 结束
+func completionEvents(t *testing.T, body string) (text string, reasons []string, errors int, done bool) {
+	t.Helper()
+	for _, event := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		payload := strings.TrimPrefix(event, "data: ")
+		if payload == "[DONE]" {
+			done = true
+			continue
+		}
+		if isStreamErrorEvent([]byte(payload)) {
+			errors++
+			continue
+		}
+		var chunk CompletionResponse
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", payload, err)
+		}
+		if chunk.ID != "cmpl-1" || chunk.Object != "text_completion" {
+			t.Fatalf("unexpected chunk header: %+v", chunk)
+		}
+		for _, c := range chunk.Choices {
+			if !utf8.ValidString(c.Text) {
+				t.Errorf("chunk splits a multibyte character: %q", c.Text)
+			}
+			text += c.Text
+			if c.FinishReason != nil {
+				reasons = append(reasons, *c.FinishReason)
+			}
+		}
+	}
+	return
+}
+
+func TestCompletionStream(t *testing.T) {
+	upstreamErr := `{"error":{"message":"上游服务错误: boom","type":"upstream_error","code":"upstream_error"}}`
+	tests := []struct {
+		name        string
+		echo        string
+		stops       []string
+		input       string
+		wantText    string
+		wantReasons []string
+		wantErrors  int
+	}{
+		{
+			name:        "plain",
+			input:       chatSSE(chatDelta("Hello"), chatDelta(" world"), chatStop, "[DONE]"),
+			wantText:    "Hello world",
+			wantReasons: []string{"stop"},
+		},
+		{
+			name:        "echo",
+			echo:        "Say: ",
+			input:       chatSSE(chatDelta("hi"), chatStop, "[DONE]"),
+			wantText:    "Say: hi",
+			wantReasons: []string{"stop"},
+		},
+		{
+			name:        "stop sequence across chunks",
+			stops:       []string{"END"},
+			input:       chatSSE(chatDelta("abc E"), chatDelta("ND tail"), chatStop, "[DONE]"),
+			wantText:    "abc ",
+			wantReasons: []string{"stop"},
+		},
+		{
+			name:        "multibyte text with hold back",
+			stops:       []string{"。\n"},
+			input:       chatSSE(chatDelta("你好"), chatDelta("世界。"), chatDelta("\n多余"), chatStop, "[DONE]"),
+			wantText:    "你好世界",
+			wantReasons: []string{"stop"},
+		},
+		{
+			name:        "length",
+			input:       chatSSE(chatDelta("abc"), `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`, "[DONE]"),
+			wantText:    "abc",
+			wantReasons: []string{"length"},
+		},
+		{
+			name:       "mid-stream error",
+			stops:      []string{"END"},
+			input:      chatSSE(chatDelta("partial E"), upstreamErr, "[DONE]"),
+			wantText:   "partial E",
+			wantErrors: 1,
+		},
+		{
+			name:        "missing finish reason",
+			input:       chatSSE(chatDelta("abc"), "[DONE]"),
+			wantText:    "abc",
+			wantReasons: []string{"stop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			cs := newCompletionStream(rec, tt.echo, tt.stops)
+			sw := newChatStreamWriter(rec, "text/event-stream", cs.onChunk, cs.onDone)
+			sw.onError = cs.onError
+			sw.Header().Set("Content-Type", "text/event-stream")
+			sw.WriteHeader(http.StatusOK)
+			// 按字节切分写入，验证跨写入的事件拼接
+			for i := 0; i < len(tt.input); i += 7 {
+				sw.Write([]byte(tt.input[i:min(i+7, len(tt.input))]))
+			}
+
+			text, reasons, errors, done := completionEvents(t, rec.Body.String())
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Errorf("finish reasons = %v, want %v", reasons, tt.wantReasons)
+			}
+			if errors != tt.wantErrors {
+				t.Errorf("error events = %d, want %d", errors, tt.wantErrors)
+			}
+			if !done {
+				t.Error("stream not terminated with [DONE]")
+			}
+		})
+	}
+}
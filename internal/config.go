@@ -10,7 +10,22 @@ import (
 
 type Config struct {
 	// Server
-	Port string
+	Port              string
+	UnixSocket        string // 额外监听的 Unix socket 路径，为空不监听
+	UnixSocketMode    string // Unix socket 文件权限（八进制）
+	ReadHeaderTimeout int    // 读取请求头超时（秒）
+	IdleTimeout       int    // keep-alive 空闲连接超时（秒）
+	WriteTimeout      int    // 写超时（秒）：非流式响应的总时长；流式响应为两次输出之间的最长间隔；0 不限制
+	HTTP2Enabled      bool   // 启用 TLS 时支持 HTTP/2
+
+	// TLS
+	TLSCertFile      string   // 证书文件，变更后自动重新加载
+	TLSKeyFile       string   // 私钥文件
+	ACMEDomains      []string // ACME 自动申请证书的域名，设置后忽略证书文件
+	ACMEDirectoryURL string   // ACME 目录地址，默认 Let's Encrypt
+	ACMEEmail        string   // ACME 账户邮箱
+	ACMECacheDir     string   // ACME 证书缓存目录
+	ACMEHTTPPort     string   // HTTP-01 验证端口（通常为 80），为空仅使用 TLS-ALPN-01
 
 	// API Configuration
 	APIEndpoint  string
@@ -25,15 +40,86 @@ type Config struct {
 	PrimaryModelNew  string
 	ThinkingModelNew string
 	SearchModelNew   string
-
+	ModelAliases     string // 模型别名，如 gpt-4o=GLM-4.7,claude-*=GLM-5-Thinking
+	ModelFallbacks   string // 降级链，如 GLM-5=GLM-4.7>GLM-4.6
+	ModelCatalog     string // 模型目录文件（JSON 或 YAML），支持热加载
 	// Feature Configuration
 	DebugLogging       bool
 	AnonymousMode      bool
 	ToolSupport        bool
 	SkipAuthToken      bool
+	FeaturePolicies    string // 按 API Key 限制可开启的功能和 MCP 服务器
+	CitationMode       string // 搜索引用输出方式：markdown / annotations
+	ImageOutputFormat  string // 生成图片返回格式：url / b64_json
+	ImageMirrorDir     string // 生成图片本地转存目录，为空不转存
+	ImageModel         string // /v1/images/generations 默认模型
+	PublicBaseURL      string // 对外访问地址，用于拼接转存图片 URL
 	ThinkingProcessing string // think, strip, raw
 	ScanLimit          int
 	LogLevel           string
+	TokenizerVocab     string // GLM 词表文件路径，为空时使用内置词表
+
+	// Context Window
+	ContextStrategy      string // none, truncate, middle-out, summarize
+	ContextReserveTokens int    // 为输出预留的 token 数
+	SummaryModel         string // summarize 策略使用的摘要模型
+
+	// Response Cache
+	CacheEnabled    bool
+	CacheBackend    string // memory, disk
+	CacheTTL        int    // 秒
+	CacheMaxEntries int    // 缓存最大条目数（内存与磁盘）
+	CacheDir        string // 磁盘缓存目录
+
+	// Media Upload
+	UploadConcurrency     int // 并发上传数
+	UploadMaxFileMB       int // 单个文件大小上限（MB），0 不限制
+	UploadMaxRequestMB    int // 单次请求媒体总大小上限（MB），0 不限制
+	UploadCacheTTL        int // 上传结果缓存时间（秒），0 关闭
+	UploadCacheMaxEntries int
+	MediaStrict           bool   // 严格模式：任一媒体处理失败时拒绝请求
+	FilesDir              string // /v1/files 本地存储目录
+
+	// Sessions
+	SessionMode string // off, header: 按 X-Session-ID 复用上游对话, user: 另外支持请求中的 user 字段
+	SessionTTL  int    // 会话空闲过期时间（秒），0 不过期
+	SessionDir  string // 会话存储目录
+
+	// Batch
+	BatchDir                 string // 批处理任务目录
+	BatchConcurrency         int    // 单个批处理的并发请求数
+	BatchPerTokenConcurrency int    // 每个上游 token 的并发请求数，0 不限制
+	BatchMaxRequests         int    // 单个批处理的最大请求数
+	BatchMaxFileMB           int    // 批处理输入文件（purpose=batch）大小上限（MB），0 不限制
+
+	// Shutdown
+	ShutdownTimeout int    // 收到 SIGTERM 后等待进行中请求完成的最长时间（秒）
+	TelemetryFile   string // 累计统计数据文件，关闭时保存、启动时恢复；为空不保存
+
+	// Concurrency
+	MaxConcurrentRequests int // 全局同时处理的上游请求数，0 不限制
+	QueueMaxSize          int // 超出并发上限时最多排队的请求数
+	QueueTimeout          int // 排队超时（秒），0 不超时
+
+	// Stream resume
+	StreamResumeEnabled  bool // 缓冲流式响应，断线后可按 completionID 续传
+	StreamResumeTTL      int  // 响应结束后保留回放缓冲区的时间（秒）
+	StreamResumeBufferKB int  // 单个响应的回放缓冲区上限（KB），超出后丢弃最早的事件
+	StreamResumeGrace    int  // 客户端断开后等待重连的时间（秒），期间无人续传则中止上游生成
+
+	// WebSocket
+	WSMaxInflight    int      // 单个 WebSocket 连接同时处理的最大请求数，0 不限制
+	WSAllowedOrigins []string // 允许建立连接的浏览器 Origin，* 为全部；同源和无 Origin 的请求始终允许
+
+	// Documents
+	DocumentMode         string // auto: 上游可解析的格式上传，其余本地提取文本；extract: 全部本地提取
+	DocumentMaxTextChars int    // 本地提取文本的最大字符数，0 不限制
+
+	// Remote Fetch
+	FetchAllowHosts   []string // 允许抓取的主机（域名、*.域名、IP、CIDR），为空不限制
+	FetchDenyHosts    []string // 禁止抓取的主机
+	FetchAllowPrivate bool     // 允许访问内网地址
+	FetchMaxRedirects int
 
 	// Display
 	Note []string // 多行备注，在 / 显示
@@ -108,7 +194,22 @@ func LoadConfig() {
 
 	Cfg = &Config{
 		// Server
-		Port: getEnvString("PORT", "8000"),
+		Port:              getEnvString("PORT", "8000"),
+		UnixSocket:        getEnvString("UNIX_SOCKET", ""),
+		UnixSocketMode:    getEnvString("UNIX_SOCKET_MODE", "0660"),
+		ReadHeaderTimeout: getEnvInt("READ_HEADER_TIMEOUT", 10),
+		IdleTimeout:       getEnvInt("IDLE_TIMEOUT", 120),
+		WriteTimeout:      getEnvInt("WRITE_TIMEOUT", 600),
+		HTTP2Enabled:      getEnvBool("HTTP2_ENABLED", true),
+
+		// TLS
+		TLSCertFile:      getEnvString("TLS_CERT_FILE", ""),
+		TLSKeyFile:       getEnvString("TLS_KEY_FILE", ""),
+		ACMEDomains:      getEnvStringSlice("ACME_DOMAINS"),
+		ACMEDirectoryURL: getEnvString("ACME_DIRECTORY_URL", "https://acme-v02.api.letsencrypt.org/directory"),
+		ACMEEmail:        getEnvString("ACME_EMAIL", ""),
+		ACMECacheDir:     getEnvString("ACME_CACHE_DIR", "data/acme"),
+		ACMEHTTPPort:     getEnvString("ACME_HTTP_PORT", ""),
 
 		// API Configuration
 		APIEndpoint:  getEnvString("API_ENDPOINT", "https://chat.z.ai/api/chat/completions"),
@@ -123,19 +224,91 @@ func LoadConfig() {
 		PrimaryModelNew:  getEnvString("PRIMARY_MODEL_NEW", "GLM-4.6"),
 		ThinkingModelNew: getEnvString("THINKING_MODEL_NEW", "GLM-4.6-Thinking"),
 		SearchModelNew:   getEnvString("SEARCH_MODEL_NEW", "GLM-4.6-Search"),
+		ModelAliases:     getEnvString("MODEL_ALIASES", ""),
+		ModelFallbacks:   getEnvString("MODEL_FALLBACKS", ""),
+		ModelCatalog:     getEnvString("MODEL_CATALOG", "data/models.json"),
 
 		// Feature Configuration
 		DebugLogging:       getEnvBool("DEBUG_LOGGING", false),
 		AnonymousMode:      getEnvBool("ANONYMOUS_MODE", true),
 		ToolSupport:        getEnvBool("TOOL_SUPPORT", true),
 		SkipAuthToken:      getEnvBool("SKIP_AUTH_TOKEN", false),
+		FeaturePolicies:    getEnvString("FEATURE_POLICIES", ""),
+		CitationMode:       getEnvString("CITATION_MODE", CitationModeMarkdown),
+		ImageOutputFormat:  getEnvString("IMAGE_OUTPUT_FORMAT", ImageFormatURL),
+		ImageMirrorDir:     getEnvString("IMAGE_MIRROR_DIR", ""),
+		ImageModel:         getEnvString("IMAGE_MODEL", "GLM-4.6"),
+		PublicBaseURL:      getEnvString("PUBLIC_BASE_URL", ""),
 		ThinkingProcessing: getEnvString("THINKING_PROCESSING", "think"),
 		ScanLimit:          getEnvInt("SCAN_LIMIT", 200000),
 		LogLevel:           getEnvString("LOG_LEVEL", "info"),
+		TokenizerVocab:     getEnvString("TOKENIZER_VOCAB", ""),
+
+		// Context Window
+		ContextStrategy:      getEnvString("CONTEXT_STRATEGY", "none"),
+		ContextReserveTokens: getEnvInt("CONTEXT_RESERVE_TOKENS", 4096),
+
+		// Response Cache
+		CacheEnabled:    getEnvBool("CACHE_ENABLED", false),
+		CacheBackend:    getEnvString("CACHE_BACKEND", "memory"),
+		CacheTTL:        getEnvInt("CACHE_TTL", 3600),
+		CacheMaxEntries: getEnvInt("CACHE_MAX_ENTRIES", 1000),
+		CacheDir:        getEnvString("CACHE_DIR", "data/cache"),
+
+		// Media Upload
+		UploadConcurrency:     getEnvInt("UPLOAD_CONCURRENCY", 4),
+		UploadMaxFileMB:       getEnvInt("UPLOAD_MAX_FILE_MB", 20),
+		UploadMaxRequestMB:    getEnvInt("UPLOAD_MAX_REQUEST_MB", 50),
+		UploadCacheTTL:        getEnvInt("UPLOAD_CACHE_TTL", 86400),
+		UploadCacheMaxEntries: getEnvInt("UPLOAD_CACHE_MAX_ENTRIES", 2000),
+		MediaStrict:           getEnvBool("MEDIA_STRICT", false),
+		FilesDir:              getEnvString("FILES_DIR", "data/files"),
+
+		// Sessions
+		SessionMode: strings.ToLower(getEnvString("SESSION_MODE", "off")),
+		SessionTTL:  getEnvInt("SESSION_TTL", 86400),
+		SessionDir:  getEnvString("SESSION_DIR", "data/sessions"),
+
+		// Batch
+		BatchDir:                 getEnvString("BATCH_DIR", "data/batches"),
+		BatchConcurrency:         getEnvInt("BATCH_CONCURRENCY", 4),
+		BatchPerTokenConcurrency: getEnvInt("BATCH_PER_TOKEN_CONCURRENCY", 2),
+		BatchMaxRequests:         getEnvInt("BATCH_MAX_REQUESTS", 50000),
+		BatchMaxFileMB:           getEnvInt("BATCH_MAX_FILE_MB", 200),
+
+		// Shutdown
+		ShutdownTimeout: getEnvInt("SHUTDOWN_TIMEOUT", 30),
+		TelemetryFile:   getEnvString("TELEMETRY_FILE", "data/telemetry.json"),
+
+		// Concurrency
+		MaxConcurrentRequests: getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+		QueueMaxSize:          getEnvInt("QUEUE_MAX_SIZE", 100),
+		QueueTimeout:          getEnvInt("QUEUE_TIMEOUT", 30),
+
+		// Stream resume
+		StreamResumeEnabled:  getEnvBool("STREAM_RESUME_ENABLED", false),
+		StreamResumeTTL:      getEnvInt("STREAM_RESUME_TTL", 120),
+		StreamResumeBufferKB: getEnvInt("STREAM_RESUME_BUFFER_KB", 1024),
+		StreamResumeGrace:    getEnvInt("STREAM_RESUME_GRACE", 30),
+
+		// WebSocket
+		WSMaxInflight:    getEnvInt("WS_MAX_INFLIGHT", 8),
+		WSAllowedOrigins: getEnvStringSlice("WS_ALLOWED_ORIGINS"),
+
+		// Documents
+		DocumentMode:         getEnvString("DOCUMENT_MODE", "auto"),
+		DocumentMaxTextChars: getEnvInt("DOCUMENT_MAX_TEXT_CHARS", 200000),
+
+		// Remote Fetch
+		FetchAllowHosts:   getEnvStringSlice("FETCH_ALLOW_HOSTS"),
+		FetchDenyHosts:    getEnvStringSlice("FETCH_DENY_HOSTS"),
+		FetchAllowPrivate: getEnvBool("FETCH_ALLOW_PRIVATE", false),
+		FetchMaxRedirects: getEnvInt("FETCH_MAX_REDIRECTS", 3),
 
 		// Display
 		Note: parseNoteLines(getEnvString("NOTE", "")),
 	}
+	Cfg.SummaryModel = getEnvString("SUMMARY_MODEL", Cfg.AirModel)
 }
 
 func ValidateAuthToken(token string) bool {
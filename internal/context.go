@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 上下文窗口管理策略
+const (
+	ContextStrategyNone      = "none"       // 不处理，原样发送
+	ContextStrategyTruncate  = "truncate"   // 从最早的对话开始丢弃
+	ContextStrategyMiddleOut = "middle-out" // 保留首尾，从中间开始丢弃
+	ContextStrategySummarize = "summarize"  // 用轻量模型摘要较早的对话
+)
+
+// ContextResult 上下文处理结果
+type ContextResult struct {
+	Strategy       string
+	Limit          int64
+	OriginalTokens int64
+	FinalTokens    int64
+	Dropped        int // 丢弃的消息数
+	Summarized     int // 被摘要替代的消息数
+}
+
+// HeaderValue 生成 X-Context-Management 响应头
+func (r *ContextResult) HeaderValue() string {
+	return fmt.Sprintf("strategy=%s; limit=%d; tokens=%d->%d; dropped=%d; summarized=%d",
+		r.Strategy, r.Limit, r.OriginalTokens, r.FinalTokens, r.Dropped, r.Summarized)
+}
+
+// messageGroup 不可拆分的消息单元，assistant 工具调用与其后的 tool 结果视为一组
+type messageGroup struct {
+	messages []Message
+	tokens   int64
+	pinned   bool // system 消息和摘要始终保留
+}
+
+func countGroupTokens(messages []Message) int64 {
+	var total int64
+	for _, msg := range messages {
+		text, _ := msg.ParseContent()
+		total += CountTokens(text) + 4
+		total += CountToolCallTokens(msg.ToolCalls)
+	}
+	return total
+}
+
+// groupMessages 将消息切分为可整体丢弃的分组
+func groupMessages(messages []Message) []messageGroup {
+	var groups []messageGroup
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+		group := messageGroup{messages: []Message{msg}}
+		switch {
+		case msg.Role == "system" || msg.Role == "developer":
+			group.pinned = true
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			for i+1 < len(messages) && messages[i+1].Role == "tool" {
+				i++
+				group.messages = append(group.messages, messages[i])
+			}
+		}
+		group.tokens = countGroupTokens(group.messages)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func sumGroupTokens(groups []messageGroup) int64 {
+	total := int64(3)
+	for _, g := range groups {
+		total += g.tokens
+	}
+	return total
+}
+
+func flattenGroups(groups []messageGroup) []Message {
+	var messages []Message
+	for _, g := range groups {
+		messages = append(messages, g.messages...)
+	}
+	return messages
+}
+
+// dropOrder 返回按策略排列的可丢弃分组下标，最后一个非固定分组（当前提问）永不丢弃
+func dropOrder(groups []messageGroup, strategy string) []int {
+	var candidates []int
+	for i, g := range groups {
+		if !g.pinned {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) <= 1 {
+		return nil
+	}
+	candidates = candidates[:len(candidates)-1]
+
+	if strategy != ContextStrategyMiddleOut {
+		return candidates
+	}
+
+	// middle-out：保留开头的首个分组，从中间向两侧依次丢弃
+	remaining := candidates[1:]
+	order := make([]int, 0, len(candidates))
+	for len(remaining) > 0 {
+		mid := len(remaining) / 2
+		order = append(order, remaining[mid])
+		remaining = append(remaining[:mid:mid], remaining[mid+1:]...)
+	}
+	return append(order, candidates[0])
+}
+
+// contextBudget 计算可用于消息的 token 预算
+func contextBudget(req *ChatRequest, limit int64) int64 {
+	reserve := int64(Cfg.ContextReserveTokens)
+	if req.MaxTokens != nil && *req.MaxTokens > 0 {
+		reserve = int64(*req.MaxTokens)
+	}
+	budget := limit - reserve
+	if len(req.Tools) > 0 {
+		budget -= CountTokens(GenerateToolPrompt(req.Tools, req.ToolChoice))
+	}
+	return budget
+}
+
+// chainContextWindow 返回降级链中最小的上下文窗口及对应模型，请求降级后仍需放得下
+func chainContextWindow(model string) (string, int64) {
+	limitModel, limit := "", int64(0)
+	for _, m := range GetFallbackChain(model) {
+		mapping, ok := GetModelMapping(m)
+		if !ok || mapping.ContextLength <= 0 {
+			continue
+		}
+		if limit == 0 || int64(mapping.ContextLength) < limit {
+			limitModel, limit = m, int64(mapping.ContextLength)
+		}
+	}
+	return limitModel, limit
+}
+
+// ApplyContextStrategy 在请求超出模型上下文窗口时按配置策略裁剪消息（按降级链中最小的窗口计算）
+// 未做任何处理时返回的 ContextResult 为 nil
+func ApplyContextStrategy(token string, req *ChatRequest) ([]Message, *ContextResult, error) {
+	messages := req.Messages
+	strategy := strings.ToLower(Cfg.ContextStrategy)
+	if strategy == ContextStrategyNone || strategy == "" {
+		return messages, nil, nil
+	}
+	limitModel, limit := chainContextWindow(req.Model)
+	if limit <= 0 {
+		return messages, nil, nil
+	}
+
+	budget := contextBudget(req, limit)
+	groups := groupMessages(messages)
+	original := sumGroupTokens(groups)
+	if original <= budget {
+		return messages, nil, nil
+	}
+
+	result := &ContextResult{Strategy: strategy, Limit: limit, OriginalTokens: original}
+	drop := make(map[int]bool)
+	total := original
+	for _, idx := range dropOrder(groups, strategy) {
+		if total <= budget {
+			break
+		}
+		drop[idx] = true
+		total -= groups[idx].tokens
+	}
+
+	if strategy == ContextStrategySummarize && len(drop) > 0 {
+		if summarized, count, ok := summarizeGroups(token, groups, drop); ok {
+			groups = summarized
+			result.Summarized = count
+			drop = make(map[int]bool)
+			// 摘要本身仍可能超出预算，继续按 truncate 丢弃
+			total = sumGroupTokens(groups)
+			for _, idx := range dropOrder(groups, ContextStrategyTruncate) {
+				if total <= budget {
+					break
+				}
+				drop[idx] = true
+				total -= groups[idx].tokens
+			}
+		} else {
+			result.Strategy = ContextStrategyTruncate
+		}
+	}
+
+	var kept []messageGroup
+	for i, g := range groups {
+		if drop[i] {
+			result.Dropped += len(g.messages)
+			continue
+		}
+		kept = append(kept, g)
+	}
+	result.FinalTokens = sumGroupTokens(kept)
+
+	if result.FinalTokens > budget {
+		return nil, result, fmt.Errorf("消息长度 %d tokens 超出模型 %s 的上下文限制 %d tokens", result.FinalTokens, limitModel, limit)
+	}
+	return flattenGroups(kept), result, nil
+}
+
+func contentText(msg Message) string {
+	text, _ := msg.ParseContent()
+	return text
+}
+
+const contextSummaryPrefix = "以下是此前对话的摘要：\n"
+
+const contextSummaryPrompt = `你是对话压缩助手。请将用户提供的对话记录压缩为简洁的摘要，保留关键事实、用户偏好、已做出的决定、工具调用结果和尚未解决的问题。只输出摘要正文，不要添加任何解释。`
+
+// summarizeGroups 使用摘要模型压缩待丢弃的分组，并将摘要插入到首个被丢弃分组的位置
+// 返回新的分组列表和被摘要替代的消息数
+func summarizeGroups(token string, groups []messageGroup, drop map[int]bool) ([]messageGroup, int, bool) {
+	var transcript strings.Builder
+	first := -1
+	count := 0
+	for i, g := range groups {
+		if !drop[i] {
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		count += len(g.messages)
+		for _, msg := range g.messages {
+			text := contentText(msg)
+			for _, tc := range msg.ToolCalls {
+				text += fmt.Sprintf("\n[调用工具 %s: %s]", tc.Function.Name, tc.Function.Arguments)
+			}
+			transcript.WriteString(fmt.Sprintf("%s: %s\n\n", msg.Role, text))
+		}
+	}
+	if first == -1 {
+		return groups, 0, false
+	}
+
+	summary, err := requestSummary(token, transcript.String())
+	if err != nil {
+		LogWarn("[Context] 摘要失败，回退到截断: %v", err)
+		return groups, 0, false
+	}
+
+	summaryMsg := Message{Role: "system", Content: contextSummaryPrefix + summary}
+	var out []messageGroup
+	for i, g := range groups {
+		if i == first {
+			out = append(out, messageGroup{
+				messages: []Message{summaryMsg},
+				tokens:   countGroupTokens([]Message{summaryMsg}),
+				pinned:   true,
+			})
+		}
+		if drop[i] {
+			continue
+		}
+		out = append(out, g)
+	}
+	return out, count, true
+}
+
+// requestSummary 调用摘要模型生成摘要文本
+func requestSummary(token, transcript string) (string, error) {
+	prompt := []Message{
+		{Role: "system", Content: contextSummaryPrompt},
+		{Role: "user", Content: transcript},
+	}
+	resp, modelName, err := makeUpstreamRequest(token, prompt, Cfg.SummaryModel, nil, false, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	buf := newResponseBuffer()
+	result := handleNonStreamResponseWithRetry(buf, resp.Body, "summary", modelName, 0, nil, imageFormatRaw, Cfg.CitationMode)
+	if !result.Success || !result.HasContent {
+		return "", fmt.Errorf("%s", result.ErrorMessage)
+	}
+
+	var completion ChatCompletionResponse
+	if err := json.Unmarshal(buf.body.Bytes(), &completion); err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 || completion.Choices[0].Message == nil {
+		return "", fmt.Errorf("empty summary")
+	}
+	summary := strings.TrimSpace(completion.Choices[0].Message.Content)
+	if summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summary, nil
+}
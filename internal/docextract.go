@@ -0,0 +1,586 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxExtractBytes 解压单个文档部件的大小上限，防止压缩炸弹
+const maxExtractBytes = 64 * 1024 * 1024
+
+var errExtractTooLarge = errors.New("解压后内容过大")
+
+// extractPlainText 文本文件：处理 BOM 和 UTF-16，非法字节替换为 U+FFFD
+func extractPlainText(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		data = data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false), nil
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true), nil
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return "", errors.New("文件包含二进制内容")
+	}
+	if utf8.Valid(data) {
+		return string(data), nil
+	}
+	return strings.ToValidUTF8(string(data), "�"), nil
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			u = append(u, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			u = append(u, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// readZipEntry 读取 zip 中的文件，限制解压大小
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxExtractBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxExtractBytes {
+		return nil, errExtractTooLarge
+	}
+	return data, nil
+}
+
+// numberedZipEntries 返回匹配 pattern（第一个分组为序号）的 zip 文件，按序号排序
+func numberedZipEntries(zr *zip.Reader, pattern *regexp.Regexp) []*zip.File {
+	type entry struct {
+		n int
+		f *zip.File
+	}
+	var entries []entry
+	for _, f := range zr.File {
+		if m := pattern.FindStringSubmatch(f.Name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			entries = append(entries, entry{n, f})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].n < entries[j].n })
+	files := make([]*zip.File, len(entries))
+	for i, e := range entries {
+		files[i] = e.f
+	}
+	return files
+}
+
+// extractXMLText 提取 OOXML 中 textTag 元素的文本，paraTag 结束时换行
+func extractXMLText(data []byte, textTag, paraTag string) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var sb strings.Builder
+	inText := false
+	inProps := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case textTag:
+				inText = true
+			case "pPr":
+				inProps++
+			case "tab":
+				if inProps == 0 {
+					sb.WriteByte('\t')
+				}
+			case "br":
+				sb.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case textTag:
+				inText = false
+			case "pPr":
+				inProps--
+			case paraTag:
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// extractDOCXText Word 文档：读取 word/document.xml 的段落文本
+func extractDOCXText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			content, err := readZipEntry(f)
+			if err != nil {
+				return "", err
+			}
+			return extractXMLText(content, "t", "p")
+		}
+	}
+	return "", errors.New("缺少 word/document.xml")
+}
+
+var pptxSlidePattern = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// extractPPTXText PowerPoint 文档：按页读取幻灯片文本
+func extractPPTXText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, f := range numberedZipEntries(zr, pptxSlidePattern) {
+		content, err := readZipEntry(f)
+		if err != nil {
+			return "", err
+		}
+		text, err := extractXMLText(content, "t", "p")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "## Slide %d\n%s\n", i+1, strings.TrimSpace(text))
+	}
+	return sb.String(), nil
+}
+
+var xlsxSheetPattern = regexp.MustCompile(`^xl/worksheets/sheet(\d+)\.xml$`)
+
+// extractXLSXText Excel 文档：按工作表输出，每行单元格以制表符分隔
+func extractXLSXText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var shared []string
+	for _, f := range zr.File {
+		if f.Name == "xl/sharedStrings.xml" {
+			content, err := readZipEntry(f)
+			if err != nil {
+				return "", err
+			}
+			if shared, err = parseSharedStrings(content); err != nil {
+				return "", err
+			}
+			break
+		}
+	}
+	var sb strings.Builder
+	for i, f := range numberedZipEntries(zr, xlsxSheetPattern) {
+		content, err := readZipEntry(f)
+		if err != nil {
+			return "", err
+		}
+		rows, err := parseSheetRows(content, shared)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "## Sheet %d\n", i+1)
+		for _, row := range rows {
+			sb.WriteString(strings.Join(row, "\t"))
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), nil
+}
+
+// parseSharedStrings 解析共享字符串表，每个 si 可能由多个 t 组成
+func parseSharedStrings(data []byte) ([]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var result []string
+	var cur strings.Builder
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				cur.Reset()
+			case "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				result = append(result, cur.String())
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				cur.Write(t)
+			}
+		}
+	}
+	return result, nil
+}
+
+// parseSheetRows 解析工作表单元格，共享字符串按索引替换
+func parseSheetRows(data []byte, shared []string) ([][]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var rows [][]string
+	var row []string
+	var cellType string
+	var cell strings.Builder
+	inValue := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = nil
+			case "c":
+				cellType = ""
+				cell.Reset()
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "t" {
+						cellType = attr.Value
+					}
+				}
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				value := cell.String()
+				if cellType == "s" {
+					if idx, err := strconv.Atoi(value); err == nil && idx >= 0 && idx < len(shared) {
+						value = shared[idx]
+					}
+				}
+				row = append(row, value)
+			case "row":
+				rows = append(rows, row)
+			}
+		case xml.CharData:
+			if inValue {
+				cell.Write(t)
+			}
+		}
+	}
+	return rows, nil
+}
+
+var (
+	pdfStreamKeyword    = []byte("stream")
+	pdfEndStreamKeyword = []byte("endstream")
+)
+
+// extractPDFText 尽力提取 PDF 文本：解压 FlateDecode 内容流，读取 BT/ET 中的文本操作符
+// 扫描件和使用 CID 字体编码的文本无法提取
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", errors.New("不是有效的 PDF 文件")
+	}
+	var sb strings.Builder
+	pos := 0
+	for {
+		idx := bytes.Index(data[pos:], pdfStreamKeyword)
+		if idx < 0 {
+			break
+		}
+		start := pos + idx
+		dict := data[pos:start]
+		if objIdx := bytes.LastIndex(dict, []byte("obj")); objIdx >= 0 {
+			dict = dict[objIdx:]
+		}
+		bodyStart := start + len(pdfStreamKeyword)
+		if bodyStart < len(data) && data[bodyStart] == '\r' {
+			bodyStart++
+		}
+		if bodyStart < len(data) && data[bodyStart] == '\n' {
+			bodyStart++
+		}
+		end := bytes.Index(data[bodyStart:], pdfEndStreamKeyword)
+		if end < 0 {
+			break
+		}
+		body := data[bodyStart : bodyStart+end]
+		pos = bodyStart + end + len(pdfEndStreamKeyword)
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			decoded, err := io.ReadAll(io.LimitReader(zr, maxExtractBytes+1))
+			zr.Close()
+			if len(decoded) > maxExtractBytes {
+				return "", errExtractTooLarge
+			}
+			if err != nil && len(decoded) == 0 {
+				continue
+			}
+			body = decoded
+		case bytes.Contains(dict, []byte("/Filter")):
+			// 图片等其他编码的流
+			continue
+		}
+		sb.WriteString(parsePDFContentText(body))
+	}
+	return collapseBlankLines(sb.String()), nil
+}
+
+// parsePDFContentText 解析内容流中的 Tj、TJ、'、" 文本操作符
+func parsePDFContentText(content []byte) string {
+	var sb strings.Builder
+	var operands []string
+	var array []string
+	inArray, inText := false, false
+	var lastNumbers []float64
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, next := readPDFLiteralString(content, i)
+			i = next
+			if inArray {
+				array = append(array, s)
+			} else {
+				operands = append(operands, s)
+			}
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '<':
+			s, next := readPDFHexString(content, i)
+			i = next
+			if inArray {
+				array = append(array, s)
+			} else {
+				operands = append(operands, s)
+			}
+		case c == '[':
+			inArray = true
+			array = nil
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case isPDFWhitespace(c) || c == '>' || c == ')' || c == '{' || c == '}':
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFWhitespace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			if i == start {
+				i++
+				continue
+			}
+			token := string(content[start:i])
+			if n, err := strconv.ParseFloat(token, 64); err == nil {
+				if inArray {
+					// TJ 数组中较大的负偏移通常表示单词间距
+					if n < -200 {
+						array = append(array, " ")
+					}
+				} else {
+					lastNumbers = append(lastNumbers, n)
+				}
+				continue
+			}
+			if strings.HasPrefix(token, "/") {
+				continue
+			}
+			switch token {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				sb.WriteByte('\n')
+			case "Tj":
+				if inText {
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"":
+				if inText {
+					sb.WriteByte('\n')
+					sb.WriteString(strings.Join(operands, ""))
+				}
+			case "TJ":
+				if inText {
+					sb.WriteString(strings.Join(array, ""))
+				}
+				array = nil
+			case "T*":
+				if inText {
+					sb.WriteByte('\n')
+				}
+			case "Td", "TD":
+				if inText && len(lastNumbers) >= 2 {
+					if lastNumbers[len(lastNumbers)-1] != 0 {
+						sb.WriteByte('\n')
+					} else if sb.Len() > 0 {
+						sb.WriteByte(' ')
+					}
+				}
+			}
+			operands = nil
+			lastNumbers = nil
+		}
+	}
+	return sb.String()
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) != -1
+}
+
+// readPDFLiteralString 读取 (...) 字符串，处理嵌套括号和转义
+func readPDFLiteralString(content []byte, i int) (string, int) {
+	var buf []byte
+	depth := 0
+	for i++; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(content) {
+				break
+			}
+			switch e := content[i]; e {
+			case 'n':
+				buf = append(buf, '\n')
+			case 'r':
+				buf = append(buf, '\r')
+			case 't':
+				buf = append(buf, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// 续行
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for k := 0; k < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; k++ {
+						v = v*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					buf = append(buf, byte(v))
+				} else {
+					buf = append(buf, e)
+				}
+			}
+		case '(':
+			depth++
+			buf = append(buf, c)
+		case ')':
+			if depth == 0 {
+				return decodePDFString(buf), i + 1
+			}
+			depth--
+			buf = append(buf, c)
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return decodePDFString(buf), i
+}
+
+// readPDFHexString 读取 <...> 十六进制字符串
+func readPDFHexString(content []byte, i int) (string, int) {
+	var digits []byte
+	for i++; i < len(content) && content[i] != '>'; i++ {
+		if c := content[i]; (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	buf := make([]byte, len(digits)/2)
+	for k := range buf {
+		v, _ := strconv.ParseUint(string(digits[2*k:2*k+2]), 16, 8)
+		buf[k] = byte(v)
+	}
+	return decodePDFString(buf), i + 1
+}
+
+// decodePDFString UTF-16BE（带 BOM）或单字节编码；含控制字符的通常为 CID 编码，无法解码时丢弃
+func decodePDFString(b []byte) string {
+	if bytes.HasPrefix(b, []byte{0xFE, 0xFF}) {
+		return decodeUTF16(b[2:], true)
+	}
+	if utf8.Valid(b) {
+		for _, c := range b {
+			if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+				return ""
+			}
+		}
+		return string(b)
+	}
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return ""
+		}
+		runes = append(runes, rune(c))
+	}
+	return string(runes)
+}
+
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+func collapseBlankLines(s string) string {
+	return blankLinesPattern.ReplaceAllString(s, "\n\n")
+}
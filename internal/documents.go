@@ -0,0 +1,398 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidDocument 无效的 file 内容块
+	ErrInvalidDocument = errors.New("无效的文件")
+	// ErrUnsupportedDocument 不支持的文档格式
+	ErrUnsupportedDocument = errors.New("不支持的文件格式")
+	// ErrFileNotFound file_id 不存在
+	ErrFileNotFound = errors.New("文件不存在")
+	// ErrDocumentNoText 无法从文档中提取文本
+	ErrDocumentNoText = errors.New("无法提取文档文本")
+)
+
+// DocumentModeExtract 文档全部在本地提取文本，不上传
+const DocumentModeExtract = "extract"
+
+// FilePart OpenAI file 内容块
+type FilePart struct {
+	FileData string `json:"file_data,omitempty"` // data URL 或纯 base64
+	FileID   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// documentFormat 文档格式：upload 表示上游可以直接解析，extract 为本地文本提取（nil 表示不支持）
+type documentFormat struct {
+	ext     string
+	upload  bool
+	extract func(data []byte) (string, error)
+}
+
+const (
+	mimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimePPTX = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+// documentFormats 支持的文档 MIME 类型
+var documentFormats = map[string]documentFormat{
+	"application/pdf":               {".pdf", true, extractPDFText},
+	mimeDOCX:                        {".docx", true, extractDOCXText},
+	mimeXLSX:                        {".xlsx", true, extractXLSXText},
+	mimePPTX:                        {".pptx", true, extractPPTXText},
+	"application/msword":            {".doc", true, nil},
+	"application/vnd.ms-excel":      {".xls", true, nil},
+	"application/vnd.ms-powerpoint": {".ppt", true, nil},
+	"text/plain":                    {".txt", false, extractPlainText},
+	"text/markdown":                 {".md", false, extractPlainText},
+	"text/csv":                      {".csv", false, extractPlainText},
+	"text/html":                     {".html", false, extractPlainText},
+	"text/xml":                      {".xml", false, extractPlainText},
+	"application/xml":               {".xml", false, extractPlainText},
+	"application/json":              {".json", false, extractPlainText},
+}
+
+// documentExtMap 扩展名到 MIME 类型映射，未列出的代码文件按纯文本处理
+var documentExtMap = map[string]string{
+	".pdf":  "application/pdf",
+	".docx": mimeDOCX,
+	".xlsx": mimeXLSX,
+	".pptx": mimePPTX,
+	".doc":  "application/msword",
+	".xls":  "application/vnd.ms-excel",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".txt":  "text/plain",
+	".log":  "text/plain",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".tsv":  "text/plain",
+	".htm":  "text/html",
+	".html": "text/html",
+	".xml":  "application/xml",
+	".json": "application/json",
+	".yaml": "text/plain",
+	".yml":  "text/plain",
+	".toml": "text/plain",
+	".ini":  "text/plain",
+	".sql":  "text/plain",
+	".sh":   "text/plain",
+	".py":   "text/plain",
+	".go":   "text/plain",
+	".js":   "text/plain",
+	".ts":   "text/plain",
+	".java": "text/plain",
+	".c":    "text/plain",
+	".h":    "text/plain",
+	".cpp":  "text/plain",
+	".rs":   "text/plain",
+}
+
+// detectDocumentType 依次根据声明的 MIME、扩展名和文件内容判断文档类型
+func detectDocumentType(declared, filename string, data []byte) string {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	if _, ok := documentFormats[declared]; ok {
+		return declared
+	}
+	if ct, ok := documentExtMap[strings.ToLower(filepath.Ext(filename))]; ok {
+		return ct
+	}
+	if strings.HasPrefix(declared, "text/") {
+		return "text/plain"
+	}
+	sniffed := http.DetectContentType(data)
+	switch {
+	case strings.HasPrefix(sniffed, "application/pdf"):
+		return "application/pdf"
+	case strings.HasPrefix(sniffed, "text/plain"):
+		return "text/plain"
+	}
+	return ""
+}
+
+// Document 请求中的文档附件
+type Document struct {
+	Ref         string // 消息中的引用标识
+	FileID      string // 来自 /v1/files 时的文件 ID
+	Filename    string
+	ContentType string
+	Data        []byte
+
+	format   documentFormat
+	textOnce sync.Once
+	text     string
+	textErr  error
+}
+
+// Text 本地提取文档文本（只提取一次），超出 DOCUMENT_MAX_TEXT_CHARS 时截断
+func (d *Document) Text() (string, error) {
+	d.textOnce.Do(func() {
+		if d.format.extract == nil {
+			d.textErr = fmt.Errorf("%w: %s 不支持本地提取", ErrDocumentNoText, d.format.ext)
+			return
+		}
+		text, err := d.format.extract(d.Data)
+		if err != nil {
+			d.textErr = fmt.Errorf("%w: %v", ErrDocumentNoText, err)
+			return
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			d.textErr = ErrDocumentNoText
+			return
+		}
+		if max := Cfg.DocumentMaxTextChars; max > 0 && utf8.RuneCountInString(text) > max {
+			text = string([]rune(text)[:max]) + "\n...（内容过长，已截断）"
+		}
+		d.text = text
+	})
+	return d.text, d.textErr
+}
+
+// textPart 将提取的文本包装为 text 内容块
+func (d *Document) textPart(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "text",
+		"text": fmt.Sprintf("\n[文件: %s]\n%s\n[文件结束]\n", d.Filename, text),
+	}
+}
+
+// loadStoredFile 按 file_id 读取 owner 通过 /v1/files 上传的文件
+func loadStoredFile(fileID, owner string) (data []byte, filename string, contentType string, err error) {
+	f, data, err := getFileStore().content(fileID, owner)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+	}
+	return data, f.Filename, f.ContentType, nil
+}
+
+// storedMediaPart file_id 指向图片或视频时，转换为 image_url/video_url 内容块，按媒体上传
+func storedMediaPart(raw interface{}, owner string) map[string]interface{} {
+	fileMap, _ := raw.(map[string]interface{})
+	fileID, _ := fileMap["file_id"].(string)
+	if fileID == "" {
+		return nil
+	}
+	f, err := getFileStore().get(fileID, owner)
+	if err != nil {
+		return nil
+	}
+	switch f.mediaType() {
+	case MediaTypeImage:
+		return map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": fileID}}
+	case MediaTypeVideo:
+		return map[string]interface{}{"type": "video_url", "video_url": map[string]interface{}{"url": fileID}}
+	}
+	return nil
+}
+
+// loadDocument 解析 file 内容块，读取文件数据并识别格式
+func loadDocument(raw interface{}, owner string) (*Document, error) {
+	fileMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: file 内容块缺少 file 字段", ErrInvalidDocument)
+	}
+	var part FilePart
+	part.FileData, _ = fileMap["file_data"].(string)
+	part.FileID, _ = fileMap["file_id"].(string)
+	part.Filename, _ = fileMap["filename"].(string)
+
+	var data []byte
+	var contentType string
+	var err error
+	switch {
+	case part.FileData != "":
+		if strings.HasPrefix(part.FileData, "data:") {
+			data, contentType, err = parseBase64Data(part.FileData)
+		} else {
+			data, err = decodeBase64File(part.FileData)
+		}
+	case part.FileID != "":
+		var storedName string
+		data, storedName, contentType, err = loadStoredFile(part.FileID, owner)
+		if part.Filename == "" {
+			part.Filename = storedName
+		}
+	default:
+		return nil, fmt.Errorf("%w: 需要 file_data 或 file_id", ErrInvalidDocument)
+	}
+	if err != nil {
+		if errors.Is(err, ErrRequestFailed) {
+			return nil, fmt.Errorf("%w: 文件数据解码失败", ErrInvalidDocument)
+		}
+		return nil, err
+	}
+
+	contentType = detectDocumentType(contentType, part.Filename, data)
+	format, ok := documentFormats[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDocument, part.Filename)
+	}
+	filename := filepath.Base(part.Filename)
+	if part.Filename == "" || filename == "." || filename == "/" {
+		filename = "document_" + uuid.New().String()[:8] + format.ext
+	}
+	return &Document{
+		FileID:      part.FileID,
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+		format:      format,
+	}, nil
+}
+
+// decodeBase64File 解码不带 data: 前缀的 base64 数据
+func decodeBase64File(encoded string) ([]byte, error) {
+	if maxBytes := maxUploadFileBytes(); maxBytes > 0 && int64(base64.StdEncoding.DecodedLen(len(encoded))) > maxBytes+2 {
+		return nil, ErrMediaTooLarge
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrRequestFailed
+	}
+	return data, nil
+}
+
+// prepareDocuments 解析消息中的 file 内容块，owner 为 file_id 引用文件的归属
+// 上游可解析的文档替换为引用块，等待按 token 上传；其余文档在本地提取文本并替换为 text 内容块
+func prepareDocuments(messages []Message, owner string) ([]Message, []*Document, error) {
+	var docs []*Document
+	budget := newUploadBudget()
+	out := make([]Message, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		parts, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		changed := false
+		newParts := make([]interface{}, 0, len(parts))
+		for _, item := range parts {
+			part, ok := item.(map[string]interface{})
+			if !ok || part["type"] != "file" {
+				newParts = append(newParts, item)
+				continue
+			}
+			changed = true
+			if mediaPart := storedMediaPart(part["file"], owner); mediaPart != nil {
+				newParts = append(newParts, mediaPart)
+				continue
+			}
+			doc, err := loadDocument(part["file"], owner)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := budget.reserve(int64(len(doc.Data))); err != nil {
+				return nil, nil, err
+			}
+			if doc.format.upload && Cfg.DocumentMode != DocumentModeExtract {
+				doc.Ref = fmt.Sprintf("doc-%d", len(docs)+1)
+				docs = append(docs, doc)
+				newParts = append(newParts, map[string]interface{}{
+					"type": "file",
+					"file": map[string]interface{}{"ref": doc.Ref, "filename": doc.Filename},
+				})
+				continue
+			}
+			text, err := doc.Text()
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", doc.Filename, err)
+			}
+			LogDebug("[Document] Extracted locally: %s (%s), %d chars", doc.Filename, doc.ContentType, utf8.RuneCountInString(text))
+			newParts = append(newParts, doc.textPart(text))
+		}
+		if changed {
+			out[i].Content = newParts
+		}
+	}
+	return out, docs, nil
+}
+
+// UploadDocuments 上传文档到 z.ai，结果与 docs 一一对应，URL 为文档引用标识
+// 来自 /v1/files 的文档复用该文件在 token 下的上传记录
+func UploadDocuments(token string, owner string, docs []*Document) []UploadResult {
+	results := make([]UploadResult, len(docs))
+	for i, doc := range docs {
+		results[i] = UploadResult{URL: doc.Ref, MediaType: MediaTypeFile}
+		var file *UpstreamFile
+		var err error
+		if doc.FileID != "" {
+			file, err = getFileStore().upstreamFile(token, doc.FileID, owner, nil)
+		} else {
+			file, err = uploadData(token, doc.Data, doc.Filename, doc.ContentType, MediaTypeFile)
+		}
+		if err != nil {
+			LogError("upload document %s failed: %v", doc.Filename, err)
+			results[i].Err = err
+			continue
+		}
+		results[i].File = file
+	}
+	return results
+}
+
+// applyDocumentUploads 处理文档引用块：上传成功的通过 files 附加，从消息中移除；
+// 上传失败的改用本地提取的文本，提取也失败时保留上传错误
+func applyDocumentUploads(messages []Message, docs []*Document, results []UploadResult) []Message {
+	if len(docs) == 0 {
+		return messages
+	}
+	byRef := make(map[string]int, len(docs))
+	for i, doc := range docs {
+		byRef[doc.Ref] = i
+	}
+	out := make([]Message, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		parts, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		newParts := make([]interface{}, 0, len(parts))
+		for _, item := range parts {
+			ref := documentRef(item)
+			idx, ok := byRef[ref]
+			if ref == "" || !ok {
+				newParts = append(newParts, item)
+				continue
+			}
+			r := &results[idx]
+			if r.File != nil {
+				continue
+			}
+			text, err := docs[idx].Text()
+			if err != nil {
+				r.Err = fmt.Errorf("%v; %v", r.Err, err)
+				continue
+			}
+			LogWarn("[Document] Upload failed, using extracted text: %s", docs[idx].Filename)
+			r.Err = nil
+			newParts = append(newParts, docs[idx].textPart(text))
+		}
+		out[i].Content = newParts
+	}
+	return out
+}
+
+// documentRef 返回文档引用块的引用标识
+func documentRef(item interface{}) string {
+	part, ok := item.(map[string]interface{})
+	if !ok || part["type"] != "file" {
+		return ""
+	}
+	file, _ := part["file"].(map[string]interface{})
+	ref, _ := file["ref"].(string)
+	return ref
+}
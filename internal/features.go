@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 上游 features 字段中可按请求控制的功能
+const (
+	FeatureWebSearch       = "web_search"
+	FeatureAutoWebSearch   = "auto_web_search"
+	FeatureImageGeneration = "image_generation"
+	FeaturePreviewMode     = "preview_mode"
+)
+
+// defaultVLMServers 默认附加到所有请求的图片处理 MCP 服务器
+var defaultVLMServers = []string{"vlm-image-search", "vlm-image-recognition", "vlm-image-processing"}
+
+// WebSearchOptions OpenAI web_search_options，出现即开启联网搜索
+type WebSearchOptions struct {
+	SearchContextSize string      `json:"search_context_size,omitempty"`
+	UserLocation      interface{} `json:"user_location,omitempty"`
+}
+
+// RequestFeatures 请求扩展字段 features，未设置的开关沿用模型配置
+type RequestFeatures struct {
+	WebSearch       *bool `json:"web_search,omitempty"`
+	AutoWebSearch   *bool `json:"auto_web_search,omitempty"`
+	ImageGeneration *bool `json:"image_generation,omitempty"`
+	PreviewMode     *bool `json:"preview_mode,omitempty"`
+}
+
+// FeatureOptions 单次请求的功能选项和所属 API Key 的策略
+type FeatureOptions struct {
+	WebSearch  *WebSearchOptions
+	MCPServers []string // nil 表示使用模型默认配置
+	Features   *RequestFeatures
+	Policy     *FeaturePolicy
+}
+
+// UpstreamFeatures 最终发送给上游的功能配置
+type UpstreamFeatures struct {
+	WebSearch       bool
+	AutoWebSearch   bool
+	ImageGeneration bool
+	PreviewMode     bool
+	EnableThinking  bool
+	MCPServers      []string
+}
+
+// FeaturePolicy API Key 可开启的功能白名单，MCP 服务器以 mcp: 前缀表示，支持 * 通配
+type FeaturePolicy struct {
+	allow []string
+}
+
+// Allows 判断功能或 MCP 服务器（mcp:name）是否被允许，nil 策略不做限制
+func (p *FeaturePolicy) Allows(name string) bool {
+	if p == nil {
+		return true
+	}
+	for _, pattern := range p.allow {
+		if pattern == name {
+			return true
+		}
+		if strings.Contains(pattern, "*") {
+			if ok, err := path.Match(pattern, name); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String 返回规范化的策略描述，用于缓存键
+func (p *FeaturePolicy) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.allow, "|")
+}
+
+var (
+	featurePolicies     map[string]*FeaturePolicy
+	featurePoliciesOnce sync.Once
+)
+
+// parseFeaturePolicies 解析 "sk-free=web_search|mcp:vlm-*,*=*" 格式的策略配置
+// 键为 API Key，* 表示未单独配置的 Key
+func parseFeaturePolicies(val string) map[string]*FeaturePolicy {
+	policies := make(map[string]*FeaturePolicy)
+	for _, item := range strings.Split(val, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			continue
+		}
+		policy := &FeaturePolicy{}
+		for _, name := range strings.Split(parts[1], "|") {
+			if name = strings.TrimSpace(name); name != "" {
+				policy.allow = append(policy.allow, name)
+			}
+		}
+		sort.Strings(policy.allow)
+		policies[key] = policy
+	}
+	return policies
+}
+
+// GetFeaturePolicy 获取 API Key 对应的功能策略，未配置 FEATURE_POLICIES 时返回 nil（不限制）
+func GetFeaturePolicy(apiKey string) *FeaturePolicy {
+	featurePoliciesOnce.Do(func() {
+		featurePolicies = parseFeaturePolicies(Cfg.FeaturePolicies)
+		if len(featurePolicies) > 0 {
+			LogInfo("已加载 %d 条功能策略", len(featurePolicies))
+		}
+	})
+	if len(featurePolicies) == 0 {
+		return nil
+	}
+	if p, ok := featurePolicies[apiKey]; ok {
+		return p
+	}
+	if p, ok := featurePolicies["*"]; ok {
+		return p
+	}
+	// 配置了策略但未匹配且无默认项：不允许任何可选功能
+	return &FeaturePolicy{}
+}
+
+// resolveUpstreamFeatures 按 模型配置 < web_search_options < 扩展字段 的顺序计算功能开关，最后按策略裁剪
+func resolveUpstreamFeatures(mapping *ModelMapping, opts *FeatureOptions, hasTools bool) UpstreamFeatures {
+	f := UpstreamFeatures{
+		WebSearch:       true,
+		AutoWebSearch:   mapping.AutoWebSearch,
+		ImageGeneration: true,
+		EnableThinking:  mapping.EnableThinking,
+	}
+	if mapping.UpstreamModelID == "glm-4.5v" || mapping.UpstreamModelID == "glm-4.6v" {
+		f.AutoWebSearch = false
+	}
+
+	var mcpServers []string
+	if opts != nil && opts.MCPServers != nil {
+		mcpServers = opts.MCPServers
+	} else {
+		mcpServers = append(append([]string{}, mapping.MCPServers...), defaultVLMServers...)
+	}
+
+	if opts != nil {
+		if opts.WebSearch != nil {
+			f.WebSearch = true
+			f.AutoWebSearch = true
+		}
+		if rf := opts.Features; rf != nil {
+			if rf.WebSearch != nil {
+				f.WebSearch = *rf.WebSearch
+			}
+			if rf.AutoWebSearch != nil {
+				f.AutoWebSearch = *rf.AutoWebSearch
+			}
+			if rf.ImageGeneration != nil {
+				f.ImageGeneration = *rf.ImageGeneration
+			}
+			if rf.PreviewMode != nil {
+				f.PreviewMode = *rf.PreviewMode
+			}
+		}
+	}
+	if hasTools {
+		f.AutoWebSearch = false
+	}
+
+	var policy *FeaturePolicy
+	if opts != nil {
+		policy = opts.Policy
+	}
+	f.WebSearch = f.WebSearch && policy.Allows(FeatureWebSearch)
+	f.AutoWebSearch = f.AutoWebSearch && policy.Allows(FeatureAutoWebSearch)
+	f.ImageGeneration = f.ImageGeneration && policy.Allows(FeatureImageGeneration)
+	f.PreviewMode = f.PreviewMode && policy.Allows(FeaturePreviewMode)
+
+	seen := make(map[string]bool)
+	for _, s := range mcpServers {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		if !policy.Allows("mcp:" + s) {
+			LogDebug("MCP server %s not allowed by policy, skipped", s)
+			continue
+		}
+		f.MCPServers = append(f.MCPServers, s)
+	}
+	return f
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFetchBlocked 远程地址被安全策略拒绝
+var ErrFetchBlocked = errors.New("远程地址不允许访问")
+
+// allowedFetchSchemes 允许抓取的协议
+var allowedFetchSchemes = map[string]bool{"http": true, "https": true}
+
+// blockedNetworks 禁止访问的地址段（回环、私有、链路本地、云元数据等）
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",   // NAT64，可映射到任意 IPv4 地址
+	"64:ff9b:1::/48", // 本地 NAT64
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// hostRule 主机规则：精确域名、*.example.com 通配、IP 或 CIDR
+type hostRule struct {
+	host   string
+	suffix string
+	ipNet  *net.IPNet
+}
+
+func parseHostRules(items []string) []hostRule {
+	var rules []hostRule
+	for _, item := range items {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(item); err == nil {
+			rules = append(rules, hostRule{ipNet: n})
+			continue
+		}
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			rules = append(rules, hostRule{ipNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}})
+			continue
+		}
+		if strings.HasPrefix(item, "*.") {
+			rules = append(rules, hostRule{suffix: item[1:]})
+			continue
+		}
+		rules = append(rules, hostRule{host: item})
+	}
+	return rules
+}
+
+// matchHost 判断主机名是否命中规则
+func matchHost(rules []hostRule, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, r := range rules {
+		switch {
+		case r.ipNet != nil:
+			if ip != nil && r.ipNet.Contains(ip) {
+				return true
+			}
+		case r.suffix != "":
+			if strings.HasSuffix(host, r.suffix) {
+				return true
+			}
+		case r.host == host:
+			return true
+		}
+	}
+	return false
+}
+
+// matchIP 判断 IP 是否命中规则中的 IP/CIDR
+func matchIP(rules []hostRule, ip net.IP) bool {
+	for _, r := range rules {
+		if r.ipNet != nil && r.ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockedIP 判断 IP 是否属于禁止访问的地址段
+func isBlockedIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeFetcher 带 SSRF 防护的远程资源抓取器
+type safeFetcher struct {
+	allowHosts []hostRule
+	denyHosts  []hostRule
+	client     *http.Client
+}
+
+var (
+	safeFetcherInstance *safeFetcher
+	safeFetcherOnce     sync.Once
+)
+
+// getSafeFetcher 获取全局抓取器
+func getSafeFetcher() *safeFetcher {
+	safeFetcherOnce.Do(func() {
+		safeFetcherInstance = newSafeFetcher(Cfg.FetchAllowHosts, Cfg.FetchDenyHosts)
+	})
+	return safeFetcherInstance
+}
+
+// newSafeFetcher 按主机允许/禁止名单创建抓取器
+func newSafeFetcher(allowHosts, denyHosts []string) *safeFetcher {
+	f := &safeFetcher{
+		allowHosts: parseHostRules(allowHosts),
+		denyHosts:  parseHostRules(denyHosts),
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	f.client = &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives:     true,
+			DialContext:           f.dialContext(dialer),
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > Cfg.FetchMaxRedirects {
+				return fmt.Errorf("%w: 重定向次数超过 %d", ErrFetchBlocked, Cfg.FetchMaxRedirects)
+			}
+			// 重定向目标同样需要校验
+			return f.checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// checkURL 校验协议和主机名单
+func (f *safeFetcher) checkURL(u *url.URL) error {
+	if !allowedFetchSchemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("%w: 不支持的协议 %q", ErrFetchBlocked, u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: 缺少主机名", ErrFetchBlocked)
+	}
+	if matchHost(f.denyHosts, host) {
+		return fmt.Errorf("%w: 主机 %s 在禁止列表中", ErrFetchBlocked, host)
+	}
+	if len(f.allowHosts) > 0 && !matchHost(f.allowHosts, host) {
+		return fmt.Errorf("%w: 主机 %s 不在允许列表中", ErrFetchBlocked, host)
+	}
+	return nil
+}
+
+// dialContext 解析域名后逐个校验 IP，并直接连接校验过的 IP，防止 DNS 重绑定
+func (f *safeFetcher) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error = fmt.Errorf("%w: %s 无可用地址", ErrFetchBlocked, host)
+		for _, ipAddr := range ips {
+			ip := ipAddr.IP
+			if matchIP(f.denyHosts, ip) {
+				lastErr = fmt.Errorf("%w: %s 解析到禁止的地址 %s", ErrFetchBlocked, host, ip)
+				continue
+			}
+			// 域名的解析结果不可信，只有显式列出的 IP/CIDR 可以是内网地址
+			if !Cfg.FetchAllowPrivate && !matchIP(f.allowHosts, ip) && isBlockedIP(ip) {
+				lastErr = fmt.Errorf("%w: %s 解析到内网地址 %s", ErrFetchBlocked, host, ip)
+				continue
+			}
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return conn, nil
+		}
+		return nil, lastErr
+	}
+}
+
+// Get 抓取远程资源，校验地址后返回响应
+func (f *safeFetcher) Get(rawURL string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 无效的 URL", ErrFetchBlocked)
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return f.client.Do(req)
+}
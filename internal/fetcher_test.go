@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"64:ff9b::a9fe:a9fe", true}, // NAT64 映射的 169.254.169.254
+		{"64:ff9b::808:808", true},
+		{"64:ff9b:1::1", true},
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"2606:4700:4700::1111", false},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test ip %s", tt.ip)
+		}
+		if got := isBlockedIP(ip); got != tt.blocked {
+			t.Errorf("isBlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	rules := parseHostRules([]string{"example.com", "*.cdn.example.net", "10.0.0.0/8", "192.168.1.5", " "})
+	tests := []struct {
+		host  string
+		match bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com.", true},
+		{"www.example.com", false},
+		{"a.cdn.example.net", true},
+		{"cdn.example.net", false},
+		{"10.2.3.4", true},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+	}
+	for _, tt := range tests {
+		if got := matchHost(rules, tt.host); got != tt.match {
+			t.Errorf("matchHost(%q) = %v, want %v", tt.host, got, tt.match)
+		}
+	}
+}
+
+func TestSafeFetcherCheckURL(t *testing.T) {
+	f := newSafeFetcher([]string{"*.example.com", "203.0.113.0/24"}, []string{"bad.example.com"})
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://img.example.com/a.png", true},
+		{"http://203.0.113.7/a.png", true},
+		{"https://bad.example.com/a.png", false},
+		{"https://other.org/a.png", false},
+		{"ftp://img.example.com/a.png", false},
+		{"file:///etc/passwd", false},
+		{"http:///a.png", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.checkURL(u)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkURL(%s) err = %v, want allowed=%v", tt.url, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrFetchBlocked) {
+			t.Errorf("checkURL(%s) err = %v, want ErrFetchBlocked", tt.url, err)
+		}
+	}
+}
+
+func TestSafeFetcherGet(t *testing.T) {
+	var redirectTo string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, redirectTo, http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+	port := srv.URL[strings.LastIndex(srv.URL, ":")+1:]
+
+	tests := []struct {
+		name       string
+		allowHosts []string
+		denyHosts  []string
+		redirectTo string
+		path       string
+		host       string
+		allowed    bool
+	}{
+		{name: "private ip blocked by default", path: "/", host: "127.0.0.1"},
+		{name: "allowlisted ip", allowHosts: []string{"127.0.0.1"}, path: "/", host: "127.0.0.1", allowed: true},
+		{name: "allowlisted cidr", allowHosts: []string{"127.0.0.0/8"}, path: "/", host: "127.0.0.1", allowed: true},
+		// 域名在允许列表中不代表其解析结果可信
+		{name: "allowlisted hostname resolving to loopback", allowHosts: []string{"localhost"}, path: "/", host: "localhost"},
+		{name: "denied ip", allowHosts: []string{"127.0.0.0/8"}, denyHosts: []string{"127.0.0.1"}, path: "/", host: "127.0.0.1"},
+		{name: "redirect to allowlisted target", allowHosts: []string{"127.0.0.1"}, redirectTo: "/ok", path: "/redirect", host: "127.0.0.1", allowed: true},
+		{name: "redirect outside allowlist", allowHosts: []string{"127.0.0.1"}, redirectTo: "http://localhost:" + port + "/", path: "/redirect", host: "127.0.0.1"},
+		{name: "redirect to denied host", allowHosts: []string{"127.0.0.0/8"}, denyHosts: []string{"127.0.0.2"}, redirectTo: "http://127.0.0.2:" + port + "/", path: "/redirect", host: "127.0.0.1"},
+		{name: "redirect to unsupported scheme", allowHosts: []string{"127.0.0.1"}, redirectTo: "file:///etc/passwd", path: "/redirect", host: "127.0.0.1"},
+		{name: "too many redirects", allowHosts: []string{"127.0.0.1"}, path: "/loop", host: "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirectTo = tt.redirectTo
+			f := newSafeFetcher(tt.allowHosts, tt.denyHosts)
+			resp, err := f.Get("http://"+tt.host+":"+port+tt.path, nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if tt.allowed {
+				if err != nil || resp.StatusCode != http.StatusOK {
+					t.Fatalf("Get = %v, want success", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrFetchBlocked) {
+				t.Fatalf("Get err = %v, want ErrFetchBlocked", err)
+			}
+		})
+	}
+}
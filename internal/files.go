@@ -0,0 +1,508 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const filesPath = "/v1/files"
+
+// defaultFilePurpose 未指定 purpose 时的默认值
+const defaultFilePurpose = "user_data"
+
+var fileIDPattern = regexp.MustCompile(`^file-[a-zA-Z0-9]{8,64}$`)
+
+// isFileID 判断是否为本地文件 ID
+func isFileID(s string) bool {
+	return fileIDPattern.MatchString(s)
+}
+
+// FileObject OpenAI 文件对象
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+}
+
+// FileListResponse 文件列表响应
+type FileListResponse struct {
+	Object string       `json:"object"`
+	Data   []FileObject `json:"data"`
+}
+
+// FileDeleteResponse 删除文件响应
+type FileDeleteResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+// upstreamFileRef 文件在某个 token 下已上传到 z.ai 的记录
+type upstreamFileRef struct {
+	File       UpstreamFile `json:"file"`
+	UploadedAt int64        `json:"uploaded_at"`
+}
+
+// storedFile 本地文件元数据，upstream 按 token 哈希记录已上传的文件
+type storedFile struct {
+	FileObject
+	ContentType string                      `json:"content_type"`
+	Owner       string                      `json:"owner"`
+	Upstream    map[string]*upstreamFileRef `json:"upstream,omitempty"`
+}
+
+// mediaType 根据 MIME 类型返回上传时使用的媒体类型
+func (f *storedFile) mediaType() MediaType {
+	switch {
+	case strings.HasPrefix(f.ContentType, "image/"):
+		return MediaTypeImage
+	case strings.HasPrefix(f.ContentType, "video/"):
+		return MediaTypeVideo
+	}
+	return MediaTypeFile
+}
+
+// fileStore 本地文件存储：<id> 为文件内容，<id>.json 为元数据
+type fileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+var (
+	fileStoreInstance *fileStore
+	fileStoreOnce     sync.Once
+)
+
+// getFileStore 获取全局文件存储
+func getFileStore() *fileStore {
+	fileStoreOnce.Do(func() {
+		if err := os.MkdirAll(Cfg.FilesDir, 0755); err != nil {
+			LogError("[Files] create dir %s failed: %v", Cfg.FilesDir, err)
+		}
+		fileStoreInstance = &fileStore{dir: Cfg.FilesDir}
+	})
+	return fileStoreInstance
+}
+
+// fileOwner 文件归属，使用 API Key 的哈希，避免明文落盘
+func fileOwner(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// requestOwner 请求的文件归属，内部请求沿用发起者的归属
+func requestOwner(r *http.Request, apiKey string) string {
+	if caller, ok := r.Context().Value(internalCallerKey{}).(*internalCaller); ok {
+		return caller.owner
+	}
+	return fileOwner(apiKey)
+}
+
+// tokenKey 上游 token 的哈希
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *fileStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *fileStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// maxFileBytes 按用途返回单个文件大小上限：批处理输入使用 BATCH_MAX_FILE_MB，
+// 服务端生成的批处理结果不限制，其余使用 UPLOAD_MAX_FILE_MB
+func maxFileBytes(purpose string) int64 {
+	switch purpose {
+	case "batch":
+		return int64(Cfg.BatchMaxFileMB) * 1024 * 1024
+	case "batch_output":
+		return 0
+	}
+	return maxUploadFileBytes()
+}
+
+// create 保存上传的文件，超过该用途的大小上限时返回 ErrMediaTooLarge
+func (s *fileStore) create(owner, filename, purpose, contentType string, r io.Reader) (*storedFile, error) {
+	id := "file-" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+	tmp := s.dataPath(id) + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	reader := r
+	maxBytes := maxFileBytes(purpose)
+	if maxBytes > 0 {
+		reader = io.LimitReader(r, maxBytes+1)
+	}
+	// 保留文件头用于识别类型
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(reader, head)
+	head = head[:n]
+	written, err := io.Copy(out, io.MultiReader(bytes.NewReader(head), reader))
+	out.Close()
+	if err == nil && maxBytes > 0 && written > maxBytes {
+		err = fmt.Errorf("%w: 文件超过 %d MB", ErrMediaTooLarge, maxBytes>>20)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, s.dataPath(id)); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	f := &storedFile{
+		FileObject: FileObject{
+			ID:        id,
+			Object:    "file",
+			Bytes:     written,
+			CreatedAt: time.Now().Unix(),
+			Filename:  filename,
+			Purpose:   purpose,
+			Status:    "processed",
+		},
+		ContentType: detectStoredFileType(contentType, filename, head),
+		Owner:       owner,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveLocked(f); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	return f, nil
+}
+
+// detectStoredFileType 识别文件 MIME 类型：图片和视频优先使用声明或嗅探结果，其余按文档规则识别
+func detectStoredFileType(declared, filename string, head []byte) string {
+	declared = strings.ToLower(strings.TrimSpace(strings.SplitN(declared, ";", 2)[0]))
+	if _, ok := mimeExtMap[declared]; ok {
+		return declared
+	}
+	sniffed := http.DetectContentType(head)
+	if strings.HasPrefix(sniffed, "image/") || strings.HasPrefix(sniffed, "video/") {
+		return sniffed
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	for mime, e := range mimeExtMap {
+		if e == ext {
+			return mime
+		}
+	}
+	if ct := detectDocumentType(declared, filename, head); ct != "" {
+		return ct
+	}
+	if declared != "" {
+		return declared
+	}
+	return "application/octet-stream"
+}
+
+func (s *fileStore) saveLocked(f *storedFile) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := s.metaPath(f.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.metaPath(f.ID)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) loadLocked(id string) (*storedFile, error) {
+	if !isFileID(id) {
+		return nil, ErrFileNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	var f storedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		LogError("[Files] corrupt metadata %s: %v", id, err)
+		return nil, ErrFileNotFound
+	}
+	return &f, nil
+}
+
+// get 获取文件元数据，不属于 owner 的文件视为不存在
+func (s *fileStore) get(id, owner string) (*storedFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.loadLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	if f.Owner != owner {
+		return nil, ErrFileNotFound
+	}
+	return f, nil
+}
+
+// list 列出 owner 的文件，按创建时间倒序
+func (s *fileStore) list(owner, purpose string) ([]FileObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []FileObject{}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		f, err := s.loadLocked(strings.TrimSuffix(name, ".json"))
+		if err != nil || f.Owner != owner {
+			continue
+		}
+		if purpose != "" && f.Purpose != purpose {
+			continue
+		}
+		files = append(files, f.FileObject)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files, nil
+}
+
+// delete 删除文件内容和元数据
+func (s *fileStore) delete(id, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.loadLocked(id)
+	if err != nil {
+		return err
+	}
+	if f.Owner != owner {
+		return ErrFileNotFound
+	}
+	os.Remove(s.dataPath(id))
+	return os.Remove(s.metaPath(id))
+}
+
+// content 读取文件内容
+func (s *fileStore) content(id, owner string) (*storedFile, []byte, error) {
+	f, err := s.get(id, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(s.dataPath(id))
+	if err != nil {
+		return nil, nil, ErrFileNotFound
+	}
+	return f, data, nil
+}
+
+// upstreamFile 返回文件在 token 下的上游文件，未上传或记录过期时上传并记录
+func (s *fileStore) upstreamFile(token, id, owner string, budget *uploadBudget) (*UpstreamFile, error) {
+	key := tokenKey(token)
+	ttl := time.Duration(Cfg.UploadCacheTTL) * time.Second
+
+	f, err := s.get(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := budget.reserve(f.Bytes); err != nil {
+		return nil, err
+	}
+	if ref, ok := f.Upstream[key]; ok && ttl > 0 && time.Since(time.Unix(ref.UploadedAt, 0)) < ttl {
+		LogDebug("[Files] Reusing upstream file: %s -> %s", id, ref.File.ID)
+		file := ref.File
+		file.ItemID = uuid.New().String()
+		return &file, nil
+	}
+
+	_, data, err := s.content(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	file, err := uploadData(token, data, f.Filename, f.ContentType, f.mediaType())
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return file, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 重新读取，避免覆盖并发写入的记录
+	latest, err := s.loadLocked(id)
+	if err != nil {
+		return file, nil
+	}
+	if latest.Upstream == nil {
+		latest.Upstream = make(map[string]*upstreamFileRef)
+	}
+	now := time.Now()
+	for k, ref := range latest.Upstream {
+		if now.Sub(time.Unix(ref.UploadedAt, 0)) >= ttl {
+			delete(latest.Upstream, k)
+		}
+	}
+	latest.Upstream[key] = &upstreamFileRef{File: *file, UploadedAt: now.Unix()}
+	if err := s.saveLocked(latest); err != nil {
+		LogWarn("[Files] save upstream record for %s failed: %v", id, err)
+	}
+	return file, nil
+}
+
+// HandleFiles OpenAI Files API：上传、列表、查询、删除和下载内容
+func HandleFiles(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	owner := fileOwner(apiKey)
+	store := getFileStore()
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, filesPath), "/")
+	parts := strings.Split(rest, "/")
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			files, err := store.list(owner, r.URL.Query().Get("purpose"))
+			if err != nil {
+				LogError("[Files] list failed: %v", err)
+				writeErrorResponse(w, http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, FileListResponse{Object: "list", Data: files})
+		case http.MethodPost:
+			handleFileUpload(w, r, store, owner)
+		default:
+			writeInvalidRequestError(w, "Only GET and POST methods are allowed")
+		}
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			f, err := store.get(parts[0], owner)
+			if err != nil {
+				writeFileNotFoundError(w, parts[0])
+				return
+			}
+			writeJSON(w, f.FileObject)
+		case http.MethodDelete:
+			if err := store.delete(parts[0], owner); err != nil {
+				writeFileNotFoundError(w, parts[0])
+				return
+			}
+			LogInfo("[Files] Deleted %s", parts[0])
+			writeJSON(w, FileDeleteResponse{ID: parts[0], Object: "file", Deleted: true})
+		default:
+			writeInvalidRequestError(w, "Only GET and DELETE methods are allowed")
+		}
+	case len(parts) == 2 && parts[1] == "content":
+		if r.Method != http.MethodGet {
+			writeInvalidRequestError(w, "Only GET method is allowed")
+			return
+		}
+		f, data, err := store.content(parts[0], owner)
+		if err != nil {
+			writeFileNotFoundError(w, parts[0])
+			return
+		}
+		// 与 OpenAI 一致按二进制返回，避免上传的 HTML/SVG 在 API 域名下被浏览器渲染
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.Filename))
+		w.Write(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleFileUpload 处理 multipart 上传：file 为文件，purpose 可选
+func handleFileUpload(w http.ResponseWriter, r *http.Request, store *fileStore, owner string) {
+	// purpose 在表单解析后才能读取，请求体先按各用途中较大的上限限制，保存时再按用途检查
+	uploadMax, batchMax := maxFileBytes(defaultFilePurpose), maxFileBytes("batch")
+	maxBytes := max(uploadMax, batchMax)
+	if uploadMax > 0 && batchMax > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20)
+	}
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrTypeInvalidRequest,
+				fmt.Sprintf("文件超过 %d MB", maxBytes>>20), "media_too_large")
+			return
+		}
+		writeInvalidRequestError(w, "无效的 multipart 请求")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeInvalidRequestError(w, "缺少 file 字段")
+		return
+	}
+	defer file.Close()
+
+	purpose := r.FormValue("purpose")
+	if purpose == "" {
+		purpose = defaultFilePurpose
+	}
+	filename := filepath.Base(header.Filename)
+	if filename == "." || filename == "/" {
+		filename = "upload"
+	}
+
+	f, err := store.create(owner, filename, purpose, header.Header.Get("Content-Type"), file)
+	if err != nil {
+		if errors.Is(err, ErrMediaTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrTypeInvalidRequest, err.Error(), "media_too_large")
+			return
+		}
+		LogError("[Files] save failed: %v", err)
+		writeErrorResponse(w, http.StatusInternalServerError)
+		return
+	}
+	LogInfo("[Files] Stored %s: %s (%s, %d bytes)", f.ID, f.Filename, f.ContentType, f.Bytes)
+	writeJSON(w, f.FileObject)
+}
+
+// writeFileNotFoundError 文件不存在错误
+func writeFileNotFoundError(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, ErrTypeNotFound, fmt.Sprintf("文件 '%s' 不存在", id), "file_not_found")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,650 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const geminiPathPrefix = "/v1beta/models/"
+
+// GeminiBlob 内联数据（base64）
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// GeminiFileData 文件引用
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+// GeminiFunctionCall 函数调用
+type GeminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// GeminiFunctionResponse 函数调用结果
+type GeminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+// GeminiPart 内容片段
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiContent 一轮对话内容，role 为 user 或 model
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiFunctionDeclaration 函数声明
+type GeminiFunctionDeclaration struct {
+	Name                 string          `json:"name"`
+	Description          string          `json:"description,omitempty"`
+	Parameters           json.RawMessage `json:"parameters,omitempty"`
+	ParametersJSONSchema json.RawMessage `json:"parametersJsonSchema,omitempty"`
+}
+
+// GeminiTool 工具定义
+type GeminiTool struct {
+	FunctionDeclarations  []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+	GoogleSearch          json.RawMessage             `json:"googleSearch,omitempty"`
+	GoogleSearchRetrieval json.RawMessage             `json:"googleSearchRetrieval,omitempty"`
+}
+
+// GeminiToolConfig 函数调用配置
+type GeminiToolConfig struct {
+	FunctionCallingConfig *struct {
+		Mode                 string   `json:"mode,omitempty"` // AUTO, ANY, NONE
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig,omitempty"`
+}
+
+// GeminiThinkingConfig 思考配置
+type GeminiThinkingConfig struct {
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+}
+
+// GeminiGenerationConfig 生成参数（仅支持部分字段）
+type GeminiGenerationConfig struct {
+	Temperature     *float64              `json:"temperature,omitempty"`
+	TopP            *float64              `json:"topP,omitempty"`
+	MaxOutputTokens *int                  `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string              `json:"stopSequences,omitempty"`
+	CandidateCount  *int                  `json:"candidateCount,omitempty"`
+	ThinkingConfig  *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// GeminiRequest generateContent 请求
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiWebChunk 搜索来源
+type GeminiWebChunk struct {
+	URI   string `json:"uri"`
+	Title string `json:"title,omitempty"`
+}
+
+type GeminiGroundingChunk struct {
+	Web *GeminiWebChunk `json:"web,omitempty"`
+}
+
+// GeminiSegment 被引用的文本区间（UTF-8 字节偏移）
+type GeminiSegment struct {
+	StartIndex int    `json:"startIndex"`
+	EndIndex   int    `json:"endIndex"`
+	Text       string `json:"text,omitempty"`
+}
+
+type GeminiGroundingSupport struct {
+	Segment               GeminiSegment `json:"segment"`
+	GroundingChunkIndices []int         `json:"groundingChunkIndices"`
+}
+
+// GeminiGroundingMetadata 搜索引用信息
+type GeminiGroundingMetadata struct {
+	GroundingChunks   []GeminiGroundingChunk   `json:"groundingChunks,omitempty"`
+	GroundingSupports []GeminiGroundingSupport `json:"groundingSupports,omitempty"`
+}
+
+// GeminiCandidate 候选结果
+type GeminiCandidate struct {
+	Content           GeminiContent            `json:"content"`
+	FinishReason      string                   `json:"finishReason,omitempty"`
+	Index             int                      `json:"index"`
+	GroundingMetadata *GeminiGroundingMetadata `json:"groundingMetadata,omitempty"`
+}
+
+// GeminiUsageMetadata 用量信息，candidatesTokenCount 不含思考部分
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int64 `json:"promptTokenCount"`
+	CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+	TotalTokenCount      int64 `json:"totalTokenCount"`
+	ThoughtsTokenCount   int64 `json:"thoughtsTokenCount,omitempty"`
+}
+
+// GeminiResponse generateContent 响应，流式输出时每个 chunk 也使用该结构
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+}
+
+// geminiStatusNames HTTP 状态码对应的 Google API 错误状态
+var geminiStatusNames = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusBadGateway:          "UNAVAILABLE",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+}
+
+// geminiErrorJSON Google API 风格的错误响应体
+func geminiErrorJSON(status int, message string) []byte {
+	name, ok := geminiStatusNames[status]
+	if !ok {
+		name = "UNKNOWN"
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+			"status":  name,
+		},
+	})
+	return append(data, '\n')
+}
+
+func writeGeminiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(geminiErrorJSON(status, message))
+}
+
+// geminiErrorBody 将 OpenAI 格式的错误转换为 Google API 格式
+func geminiErrorBody(status int, body []byte) []byte {
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Message == "" {
+		return body
+	}
+	return geminiErrorJSON(status, errResp.Error.Message)
+}
+
+// geminiFinishReason 映射结束原因
+func geminiFinishReason(reason string) string {
+	if reason == "length" {
+		return "MAX_TOKENS"
+	}
+	return "STOP"
+}
+
+// geminiMediaPart 将内联数据或文件引用转换为内容块：图片、视频走媒体上传，其余作为文档
+func geminiMediaPart(mimeType, url string, inline bool) (map[string]interface{}, error) {
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}}, nil
+	case strings.HasPrefix(mimeType, "video/"):
+		return map[string]interface{}{"type": "video_url", "video_url": map[string]interface{}{"url": url}}, nil
+	}
+	file := map[string]interface{}{}
+	if inline {
+		file["file_data"] = url
+		filename := "document"
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			filename += exts[0]
+		}
+		file["filename"] = filename
+	} else if isFileID(url) {
+		file["file_id"] = url
+	} else {
+		return nil, fmt.Errorf("不支持的文件引用: %s", url)
+	}
+	return map[string]interface{}{"type": "file", "file": file}, nil
+}
+
+// geminiToChatRequest 将 generateContent 请求转换为对话请求
+func geminiToChatRequest(model string, req *GeminiRequest) (*ChatRequest, bool, error) {
+	chatReq := &ChatRequest{Model: model}
+	includeThoughts := false
+
+	if req.SystemInstruction != nil {
+		var texts []string
+		for _, p := range req.SystemInstruction.Parts {
+			if p.Text != "" {
+				texts = append(texts, p.Text)
+			}
+		}
+		if len(texts) > 0 {
+			chatReq.Messages = append(chatReq.Messages, Message{Role: "system", Content: strings.Join(texts, "\n")})
+		}
+	}
+
+	// 函数调用 ID：Gemini 可省略，按函数名依次匹配
+	pendingCalls := make(map[string][]string)
+	for _, content := range req.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		var text strings.Builder
+		var parts []interface{}
+		var toolCalls []ToolCall
+		for _, p := range content.Parts {
+			switch {
+			case p.Thought:
+				// 历史中的思考内容不回传上游
+			case p.FunctionCall != nil:
+				id := p.FunctionCall.ID
+				if id == "" {
+					id = generateCallID()
+				}
+				pendingCalls[p.FunctionCall.Name] = append(pendingCalls[p.FunctionCall.Name], id)
+				var args interface{}
+				json.Unmarshal(p.FunctionCall.Args, &args)
+				toolCalls = append(toolCalls, ToolCall{
+					ID:       id,
+					Type:     "function",
+					Function: ToolCallFunction{Name: p.FunctionCall.Name, Arguments: normalizeArguments(args)},
+				})
+			case p.FunctionResponse != nil:
+				id := p.FunctionResponse.ID
+				if queue := pendingCalls[p.FunctionResponse.Name]; id == "" && len(queue) > 0 {
+					id = queue[0]
+					pendingCalls[p.FunctionResponse.Name] = queue[1:]
+				}
+				chatReq.Messages = append(chatReq.Messages, Message{
+					Role:       "tool",
+					Content:    string(p.FunctionResponse.Response),
+					ToolCallID: id,
+				})
+			case p.InlineData != nil:
+				part, err := geminiMediaPart(p.InlineData.MimeType, "data:"+p.InlineData.MimeType+";base64,"+p.InlineData.Data, true)
+				if err != nil {
+					return nil, false, err
+				}
+				parts = append(parts, part)
+			case p.FileData != nil:
+				part, err := geminiMediaPart(p.FileData.MimeType, p.FileData.FileURI, false)
+				if err != nil {
+					return nil, false, err
+				}
+				parts = append(parts, part)
+			default:
+				text.WriteString(p.Text)
+			}
+		}
+		if text.Len() == 0 && len(parts) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		msg := Message{Role: role, Content: text.String(), ToolCalls: toolCalls}
+		if len(parts) > 0 {
+			if text.Len() > 0 {
+				parts = append([]interface{}{map[string]interface{}{"type": "text", "text": text.String()}}, parts...)
+			}
+			msg.Content = parts
+		}
+		chatReq.Messages = append(chatReq.Messages, msg)
+	}
+	if len(chatReq.Messages) == 0 {
+		return nil, false, fmt.Errorf("contents 不能为空")
+	}
+
+	for _, tool := range req.Tools {
+		for _, fd := range tool.FunctionDeclarations {
+			params := fd.Parameters
+			if len(params) == 0 {
+				params = fd.ParametersJSONSchema
+			}
+			chatReq.Tools = append(chatReq.Tools, Tool{
+				Type:     "function",
+				Function: ToolFunction{Name: fd.Name, Description: fd.Description, Parameters: params},
+			})
+		}
+		if len(tool.GoogleSearch) > 0 || len(tool.GoogleSearchRetrieval) > 0 {
+			chatReq.WebSearchOptions = &WebSearchOptions{}
+		}
+	}
+
+	if req.ToolConfig != nil && req.ToolConfig.FunctionCallingConfig != nil {
+		fc := req.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(fc.Mode) {
+		case "NONE":
+			chatReq.ToolChoice = "none"
+		case "ANY":
+			chatReq.ToolChoice = "required"
+			if len(fc.AllowedFunctionNames) == 1 {
+				chatReq.ToolChoice = map[string]interface{}{
+					"type":     "function",
+					"function": map[string]interface{}{"name": fc.AllowedFunctionNames[0]},
+				}
+			}
+		case "AUTO":
+			chatReq.ToolChoice = "auto"
+		}
+	}
+
+	if gc := req.GenerationConfig; gc != nil {
+		if gc.CandidateCount != nil && *gc.CandidateCount > 1 {
+			return nil, false, fmt.Errorf("candidateCount 仅支持 1")
+		}
+		chatReq.Temperature = gc.Temperature
+		chatReq.TopP = gc.TopP
+		chatReq.MaxTokens = gc.MaxOutputTokens
+		if len(gc.StopSequences) > 0 {
+			chatReq.Stop = gc.StopSequences
+		}
+		if tc := gc.ThinkingConfig; tc != nil {
+			includeThoughts = tc.IncludeThoughts
+			if tc.IncludeThoughts || (tc.ThinkingBudget != nil && *tc.ThinkingBudget != 0) {
+				chatReq.Model = thinkingVariant(chatReq.Model)
+			}
+		}
+	}
+	return chatReq, includeThoughts, nil
+}
+
+// geminiGrounding 根据 url_citation 注解生成 groundingMetadata
+type geminiGrounding struct {
+	text        strings.Builder
+	annotations []Annotation
+}
+
+func (g *geminiGrounding) metadata() *GeminiGroundingMetadata {
+	if len(g.annotations) == 0 {
+		return nil
+	}
+	text := g.text.String()
+	// 注解按字符计，Gemini 按 UTF-8 字节计
+	runeOffsets := make([]int, 0, utf8.RuneCountInString(text)+1)
+	for i := range text {
+		runeOffsets = append(runeOffsets, i)
+	}
+	runeOffsets = append(runeOffsets, len(text))
+	byteOffset := func(runeIdx int) int {
+		if runeIdx < 0 {
+			return 0
+		}
+		if runeIdx >= len(runeOffsets) {
+			return len(text)
+		}
+		return runeOffsets[runeIdx]
+	}
+
+	meta := &GeminiGroundingMetadata{}
+	chunkIndex := make(map[string]int)
+	for _, a := range g.annotations {
+		c := a.URLCitation
+		if c == nil || c.URL == "" {
+			continue
+		}
+		idx, ok := chunkIndex[c.URL]
+		if !ok {
+			idx = len(meta.GroundingChunks)
+			chunkIndex[c.URL] = idx
+			meta.GroundingChunks = append(meta.GroundingChunks, GeminiGroundingChunk{Web: &GeminiWebChunk{URI: c.URL, Title: c.Title}})
+		}
+		start, end := byteOffset(c.StartIndex), byteOffset(c.EndIndex)
+		if end <= start {
+			continue
+		}
+		meta.GroundingSupports = append(meta.GroundingSupports, GeminiGroundingSupport{
+			Segment:               GeminiSegment{StartIndex: start, EndIndex: end, Text: text[start:end]},
+			GroundingChunkIndices: []int{idx},
+		})
+	}
+	if len(meta.GroundingChunks) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// geminiUsage 转换用量信息
+func geminiUsage(u *Usage) *GeminiUsageMetadata {
+	if u == nil {
+		return nil
+	}
+	var thoughts int64
+	if u.CompletionTokensDetails != nil {
+		thoughts = u.CompletionTokensDetails.ReasoningTokens
+	}
+	return &GeminiUsageMetadata{
+		PromptTokenCount:     u.PromptTokens,
+		CandidatesTokenCount: u.CompletionTokens - thoughts,
+		TotalTokenCount:      u.TotalTokens,
+		ThoughtsTokenCount:   thoughts,
+	}
+}
+
+// geminiParts 生成思考、正文和函数调用片段
+func geminiParts(reasoning, content string, toolCalls []ToolCall, includeThoughts bool) []GeminiPart {
+	var parts []GeminiPart
+	if includeThoughts && reasoning != "" {
+		parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+	}
+	if content != "" {
+		parts = append(parts, GeminiPart{Text: content})
+	}
+	for _, tc := range toolCalls {
+		args := json.RawMessage(tc.Function.Arguments)
+		if !json.Valid(args) {
+			args = json.RawMessage("{}")
+		}
+		parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{ID: tc.ID, Name: tc.Function.Name, Args: args}})
+	}
+	return parts
+}
+
+// geminiStream 将对话接口的流式 chunk 转换为 streamGenerateContent 格式（SSE 或 JSON 数组）
+type geminiStream struct {
+	w               http.ResponseWriter
+	sse             bool
+	includeThoughts bool
+	wrote           bool
+	done            bool
+	responseID      string
+	model           string
+	finishReason    string
+	usage           *Usage
+	grounding       geminiGrounding
+}
+
+func (s *geminiStream) onChunk(chunk *ChatCompletionChunkResponse) {
+	if s.responseID == "" {
+		s.responseID = strings.TrimPrefix(chunk.ID, "chatcmpl-")
+	}
+	s.model = chunk.Model
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil {
+			s.finishReason = *choice.FinishReason
+		}
+		if choice.Delta == nil {
+			continue
+		}
+		s.grounding.text.WriteString(choice.Delta.Content)
+		s.grounding.annotations = append(s.grounding.annotations, choice.Delta.Annotations...)
+		parts := geminiParts(choice.Delta.ReasoningContent, choice.Delta.Content, choice.Delta.ToolCalls, s.includeThoughts)
+		if len(parts) > 0 {
+			s.write(GeminiResponse{Candidates: []GeminiCandidate{{Content: GeminiContent{Role: "model", Parts: parts}}}})
+		}
+	}
+}
+
+func (s *geminiStream) onDone() {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.write(GeminiResponse{
+		Candidates: []GeminiCandidate{{
+			Content:           GeminiContent{Role: "model", Parts: []GeminiPart{}},
+			FinishReason:      geminiFinishReason(s.finishReason),
+			GroundingMetadata: s.grounding.metadata(),
+		}},
+		UsageMetadata: geminiUsage(s.usage),
+	})
+	if !s.sse {
+		s.w.Write([]byte("]\n"))
+	}
+	s.flush()
+}
+
+func (s *geminiStream) write(resp GeminiResponse) {
+	resp.ModelVersion = s.model
+	resp.ResponseID = s.responseID
+	data, _ := json.Marshal(resp)
+	switch {
+	case s.sse:
+		fmt.Fprintf(s.w, "data: %s\r\n\r\n", data)
+	case !s.wrote:
+		fmt.Fprintf(s.w, "[%s", data)
+	default:
+		fmt.Fprintf(s.w, ",\r\n%s", data)
+	}
+	s.wrote = true
+	s.flush()
+}
+
+func (s *geminiStream) flush() {
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// HandleGemini Gemini 兼容接口：POST /v1beta/models/{model}:generateContent 和 :streamGenerateContent
+func HandleGemini(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, geminiPathPrefix)
+	sep := strings.LastIndex(rest, ":")
+	if sep <= 0 {
+		writeGeminiError(w, http.StatusNotFound, "未知的接口")
+		return
+	}
+	model, method := rest[:sep], rest[sep+1:]
+	if method != "generateContent" && method != "streamGenerateContent" {
+		writeGeminiError(w, http.StatusNotFound, fmt.Sprintf("不支持的方法 %s", method))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeGeminiError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	// Gemini 客户端通过 x-goog-api-key 请求头或 key 参数传递 API Key
+	if r.Header.Get("Authorization") == "" {
+		key := r.Header.Get("x-goog-api-key")
+		if key == "" {
+			key = r.URL.Query().Get("key")
+		}
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+	}
+	authBuf := newResponseBuffer()
+	apiKey, ok := authenticateRequest(authBuf, r)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(authBuf.statusCode)
+		w.Write(geminiErrorBody(authBuf.statusCode, authBuf.body.Bytes()))
+		return
+	}
+
+	var req GeminiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "无效的请求格式")
+		return
+	}
+	chatReq, includeThoughts, err := geminiToChatRequest(model, &req)
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chatReq.Stream = method == "streamGenerateContent"
+	if chatReq.Stream {
+		chatReq.StreamOptions = &struct {
+			IncludeUsage bool `json:"include_usage,omitempty"`
+		}{IncludeUsage: true}
+	}
+
+	// 搜索结果以 annotations 返回，再转换为 groundingMetadata
+	caller := newInternalCaller(r, apiKey)
+	caller.citationMode = CitationModeAnnotations
+	chatHTTPReq := newInternalChatRequest(r, caller, chatReq)
+
+	if chatReq.Stream {
+		s := &geminiStream{w: w, sse: r.URL.Query().Get("alt") == "sse", includeThoughts: includeThoughts}
+		contentType := "application/json"
+		if s.sse {
+			contentType = "text/event-stream"
+		}
+		sw := newChatStreamWriter(w, contentType, s.onChunk, s.onDone)
+		sw.convertError = geminiErrorBody
+		HandleChatCompletions(sw, chatHTTPReq)
+		return
+	}
+
+	buf := newResponseBuffer()
+	HandleChatCompletions(buf, chatHTTPReq)
+	copyResponseHeaders(w.Header(), buf.header)
+	if buf.statusCode != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(buf.statusCode)
+		w.Write(geminiErrorBody(buf.statusCode, buf.body.Bytes()))
+		return
+	}
+
+	var chatResp ChatCompletionResponse
+	if err := json.Unmarshal(buf.body.Bytes(), &chatResp); err != nil || len(chatResp.Choices) == 0 || chatResp.Choices[0].Message == nil {
+		writeGeminiError(w, http.StatusBadGateway, "无法解析上游响应")
+		return
+	}
+	choice := chatResp.Choices[0]
+	finishReason := ""
+	if choice.FinishReason != nil {
+		finishReason = *choice.FinishReason
+	}
+	grounding := geminiGrounding{annotations: choice.Message.Annotations}
+	grounding.text.WriteString(choice.Message.Content)
+	parts := geminiParts(choice.Message.ReasoningContent, choice.Message.Content, choice.Message.ToolCalls, includeThoughts)
+	if parts == nil {
+		parts = []GeminiPart{}
+	}
+	writeJSON(w, GeminiResponse{
+		Candidates: []GeminiCandidate{{
+			Content:           GeminiContent{Role: "model", Parts: parts},
+			FinishReason:      geminiFinishReason(finishReason),
+			GroundingMetadata: grounding.metadata(),
+		}},
+		UsageMetadata: geminiUsage(chatResp.Usage),
+		ModelVersion:  chatResp.Model,
+		ResponseID:    strings.TrimPrefix(chatResp.ID, "chatcmpl-"),
+	})
+}
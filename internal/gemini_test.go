@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeminiToChatRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		check   func(t *testing.T, req *ChatRequest, includeThoughts bool)
+		wantErr bool
+	}{
+		{
+			name: "system instruction and roles",
+			body: `{"systemInstruction":{"parts":[{"text":"你是助手"},{"text":"简洁回答"}]},
+				"contents":[{"role":"user","parts":[{"text":"你好"}]},{"role":"model","parts":[{"text":"想法","thought":true},{"text":"你好！"}]}]}`,
+			check: func(t *testing.T, req *ChatRequest, _ bool) {
+				want := []Message{
+					{Role: "system", Content: "你是助手\n简洁回答"},
+					{Role: "user", Content: "你好"},
+					{Role: "assistant", Content: "你好！"},
+				}
+				if !reflect.DeepEqual(req.Messages, want) {
+					t.Errorf("messages = %+v, want %+v", req.Messages, want)
+				}
+			},
+		},
+		{
+			name: "function responses matched by name",
+			body: `{"contents":[
+				{"role":"user","parts":[{"text":"天气"}]},
+				{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"北京"}}},{"functionCall":{"name":"get_time","args":{}}}]},
+				{"role":"user","parts":[{"functionResponse":{"name":"get_time","response":{"time":"12:00"}}},{"functionResponse":{"name":"get_weather","response":{"weather":"晴"}}}]}]}`,
+			check: func(t *testing.T, req *ChatRequest, _ bool) {
+				if len(req.Messages) != 4 {
+					t.Fatalf("messages = %d, want 4", len(req.Messages))
+				}
+				calls := req.Messages[1].ToolCalls
+				if len(calls) != 2 || calls[0].Function.Arguments != `{"city":"北京"}` {
+					t.Fatalf("tool calls = %+v", calls)
+				}
+				if req.Messages[2].ToolCallID != calls[1].ID || req.Messages[3].ToolCallID != calls[0].ID {
+					t.Errorf("tool results not matched: %s %s", req.Messages[2].ToolCallID, req.Messages[3].ToolCallID)
+				}
+			},
+		},
+		{
+			name: "explicit function call id",
+			body: `{"contents":[{"role":"model","parts":[{"functionCall":{"id":"c1","name":"f"}}]},{"role":"user","parts":[{"functionResponse":{"id":"c1","name":"f","response":{}}}]}]}`,
+			check: func(t *testing.T, req *ChatRequest, _ bool) {
+				if req.Messages[0].ToolCalls[0].ID != "c1" || req.Messages[1].ToolCallID != "c1" {
+					t.Errorf("messages = %+v", req.Messages)
+				}
+			},
+		},
+		{
+			name: "inline media and documents",
+			body: `{"contents":[{"parts":[{"text":"看看"},{"inlineData":{"mimeType":"image/png","data":"AAAA"}},{"inlineData":{"mimeType":"application/pdf","data":"BBBB"}}]}]}`,
+			check: func(t *testing.T, req *ChatRequest, _ bool) {
+				parts, ok := req.Messages[0].Content.([]interface{})
+				if !ok || len(parts) != 3 {
+					t.Fatalf("content = %#v", req.Messages[0].Content)
+				}
+				types := []string{}
+				for _, p := range parts {
+					types = append(types, p.(map[string]interface{})["type"].(string))
+				}
+				if !reflect.DeepEqual(types, []string{"text", "image_url", "file"}) {
+					t.Errorf("part types = %v", types)
+				}
+				file := parts[2].(map[string]interface{})["file"].(map[string]interface{})
+				if file["file_data"] != "data:application/pdf;base64,BBBB" || file["filename"] != "document.pdf" {
+					t.Errorf("file part = %v", file)
+				}
+			},
+		},
+		{
+			name:    "unsupported file uri",
+			body:    `{"contents":[{"parts":[{"fileData":{"mimeType":"application/pdf","fileUri":"gs://bucket/a.pdf"}}]}]}`,
+			wantErr: true,
+		},
+		{
+			name: "tools, search and forced function",
+			body: `{"contents":[{"parts":[{"text":"hi"}]}],
+				"tools":[{"functionDeclarations":[{"name":"f","parametersJsonSchema":{"type":"object"}}]},{"googleSearch":{}}],
+				"toolConfig":{"functionCallingConfig":{"mode":"ANY","allowedFunctionNames":["f"]}}}`,
+			check: func(t *testing.T, req *ChatRequest, _ bool) {
+				if len(req.Tools) != 1 || string(req.Tools[0].Function.Parameters) != `{"type":"object"}` {
+					t.Errorf("tools = %+v", req.Tools)
+				}
+				if req.WebSearchOptions == nil {
+					t.Error("googleSearch not mapped to web_search_options")
+				}
+				choice, _ := json.Marshal(req.ToolChoice)
+				if string(choice) != `{"function":{"name":"f"},"type":"function"}` {
+					t.Errorf("tool_choice = %s", choice)
+				}
+			},
+		},
+		{
+			name: "generation config and thinking",
+			body: `{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"temperature":0.2,"maxOutputTokens":100,"stopSequences":["END"],"thinkingConfig":{"includeThoughts":true}}}`,
+			check: func(t *testing.T, req *ChatRequest, includeThoughts bool) {
+				if req.Temperature == nil || *req.Temperature != 0.2 || req.MaxTokens == nil || *req.MaxTokens != 100 {
+					t.Errorf("generation config not mapped: %+v", req)
+				}
+				if !reflect.DeepEqual(req.Stop, []string{"END"}) {
+					t.Errorf("stop = %v", req.Stop)
+				}
+				if !includeThoughts || req.Model != thinkingVariant("GLM-4.7") {
+					t.Errorf("includeThoughts = %v, model = %s", includeThoughts, req.Model)
+				}
+			},
+		},
+		{name: "empty contents", body: `{"contents":[{"parts":[{"text":""}]}]}`, wantErr: true},
+		{name: "multiple candidates", body: `{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"candidateCount":2}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req GeminiRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			chatReq, includeThoughts, err := geminiToChatRequest("GLM-4.7", &req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				tt.check(t, chatReq, includeThoughts)
+			}
+		})
+	}
+}
+
+func TestGeminiGroundingMetadata(t *testing.T) {
+	var g geminiGrounding
+	g.text.WriteString("北京今天晴，上海有雨。")
+	g.annotations = []Annotation{
+		{Type: "url_citation", URLCitation: &URLCitation{URL: "https://a.example", Title: "A", StartIndex: 0, EndIndex: 5}},
+		{Type: "url_citation", URLCitation: &URLCitation{URL: "https://b.example", StartIndex: 6, EndIndex: 10}},
+		{Type: "url_citation", URLCitation: &URLCitation{URL: "https://a.example", StartIndex: 6, EndIndex: 100}},
+		{Type: "url_citation", URLCitation: &URLCitation{URL: "https://c.example", StartIndex: 3, EndIndex: 3}},
+	}
+	meta := g.metadata()
+	if meta == nil || len(meta.GroundingChunks) != 3 {
+		t.Fatalf("metadata = %+v", meta)
+	}
+	want := []GeminiGroundingSupport{
+		{Segment: GeminiSegment{StartIndex: 0, EndIndex: 15, Text: "北京今天晴"}, GroundingChunkIndices: []int{0}},
+		{Segment: GeminiSegment{StartIndex: 18, EndIndex: 30, Text: "上海有雨"}, GroundingChunkIndices: []int{1}},
+		{Segment: GeminiSegment{StartIndex: 18, EndIndex: 33, Text: "上海有雨。"}, GroundingChunkIndices: []int{0}},
+	}
+	if !reflect.DeepEqual(meta.GroundingSupports, want) {
+		t.Errorf("supports = %+v, want %+v", meta.GroundingSupports, want)
+	}
+
+	if (&geminiGrounding{}).metadata() != nil {
+		t.Error("metadata without annotations should be nil")
+	}
+}
+
+func TestGeminiUsage(t *testing.T) {
+	u := NewUsage(10, 30, 12)
+	got := geminiUsage(u)
+	want := &GeminiUsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 18, TotalTokenCount: 40, ThoughtsTokenCount: 12}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("geminiUsage = %+v, want %+v", got, want)
+	}
+	if geminiUsage(nil) != nil {
+		t.Error("geminiUsage(nil) should be nil")
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	reasoning := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{"reasoning_content":"想一想"}}]}`
+	toolCall := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{\"a\":1}"}}]}}]}`
+	lengthStop := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`
+	usage := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":7,"total_tokens":12}}`
+
+	tests := []struct {
+		name            string
+		sse             bool
+		includeThoughts bool
+		input           string
+		wantText        string
+		wantThought     string
+		wantCalls       int
+		wantReason      string
+	}{
+		{name: "sse", sse: true, input: chatSSE(chatDelta("你好"), chatStop, usage, "[DONE]"), wantText: "你好", wantReason: "STOP"},
+		{name: "json array", input: chatSSE(chatDelta("a"), chatDelta("b"), lengthStop, "[DONE]"), wantText: "ab", wantReason: "MAX_TOKENS"},
+		{name: "thoughts included", sse: true, includeThoughts: true, input: chatSSE(reasoning, chatDelta("答"), chatStop, "[DONE]"), wantText: "答", wantThought: "想一想", wantReason: "STOP"},
+		{name: "thoughts hidden", input: chatSSE(reasoning, chatDelta("答"), chatStop, "[DONE]"), wantText: "答", wantReason: "STOP"},
+		{name: "function call", sse: true, input: chatSSE(toolCall, chatStop, "[DONE]"), wantCalls: 1, wantReason: "STOP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s := &geminiStream{w: rec, sse: tt.sse, includeThoughts: tt.includeThoughts}
+			sw := newChatStreamWriter(rec, "text/event-stream", s.onChunk, s.onDone)
+			sw.Header().Set("Content-Type", "text/event-stream")
+			sw.WriteHeader(http.StatusOK)
+			sw.Write([]byte(tt.input))
+
+			var responses []GeminiResponse
+			body := rec.Body.String()
+			if tt.sse {
+				for _, event := range strings.Split(strings.TrimSpace(body), "\r\n\r\n") {
+					var resp GeminiResponse
+					if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &resp); err != nil {
+						t.Fatalf("invalid SSE event %q: %v", event, err)
+					}
+					responses = append(responses, resp)
+				}
+			} else if err := json.Unmarshal([]byte(body), &responses); err != nil {
+				t.Fatalf("stream is not a JSON array: %v\n%s", err, body)
+			}
+
+			var text, thought, reason string
+			var calls int
+			for _, resp := range responses {
+				if resp.ResponseID != "1" || resp.ModelVersion != "GLM-4.7" {
+					t.Errorf("response id/model = %s/%s", resp.ResponseID, resp.ModelVersion)
+				}
+				for _, c := range resp.Candidates {
+					for _, p := range c.Content.Parts {
+						switch {
+						case p.Thought:
+							thought += p.Text
+						case p.FunctionCall != nil:
+							calls++
+						default:
+							text += p.Text
+						}
+					}
+					if c.FinishReason != "" {
+						reason = c.FinishReason
+					}
+				}
+			}
+			if text != tt.wantText || thought != tt.wantThought || calls != tt.wantCalls || reason != tt.wantReason {
+				t.Errorf("text=%q thought=%q calls=%d reason=%s; want %q %q %d %s",
+					text, thought, calls, reason, tt.wantText, tt.wantThought, tt.wantCalls, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestGeminiErrorBody(t *testing.T) {
+	got := geminiErrorBody(http.StatusNotFound, []byte(`{"error":{"message":"模型不存在","type":"invalid_request_error"}}`))
+	var resp struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(got, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Code != 404 || resp.Error.Message != "模型不存在" || resp.Error.Status != "NOT_FOUND" {
+		t.Errorf("geminiErrorBody = %s", got)
+	}
+}
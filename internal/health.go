@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 后台任务名称
+const (
+	WorkerVersionUpdater = "version_updater"
+	WorkerModelFetcher   = "model_fetcher"
+	WorkerTokenValidator = "token_validator"
+	WorkerBatchRunner    = "batch_runner"
+)
+
+// WorkerStatus 后台任务最近一次运行情况
+type WorkerStatus struct {
+	Runs        int64      `json:"runs"`
+	Failures    int64      `json:"failures"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// UpstreamStatus 最近一次上游对话请求的结果
+type UpstreamStatus struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// HealthCheck 单项就绪检查
+type HealthCheck struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+var (
+	healthMu      sync.Mutex
+	workerStatus  = make(map[string]*WorkerStatus)
+	upstreamState UpstreamStatus
+	// anonymousOK 最近一次获取匿名 token 是否成功（模型获取时探测）
+	anonymousOK *bool
+)
+
+// recordWorkerRun 记录后台任务的运行结果
+func recordWorkerRun(name string, err error) {
+	now := time.Now()
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	ws, ok := workerStatus[name]
+	if !ok {
+		ws = &WorkerStatus{}
+		workerStatus[name] = ws
+	}
+	ws.Runs++
+	ws.LastRun = &now
+	if err != nil {
+		ws.Failures++
+		ws.LastError = err.Error()
+		ws.LastErrorAt = &now
+	} else {
+		ws.LastSuccess = &now
+	}
+}
+
+// recordAnonymousProbe 记录匿名 token 是否可用
+func recordAnonymousProbe(ok bool) {
+	healthMu.Lock()
+	anonymousOK = &ok
+	healthMu.Unlock()
+}
+
+// recordUpstreamResult 记录上游对话请求的结果
+func recordUpstreamResult(errMsg string) {
+	now := time.Now()
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if errMsg == "" {
+		upstreamState.LastSuccess = &now
+		return
+	}
+	upstreamState.LastError = errMsg
+	upstreamState.LastErrorAt = &now
+}
+
+// lastProbe 返回上游探测（模型获取）的最近结果
+func lastProbe() (ran, ok bool, errMsg string) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	ws, exists := workerStatus[WorkerModelFetcher]
+	if !exists || ws.LastRun == nil {
+		return false, false, ""
+	}
+	if ws.LastErrorAt != nil && ws.LastErrorAt.Equal(*ws.LastRun) {
+		return true, false, ws.LastError
+	}
+	return true, true, ""
+}
+
+// readinessChecks 就绪检查：模型目录、前端版本、可用 token、上游探测
+func readinessChecks() map[string]HealthCheck {
+	checks := make(map[string]HealthCheck)
+
+	mappingsLock.RLock()
+	modelCount := len(modelMappings)
+	mappingsLock.RUnlock()
+	if modelCount > 0 {
+		checks["model_catalog"] = HealthCheck{OK: true}
+	} else {
+		checks["model_catalog"] = HealthCheck{Message: "模型目录未加载"}
+	}
+
+	if GetFeVersion() != "" {
+		checks["fe_version"] = HealthCheck{OK: true, Message: GetFeVersion()}
+	} else {
+		checks["fe_version"] = HealthCheck{Message: "尚未获取前端版本"}
+	}
+
+	healthMu.Lock()
+	anonymous := anonymousOK
+	healthMu.Unlock()
+	switch {
+	case GetTokenManager().GetStats().ValidTokenCount > 0:
+		checks["tokens"] = HealthCheck{OK: true, Message: "token pool"}
+	case GetBackupToken() != "":
+		checks["tokens"] = HealthCheck{OK: true, Message: "backup token"}
+	case anonymous != nil && *anonymous:
+		checks["tokens"] = HealthCheck{OK: true, Message: "anonymous"}
+	case anonymous == nil:
+		checks["tokens"] = HealthCheck{Message: "没有有效 token，匿名 token 尚未探测"}
+	default:
+		checks["tokens"] = HealthCheck{Message: "没有有效 token，匿名 token 不可用"}
+	}
+
+	switch ran, ok, errMsg := lastProbe(); {
+	case !ran:
+		checks["upstream"] = HealthCheck{Message: "上游尚未探测"}
+	case !ok:
+		checks["upstream"] = HealthCheck{Message: errMsg}
+	default:
+		checks["upstream"] = HealthCheck{OK: true}
+	}
+
+	if Draining() {
+		checks["shutdown"] = HealthCheck{Message: "服务正在关闭"}
+	} else {
+		checks["shutdown"] = HealthCheck{OK: true}
+	}
+	return checks
+}
+
+func allChecksOK(checks map[string]HealthCheck) bool {
+	for _, c := range checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleHealthz 存活检查：进程能处理 HTTP 请求即返回 200
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// HandleReadyz 就绪检查：任一依赖不可用时返回 503
+func HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := readinessChecks()
+	status := "ready"
+	if !allChecksOK(checks) {
+		status = "not_ready"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// StatusResponse /status 响应
+type StatusResponse struct {
+	Status    string                   `json:"status"`
+	Uptime    string                   `json:"uptime"`
+	StartedAt time.Time                `json:"started_at"`
+	Draining  bool                     `json:"draining"`
+	Checks    map[string]HealthCheck   `json:"checks"`
+	Workers   map[string]*WorkerStatus `json:"workers"`
+	Upstream  UpstreamStatus           `json:"upstream"`
+	Tokens    TokenManagerStats        `json:"tokens"`
+	Models    int                      `json:"models"`
+	Queue     *QueueStats              `json:"queue,omitempty"`
+	Batches   int                      `json:"pending_batches"`
+}
+
+// HandleStatus 详细状态：就绪检查、各后台任务最近运行结果、上游请求情况
+func HandleStatus(w http.ResponseWriter, r *http.Request) {
+	checks := readinessChecks()
+	status := "ready"
+	if !allChecksOK(checks) {
+		status = "not_ready"
+	}
+
+	healthMu.Lock()
+	workers := make(map[string]*WorkerStatus, len(workerStatus))
+	for name, ws := range workerStatus {
+		copied := *ws
+		workers[name] = &copied
+	}
+	upstream := upstreamState
+	healthMu.Unlock()
+
+	mappingsLock.RLock()
+	modelCount := len(modelMappings)
+	mappingsLock.RUnlock()
+
+	writeJSON(w, StatusResponse{
+		Status:    status,
+		Uptime:    formatDuration(time.Since(telemetry.StartTime)),
+		StartedAt: telemetry.StartTime,
+		Draining:  Draining(),
+		Checks:    checks,
+		Workers:   workers,
+		Upstream:  upstream,
+		Tokens:    GetTokenManager().GetStats(),
+		Models:    modelCount,
+		Queue:     GetQueueStats(),
+		Batches:   getBatchManager().pendingCount(),
+	})
+}
@@ -0,0 +1,367 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 生成图片的返回格式
+const (
+	imageFormatRaw     = ""         // 不做处理，返回上游原始地址
+	ImageFormatURL     = "url"      // 返回地址（配置了转存时为本地地址）
+	ImageFormatB64JSON = "b64_json" // 返回 base64 data URL
+)
+
+// imageGenerationMarkers 上游图片生成工具块的特征
+var imageGenerationMarkers = []string{`"image_generation"`, `"generate_image"`, `"image_gen"`, `"text_to_image"`}
+
+var (
+	glmBlockPattern      = regexp.MustCompile(`(?s)<glm_block[^>]*>(.*?)</glm_block>`)
+	markdownImagePattern = regexp.MustCompile(`!\[[^\]]*\]\((https?://[^)\s]+)\)`)
+	imageURLPattern      = regexp.MustCompile(`https?://[^\s"'<>()\\]+\.(?:png|jpe?g|webp|gif)(?:\?[^\s"'<>()\\]*)?`)
+)
+
+// IsImageGenerationContent 判断 edit_content 是否为图片生成结果
+func IsImageGenerationContent(editContent string) bool {
+	if !strings.Contains(editContent, "<glm_block") {
+		return false
+	}
+	for _, marker := range imageGenerationMarkers {
+		if strings.Contains(editContent, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGeneratedImages 从图片生成工具块中提取图片地址（去重，保持顺序）
+func ParseGeneratedImages(editContent string) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(u string) {
+		u = strings.TrimSpace(u)
+		if u == "" || seen[u] {
+			return
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+
+	for _, m := range glmBlockPattern.FindAllStringSubmatch(editContent, -1) {
+		var block interface{}
+		if err := json.Unmarshal([]byte(m[1]), &block); err == nil {
+			collectImageURLs(block, "", add)
+			continue
+		}
+		for _, u := range imageURLPattern.FindAllString(m[1], -1) {
+			add(u)
+		}
+	}
+	for _, m := range markdownImagePattern.FindAllStringSubmatch(editContent, -1) {
+		add(m[1])
+	}
+	return urls
+}
+
+// collectImageURLs 递归查找 JSON 中的图片地址
+func collectImageURLs(v interface{}, key string, add func(string)) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			collectImageURLs(val[k], strings.ToLower(k), add)
+		}
+	case []interface{}:
+		for _, child := range val {
+			collectImageURLs(child, key, add)
+		}
+	case string:
+		switch {
+		case strings.HasPrefix(val, "data:image/"):
+			add(val)
+		case key == "url" || key == "image_url" || key == "image" || key == "src":
+			if strings.HasPrefix(val, "http://") || strings.HasPrefix(val, "https://") {
+				add(val)
+			}
+		default:
+			// 文本中的 markdown 图片或图片链接
+			for _, m := range markdownImagePattern.FindAllStringSubmatch(val, -1) {
+				add(m[1])
+			}
+		}
+	}
+}
+
+// ResolveGeneratedImage 按配置将上游图片地址转换为 image_url 内容块
+// format 为 b64_json 时返回 data URL；配置了 IMAGE_MIRROR_DIR 时转存到本地
+// 下载失败时回退为上游原始地址
+func ResolveGeneratedImage(imageURL, format string) ContentPart {
+	part := ContentPart{Type: "image_url", ImageURL: &MediaURL{URL: imageURL}}
+	if format == imageFormatRaw {
+		return part
+	}
+	if strings.HasPrefix(imageURL, "data:") {
+		if Cfg.ImageMirrorDir != "" && format != ImageFormatB64JSON {
+			if data, contentType, err := parseBase64Data(imageURL); err == nil {
+				if mirrored, err := mirrorImage(data, contentType); err == nil {
+					part.ImageURL.URL = mirrored
+				}
+			}
+		}
+		return part
+	}
+	if format != ImageFormatB64JSON && Cfg.ImageMirrorDir == "" {
+		return part
+	}
+
+	data, contentType, _, err := downloadFromURL(imageURL)
+	if err != nil {
+		LogWarn("[Image] 下载生成的图片失败，返回原始地址: %v", err)
+		return part
+	}
+	if contentType == "" || !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+
+	if Cfg.ImageMirrorDir != "" {
+		if mirrored, err := mirrorImage(data, contentType); err != nil {
+			LogWarn("[Image] 转存图片失败: %v", err)
+		} else if format != ImageFormatB64JSON {
+			part.ImageURL.URL = mirrored
+		}
+	}
+	if format == ImageFormatB64JSON {
+		part.ImageURL.URL = fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(data))
+	}
+	return part
+}
+
+// mirrorImage 将图片保存到 IMAGE_MIRROR_DIR，按内容哈希命名，返回对外访问地址
+func mirrorImage(data []byte, contentType string) (string, error) {
+	if err := os.MkdirAll(Cfg.ImageMirrorDir, 0755); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:16]) + getExtFromMime(contentType, MediaTypeImage)
+	path := filepath.Join(Cfg.ImageMirrorDir, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+	}
+	return strings.TrimRight(Cfg.PublicBaseURL, "/") + imageFilesPath + name, nil
+}
+
+// imageFilesPath 本地转存图片的访问路径
+const imageFilesPath = "/v1/images/files/"
+
+// HandleImageFiles 提供本地转存图片的访问
+func HandleImageFiles(w http.ResponseWriter, r *http.Request) {
+	if Cfg.ImageMirrorDir == "" {
+		http.NotFound(w, r)
+		return
+	}
+	name := filepath.Base(strings.TrimPrefix(r.URL.Path, imageFilesPath))
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, filepath.Join(Cfg.ImageMirrorDir, name))
+}
+
+// ImageGenerationRequest OpenAI /v1/images/generations 请求
+type ImageGenerationRequest struct {
+	Prompt         string `json:"prompt"`
+	Model          string `json:"model,omitempty"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+// ImageData 生成结果中的单张图片
+type ImageData struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+// ImageGenerationResponse OpenAI /v1/images/generations 响应
+type ImageGenerationResponse struct {
+	Created int64       `json:"created"`
+	Data    []ImageData `json:"data"`
+}
+
+// maxImagesPerRequest 单次请求最多生成的图片数
+const maxImagesPerRequest = 4
+
+// HandleImageGenerations 处理 /v1/images/generations，通过聊天接口的图片生成功能实现
+func HandleImageGenerations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeInvalidRequestError(w, "Only POST method is allowed")
+		return
+	}
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req ImageGenerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidRequestError(w, "无效的请求格式")
+		return
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		writeInvalidRequestError(w, "prompt 不能为空")
+		return
+	}
+	if req.Model == "" {
+		req.Model = Cfg.ImageModel
+	}
+	req.Model = ResolveModelAlias(req.Model)
+	if !IsValidModel(req.Model) {
+		writeModelNotFoundError(w, req.Model)
+		return
+	}
+	if req.N <= 0 {
+		req.N = 1
+	}
+	if req.N > maxImagesPerRequest {
+		writeInvalidRequestError(w, fmt.Sprintf("n 不能超过 %d", maxImagesPerRequest))
+		return
+	}
+	format := ImageFormatURL
+	if req.ResponseFormat == ImageFormatB64JSON {
+		format = ImageFormatB64JSON
+	}
+
+	policy := GetFeaturePolicy(apiKey)
+	if !policy.Allows(FeatureImageGeneration) {
+		writeError(w, http.StatusForbidden, ErrTypeInvalidRequest, "当前 API Key 不允许使用图片生成", "feature_not_allowed")
+		return
+	}
+
+	release, ok := acquireRequestSlot(w, r, requestOwner(r, apiKey))
+	if !ok {
+		return
+	}
+	defer release()
+	enabled := true
+	opts := &FeatureOptions{
+		Features: &RequestFeatures{ImageGeneration: &enabled},
+		Policy:   policy,
+	}
+
+	prompt := "请根据以下描述生成一张图片，只返回图片：\n" + req.Prompt
+	if req.Size != "" {
+		prompt += "\n图片尺寸：" + req.Size
+	}
+	messages := []Message{{Role: "user", Content: prompt}}
+	inputTokens := CountMessagesTokens(messages)
+
+	// 每次上游请求返回一张或多张图片；n 张图片共用 n+MaxRetries 次请求的重试预算
+	response := ImageGenerationResponse{Created: time.Now().Unix()}
+	var lastError string
+	var outputTokens int64
+	for attempt := 0; len(response.Data) < req.N && attempt < req.N+MaxRetries; attempt++ {
+		images, tokens, retryable, err := generateImages(messages, req.Model, opts, inputTokens)
+		outputTokens += tokens
+		if err != nil {
+			lastError = err.Error()
+			if !retryable {
+				break
+			}
+			continue
+		}
+		for _, img := range images {
+			if len(response.Data) >= req.N {
+				break
+			}
+			part := ResolveGeneratedImage(img, format)
+			data := ImageData{}
+			if format == ImageFormatB64JSON {
+				if idx := strings.Index(part.ImageURL.URL, ";base64,"); idx != -1 && strings.HasPrefix(part.ImageURL.URL, "data:") {
+					data.B64JSON = part.ImageURL.URL[idx+len(";base64,"):]
+				} else {
+					lastError = "图片下载失败"
+					continue
+				}
+			} else {
+				data.URL = part.ImageURL.URL
+			}
+			response.Data = append(response.Data, data)
+		}
+	}
+	RecordRequest(inputTokens, outputTokens, req.Model)
+
+	if len(response.Data) == 0 {
+		if lastError == "" {
+			lastError = "上游未返回图片"
+		}
+		writeError(w, http.StatusBadGateway, ErrTypeUpstream, fmt.Sprintf("图片生成失败: %s", lastError), "upstream_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// generateImages 发起一次带图片生成功能的聊天请求，返回上游生成的图片地址和输出 token 数
+// retryable 表示失败后是否值得重试（网络错误、5xx、未返回图片）
+func generateImages(messages []Message, model string, opts *FeatureOptions, inputTokens int64) (urls []string, outputTokens int64, retryable bool, err error) {
+	token, err := acquireToken()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	resp, modelName, err := makeUpstreamRequest(token, messages, model, nil, false, opts, nil)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		GetTokenManager().RecordCall(false, false)
+		return nil, 0, resp.StatusCode >= 500, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	buf := newResponseBuffer()
+	result := handleNonStreamResponseWithRetry(buf, resp.Body, "image", modelName, inputTokens, nil, imageFormatRaw, Cfg.CitationMode)
+	GetTokenManager().RecordCall(result.Success, false)
+
+	var completion ChatCompletionResponse
+	if err := json.Unmarshal(buf.body.Bytes(), &completion); err == nil && len(completion.Choices) > 0 && completion.Choices[0].Message != nil {
+		if completion.Usage != nil {
+			outputTokens = completion.Usage.CompletionTokens
+		}
+		for _, img := range completion.Choices[0].Message.Images {
+			if img.ImageURL != nil {
+				urls = append(urls, img.ImageURL.URL)
+			}
+		}
+		if len(urls) > 0 {
+			return urls, outputTokens, false, nil
+		}
+	}
+	if result.ErrorMessage != "" {
+		return nil, outputTokens, true, fmt.Errorf("%s", result.ErrorMessage)
+	}
+	return nil, outputTokens, true, fmt.Errorf("上游未返回图片")
+}
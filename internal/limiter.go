@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull 排队请求数已达上限
+	ErrQueueFull = errors.New("request queue is full")
+	// ErrQueueTimeout 排队超时
+	ErrQueueTimeout = errors.New("request queue timeout")
+)
+
+// limiterWaiter 排队中的请求
+type limiterWaiter struct {
+	key        string
+	ready      chan struct{}
+	granted    bool
+	background bool // 后台任务（批处理），不受队列长度和排队超时限制
+}
+
+// requestLimiter 全局并发限制：超出上限的请求排队，按 API Key 轮转调度，同一 Key 内先进先出
+type requestLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	maxQueue int
+	timeout  time.Duration
+	inflight int
+	queued   int
+	bgQueued int                   // 排队中的后台请求数，不计入队列长度上限
+	queues   map[string]*list.List // API Key -> 等待队列
+	rotation *list.List            // 有排队请求的 API Key，按轮转顺序
+	avgHold  time.Duration         // 请求占用时长的滑动平均，用于估算 Retry-After
+
+	// 统计
+	totalQueued   int64
+	totalRejected int64
+	totalTimeouts int64
+	totalWait     time.Duration
+	maxWait       time.Duration
+}
+
+// QueueStats 请求队列统计
+type QueueStats struct {
+	Limit          int     `json:"limit"`
+	InFlight       int     `json:"in_flight"`
+	Depth          int     `json:"depth"`
+	MaxDepth       int     `json:"max_depth"`
+	TotalQueued    int64   `json:"total_queued"`
+	TotalRejected  int64   `json:"total_rejected"`
+	TotalTimeouts  int64   `json:"total_timeouts"`
+	AvgWaitMs      float64 `json:"avg_wait_ms"`
+	MaxWaitMs      float64 `json:"max_wait_ms"`
+	RetryAfterSecs int     `json:"retry_after_seconds"`
+}
+
+var (
+	requestLimiterInstance *requestLimiter
+	requestLimiterOnce     sync.Once
+)
+
+// getRequestLimiter 获取全局并发限制器，未启用时返回 nil
+func getRequestLimiter() *requestLimiter {
+	requestLimiterOnce.Do(func() {
+		if Cfg.MaxConcurrentRequests <= 0 {
+			return
+		}
+		requestLimiterInstance = newRequestLimiter(Cfg.MaxConcurrentRequests, Cfg.QueueMaxSize, time.Duration(Cfg.QueueTimeout)*time.Second)
+		LogInfo("[Limiter] 并发限制已启用: limit=%d, queue=%d, timeout=%ds", Cfg.MaxConcurrentRequests, Cfg.QueueMaxSize, Cfg.QueueTimeout)
+	})
+	return requestLimiterInstance
+}
+
+func newRequestLimiter(limit, maxQueue int, timeout time.Duration) *requestLimiter {
+	return &requestLimiter{
+		limit:    limit,
+		maxQueue: maxQueue,
+		timeout:  timeout,
+		queues:   make(map[string]*list.List),
+		rotation: list.New(),
+	}
+}
+
+// acquire 获取执行名额，返回释放函数。
+// background 为 true 时（批处理）不受队列长度和排队超时限制，一直等待到获得名额或 ctx 取消
+func (l *requestLimiter) acquire(ctx context.Context, key string, background bool) (func(), error) {
+	l.mu.Lock()
+	if l.inflight < l.limit && l.queued == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return l.releaser(time.Now()), nil
+	}
+	if !background && l.queued-l.bgQueued >= l.maxQueue {
+		l.totalRejected++
+		l.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	w := &limiterWaiter{key: key, ready: make(chan struct{}), background: background}
+	q, ok := l.queues[key]
+	if !ok {
+		q = list.New()
+		l.queues[key] = q
+		l.rotation.PushBack(key)
+	}
+	elem := q.PushBack(w)
+	l.queued++
+	if background {
+		l.bgQueued++
+	}
+	l.totalQueued++
+	l.mu.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if l.timeout > 0 && !background {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wait := time.Since(start)
+	l.totalWait += wait
+	if wait > l.maxWait {
+		l.maxWait = wait
+	}
+	if err != nil && !w.granted {
+		l.remove(key, q, elem)
+		if err == ErrQueueTimeout {
+			l.totalTimeouts++
+		}
+		return nil, err
+	}
+	// 超时与获得名额同时发生时以获得名额为准
+	return l.releaser(time.Now()), nil
+}
+
+// remove 从等待队列中移除请求
+func (l *requestLimiter) remove(key string, q *list.List, elem *list.Element) {
+	l.dequeued(q.Remove(elem).(*limiterWaiter))
+	if q.Len() == 0 {
+		l.dropKey(key)
+	}
+}
+
+func (l *requestLimiter) dequeued(w *limiterWaiter) {
+	l.queued--
+	if w.background {
+		l.bgQueued--
+	}
+}
+
+func (l *requestLimiter) dropKey(key string) {
+	delete(l.queues, key)
+	for e := l.rotation.Front(); e != nil; e = e.Next() {
+		if e.Value.(string) == key {
+			l.rotation.Remove(e)
+			break
+		}
+	}
+}
+
+func (l *requestLimiter) releaser(start time.Time) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			hold := time.Since(start)
+			if l.avgHold == 0 {
+				l.avgHold = hold
+			} else {
+				l.avgHold = (l.avgHold*9 + hold) / 10
+			}
+			l.inflight--
+			l.dispatch()
+		})
+	}
+}
+
+// dispatch 将空出的名额依次分配给轮转顺序中下一个 API Key 的队首请求
+func (l *requestLimiter) dispatch() {
+	for l.inflight < l.limit && l.rotation.Len() > 0 {
+		front := l.rotation.Front()
+		key := front.Value.(string)
+		q := l.queues[key]
+		w := q.Remove(q.Front()).(*limiterWaiter)
+		l.dequeued(w)
+		if q.Len() == 0 {
+			l.rotation.Remove(front)
+			delete(l.queues, key)
+		} else {
+			l.rotation.MoveToBack(front)
+		}
+		w.granted = true
+		l.inflight++
+		close(w.ready)
+	}
+}
+
+// retryAfter 按当前排队长度和平均占用时长估算重试等待秒数
+func (l *requestLimiter) retryAfter() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.retryAfterLocked()
+}
+
+func (l *requestLimiter) retryAfterLocked() int {
+	secs := l.avgHold.Seconds() * float64(l.queued+1) / float64(l.limit)
+	return int(math.Max(1, math.Ceil(secs)))
+}
+
+// Stats 返回队列统计
+func (l *requestLimiter) Stats() *QueueStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := &QueueStats{
+		Limit:          l.limit,
+		InFlight:       l.inflight,
+		Depth:          l.queued,
+		MaxDepth:       l.maxQueue,
+		TotalQueued:    l.totalQueued,
+		TotalRejected:  l.totalRejected,
+		TotalTimeouts:  l.totalTimeouts,
+		MaxWaitMs:      float64(l.maxWait) / float64(time.Millisecond),
+		RetryAfterSecs: l.retryAfterLocked(),
+	}
+	if l.totalQueued > 0 {
+		stats.AvgWaitMs = float64(l.totalWait) / float64(l.totalQueued) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// GetQueueStats 获取请求队列统计，未启用并发限制时返回 nil
+func GetQueueStats() *QueueStats {
+	if l := getRequestLimiter(); l != nil {
+		return l.Stats()
+	}
+	return nil
+}
+
+// acquireRequestSlot 获取上游请求名额；失败时写入 429/503 响应并返回 false。
+// 未启用并发限制时直接返回。
+func acquireRequestSlot(w http.ResponseWriter, r *http.Request, owner string) (func(), bool) {
+	l := getRequestLimiter()
+	if l == nil {
+		return func() {}, true
+	}
+	ctx := r.Context()
+	caller, _ := ctx.Value(internalCallerKey{}).(*internalCaller)
+	background := caller != nil && caller.background
+	if background {
+		// 批处理请求一直排队到获得名额，服务关闭时放弃（重启后重新执行）
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(drainCtx, cancel)
+		defer stop()
+	}
+	release, err := l.acquire(ctx, owner, background)
+	if err == nil {
+		return release, true
+	}
+	retryAfter := strconv.Itoa(l.retryAfter())
+	switch err {
+	case ErrQueueFull:
+		LogWarn("[Limiter] 队列已满，拒绝请求: ip=%s", GetClientIP(r))
+		w.Header().Set("Retry-After", retryAfter)
+		writeError(w, http.StatusServiceUnavailable, ErrTypeServer, "服务繁忙，请稍后重试", "server_overloaded")
+	case ErrQueueTimeout:
+		LogWarn("[Limiter] 排队超时: ip=%s", GetClientIP(r))
+		w.Header().Set("Retry-After", retryAfter)
+		writeError(w, http.StatusTooManyRequests, ErrTypeRateLimit, fmt.Sprintf("排队超过 %d 秒，请稍后重试", Cfg.QueueTimeout), "queue_timeout")
+	default:
+		if background {
+			writeError(w, http.StatusServiceUnavailable, ErrTypeServer, "服务正在关闭", "server_shutdown")
+			break
+		}
+		// 客户端在排队期间断开
+		LogDebug("[Limiter] 排队期间请求取消: %v", err)
+	}
+	return nil, false
+}
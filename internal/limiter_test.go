@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued 等待排队请求数达到 n
+func waitQueued(t *testing.T, l *requestLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		queued := l.queued
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queued requests did not reach %d", n)
+}
+
+// enqueue 在后台排队获取名额，获得名额后按 key 写入 order
+func enqueue(t *testing.T, l *requestLimiter, ctx context.Context, key string, background bool, order chan<- string) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		release, err := l.acquire(ctx, key, background)
+		if err == nil {
+			order <- key
+			release()
+		}
+		done <- err
+	}()
+	return done
+}
+
+func TestRequestLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxQueue   int
+		timeout    time.Duration
+		background bool
+		cancel     bool
+		want       error
+	}{
+		{name: "queue full", maxQueue: 0, want: ErrQueueFull},
+		{name: "queue timeout", maxQueue: 1, timeout: 20 * time.Millisecond, want: ErrQueueTimeout},
+		{name: "context canceled", maxQueue: 1, cancel: true, want: context.Canceled},
+		{name: "background ignores queue limit and timeout", maxQueue: 0, timeout: 20 * time.Millisecond, background: true},
+		{name: "background canceled", maxQueue: 0, background: true, cancel: true, want: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRequestLimiter(1, tt.maxQueue, tt.timeout)
+			release, err := l.acquire(context.Background(), "holder", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := enqueue(t, l, ctx, "waiter", tt.background, make(chan string, 1))
+			if tt.want != ErrQueueFull {
+				waitQueued(t, l, 1)
+			}
+			if tt.cancel {
+				cancel()
+			}
+			if tt.want == nil {
+				// 超过排队超时后仍在等待，释放名额后获得
+				time.Sleep(2 * tt.timeout)
+				release()
+			}
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("acquire err = %v, want %v", err, tt.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("acquire did not return")
+			}
+			release()
+
+			stats := l.Stats()
+			if stats.InFlight != 0 || stats.Depth != 0 || l.bgQueued != 0 || len(l.queues) != 0 || l.rotation.Len() != 0 {
+				t.Fatalf("limiter not drained: inflight=%d depth=%d bg=%d keys=%d", stats.InFlight, stats.Depth, l.bgQueued, len(l.queues))
+			}
+		})
+	}
+}
+
+func TestRequestLimiterBackgroundNotCountedInQueue(t *testing.T) {
+	l := newRequestLimiter(1, 1, 0)
+	release, _ := l.acquire(context.Background(), "holder", false)
+	order := make(chan string, 3)
+	bg := enqueue(t, l, context.Background(), "batch", true, order)
+	waitQueued(t, l, 1)
+	// 后台请求不占用队列名额
+	fg := enqueue(t, l, context.Background(), "online", false, order)
+	waitQueued(t, l, 2)
+	if _, err := l.acquire(context.Background(), "other", false); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("acquire err = %v, want ErrQueueFull", err)
+	}
+	release()
+	for _, done := range []<-chan error{bg, fg} {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequestLimiterRoundRobin(t *testing.T) {
+	l := newRequestLimiter(1, 10, 0)
+	release, _ := l.acquire(context.Background(), "holder", false)
+	order := make(chan string, 4)
+	var done []<-chan error
+	for i, key := range []string{"a", "a", "a", "b"} {
+		done = append(done, enqueue(t, l, context.Background(), key, false, order))
+		waitQueued(t, l, i+1)
+	}
+	release()
+	for _, d := range done {
+		if err := <-d; err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(order)
+	var got []string
+	for key := range order {
+		got = append(got, key)
+	}
+	// 按 API Key 轮转：b 不需要等待 a 的全部请求
+	want := []string{"a", "b", "a", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("dispatch order = %v, want %v", got, want)
+		}
+	}
+}
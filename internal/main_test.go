@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	LoadConfig()
+	InitLogger()
+	// 持久化目录指向临时目录，测试不写入工作目录
+	dir, err := os.MkdirTemp("", "zai-proxy-test")
+	if err != nil {
+		panic(err)
+	}
+	Cfg.FilesDir = filepath.Join(dir, "files")
+	Cfg.BatchDir = filepath.Join(dir, "batches")
+	Cfg.SessionDir = filepath.Join(dir, "sessions")
+	Cfg.CacheDir = filepath.Join(dir, "cache")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// modelAlias 模型别名，pattern 支持 * 通配
+type modelAlias struct {
+	pattern string
+	target  string
+}
+
+// fallbackChain 降级链，pattern 支持 * 通配
+type fallbackChain struct {
+	pattern string
+	chain   []string
+}
+
+var (
+	modelAliases   []modelAlias
+	fallbackChains []fallbackChain
+	aliasLock      sync.RWMutex
+)
+
+// parseModelAliases 解析 "gpt-4o=GLM-4.7,claude-*=GLM-5-Thinking" 格式的别名配置
+func parseModelAliases(val string) []modelAlias {
+	var aliases []modelAlias
+	for _, item := range strings.Split(val, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		pattern := strings.TrimSpace(parts[0])
+		target := strings.TrimSpace(parts[1])
+		if pattern == "" || target == "" {
+			continue
+		}
+		aliases = append(aliases, modelAlias{pattern: pattern, target: target})
+	}
+	return aliases
+}
+
+// parseFallbackChains 解析 "GLM-5=GLM-4.7>GLM-4.6,GLM-4.7=GLM-4.6" 格式的降级链配置
+func parseFallbackChains(val string) []fallbackChain {
+	var chains []fallbackChain
+	for _, item := range strings.Split(val, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		model := strings.TrimSpace(parts[0])
+		if model == "" {
+			continue
+		}
+		var chain []string
+		for _, fb := range strings.Split(parts[1], ">") {
+			if fb = strings.TrimSpace(fb); fb != "" {
+				chain = append(chain, fb)
+			}
+		}
+		if len(chain) > 0 {
+			chains = append(chains, fallbackChain{pattern: model, chain: chain})
+		}
+	}
+	return chains
+}
+
+// legacyModelAliases 兼容旧版 PRIMARY_MODEL 等配置：自定义名称作为对应内置模型的别名
+func legacyModelAliases() []modelAlias {
+	legacy := []modelAlias{
+		{pattern: Cfg.PrimaryModel, target: "GLM-4.5"},
+		{pattern: Cfg.ThinkingModel, target: "GLM-4.5-Thinking"},
+		{pattern: Cfg.SearchModel, target: "GLM-4.5-Search"},
+		{pattern: Cfg.AirModel, target: "GLM-4.5-Air"},
+		{pattern: Cfg.PrimaryModelNew, target: "GLM-4.6"},
+		{pattern: Cfg.ThinkingModelNew, target: "GLM-4.6-Thinking"},
+		{pattern: Cfg.SearchModelNew, target: "GLM-4.6-Search"},
+	}
+	var aliases []modelAlias
+	for _, a := range legacy {
+		if a.pattern != "" && !strings.EqualFold(a.pattern, a.target) {
+			aliases = append(aliases, a)
+		}
+	}
+	return aliases
+}
+
+// initModelAliases 加载模型别名和降级链，优先级：环境变量 > 旧版模型配置 > 模型目录
+func initModelAliases() {
+	aliases := parseModelAliases(Cfg.ModelAliases)
+	aliases = append(aliases, legacyModelAliases()...)
+	catAliases, catChains := catalogAliases()
+	aliases = append(aliases, catAliases...)
+	chains := append(parseFallbackChains(Cfg.ModelFallbacks), catChains...)
+
+	aliasLock.Lock()
+	modelAliases = aliases
+	fallbackChains = chains
+	aliasLock.Unlock()
+	if len(aliases) > 0 || len(chains) > 0 {
+		LogInfo("已加载 %d 个模型别名，%d 条降级链", len(aliases), len(chains))
+	}
+}
+
+// matchModelPattern 大小写不敏感的通配匹配
+func matchModelPattern(pattern, model string) bool {
+	pattern = strings.ToLower(pattern)
+	model = strings.ToLower(model)
+	if !strings.Contains(pattern, "*") {
+		return pattern == model
+	}
+	ok, err := path.Match(pattern, model)
+	return err == nil && ok
+}
+
+// ResolveModelAlias 将别名解析为实际模型名，精确别名优先于通配别名
+func ResolveModelAlias(model string) string {
+	aliasLock.RLock()
+	defer aliasLock.RUnlock()
+	for _, a := range modelAliases {
+		if !strings.Contains(a.pattern, "*") && strings.EqualFold(a.pattern, model) {
+			return a.target
+		}
+	}
+	for _, a := range modelAliases {
+		if strings.Contains(a.pattern, "*") && matchModelPattern(a.pattern, model) {
+			return a.target
+		}
+	}
+	return model
+}
+
+// GetFallbackChain 返回以 model 开头的降级链（去重，跳过无效模型）
+// 精确匹配优先于通配匹配，多条匹配时按配置顺序取第一条
+func GetFallbackChain(model string) []string {
+	var chain []string
+	aliasLock.RLock()
+	for _, c := range fallbackChains {
+		if !strings.Contains(c.pattern, "*") && strings.EqualFold(c.pattern, model) {
+			chain = c.chain
+			break
+		}
+	}
+	if chain == nil {
+		for _, c := range fallbackChains {
+			if strings.Contains(c.pattern, "*") && matchModelPattern(c.pattern, model) {
+				chain = c.chain
+				break
+			}
+		}
+	}
+	aliasLock.RUnlock()
+
+	result := []string{model}
+	seen := map[string]bool{strings.ToLower(model): true}
+	for _, fb := range chain {
+		fb = ResolveModelAlias(fb)
+		if seen[strings.ToLower(fb)] || !IsValidModel(fb) {
+			continue
+		}
+		seen[strings.ToLower(fb)] = true
+		result = append(result, fb)
+	}
+	return result
+}
+
+// getExactAliases 返回非通配的别名，用于在模型列表中展示
+func getExactAliases() []modelAlias {
+	aliasLock.RLock()
+	defer aliasLock.RUnlock()
+	var result []modelAlias
+	for _, a := range modelAliases {
+		if !strings.Contains(a.pattern, "*") {
+			result = append(result, a)
+		}
+	}
+	return result
+}
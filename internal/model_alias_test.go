@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+// withTestModels 替换模型映射、别名和降级链，测试结束后恢复
+func withTestModels(t *testing.T, mappings map[string]ModelMapping, aliases, fallbacks string) {
+	t.Helper()
+	mappingsLock.Lock()
+	oldMappings := modelMappings
+	modelMappings = mappings
+	mappingsLock.Unlock()
+	aliasLock.Lock()
+	oldAliases, oldChains := modelAliases, fallbackChains
+	modelAliases, fallbackChains = parseModelAliases(aliases), parseFallbackChains(fallbacks)
+	aliasLock.Unlock()
+	t.Cleanup(func() {
+		mappingsLock.Lock()
+		modelMappings = oldMappings
+		mappingsLock.Unlock()
+		aliasLock.Lock()
+		modelAliases, fallbackChains = oldAliases, oldChains
+		aliasLock.Unlock()
+	})
+}
+
+func TestFallbackChainAndContextWindow(t *testing.T) {
+	withTestModels(t, map[string]ModelMapping{
+		"GLM-5":   {UpstreamModelID: "glm-5", ContextLength: 200000},
+		"GLM-4.7": {UpstreamModelID: "glm-4.7", ContextLength: 128000},
+		"GLM-4.6": {UpstreamModelID: "glm-4.6", ContextLength: 64000},
+		"GLM-4.5": {UpstreamModelID: "glm-4.5"},
+	}, "latest=GLM-4.6", "GLM-5=GLM-4.7>latest>GLM-5>missing,GLM-4.*=GLM-4.5,GLM-4.6=GLM-4.7")
+
+	tests := []struct {
+		model      string
+		wantChain  []string
+		wantModel  string
+		wantWindow int64
+	}{
+		// 别名解析、去重并跳过无效模型，窗口取链中最小值
+		{model: "GLM-5", wantChain: []string{"GLM-5", "GLM-4.7", "GLM-4.6"}, wantModel: "GLM-4.6", wantWindow: 64000},
+		// 精确匹配优先于通配
+		{model: "GLM-4.6", wantChain: []string{"GLM-4.6", "GLM-4.7"}, wantModel: "GLM-4.6", wantWindow: 64000},
+		// 通配匹配；未设置窗口的模型不参与计算
+		{model: "GLM-4.7", wantChain: []string{"GLM-4.7", "GLM-4.5"}, wantModel: "GLM-4.7", wantWindow: 128000},
+		{model: "GLM-4.5", wantChain: []string{"GLM-4.5"}, wantModel: "", wantWindow: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := GetFallbackChain(tt.model); !reflect.DeepEqual(got, tt.wantChain) {
+				t.Errorf("GetFallbackChain = %v, want %v", got, tt.wantChain)
+			}
+			model, window := chainContextWindow(tt.model)
+			if model != tt.wantModel || window != tt.wantWindow {
+				t.Errorf("chainContextWindow = %s/%d, want %s/%d", model, window, tt.wantModel, tt.wantWindow)
+			}
+		})
+	}
+}
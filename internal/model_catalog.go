@@ -0,0 +1,323 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/yaml"
+)
+
+// builtinCatalogAsset 内置模型目录
+const builtinCatalogAsset = "assets/models.json"
+
+// CatalogFeatures 模型功能开关，未设置的字段沿用下层配置
+type CatalogFeatures struct {
+	Thinking      *bool `json:"thinking,omitempty"`
+	WebSearch     *bool `json:"web_search,omitempty"`
+	AutoWebSearch *bool `json:"auto_web_search,omitempty"`
+	Vision        *bool `json:"vision,omitempty"`
+}
+
+// CatalogModel 模型目录中的单个模型，除 id 外的字段均可省略
+type CatalogModel struct {
+	ID            string          `json:"id"`
+	UpstreamID    string          `json:"upstream_id,omitempty"`
+	UpstreamName  string          `json:"upstream_name,omitempty"`
+	DisplayName   string          `json:"display_name,omitempty"`
+	Description   string          `json:"description,omitempty"`
+	OwnedBy       string          `json:"owned_by,omitempty"`
+	ContextLength *int            `json:"context_length,omitempty"`
+	MCPServers    []string        `json:"mcp_servers,omitempty"`
+	Features      CatalogFeatures `json:"features"`
+	Disabled      bool            `json:"disabled,omitempty"`
+}
+
+// ModelCatalog 模型目录文件
+type ModelCatalog struct {
+	Models    []CatalogModel      `json:"models"`
+	Aliases   map[string]string   `json:"aliases,omitempty"`
+	Fallbacks map[string][]string `json:"fallbacks,omitempty"`
+}
+
+var (
+	builtinCatalog  *ModelCatalog
+	fileCatalog     *ModelCatalog
+	dynamicMappings = make(map[string]ModelMapping)
+	catalogWatcher  *fsnotify.Watcher
+	catalogOnce     sync.Once
+)
+
+// apply 将目录配置覆盖到 base 上，base 为空时按目录配置新建
+func (m CatalogModel) apply(base ModelMapping, exists bool) ModelMapping {
+	if !exists {
+		base = ModelMapping{
+			DisplayName:   m.ID,
+			OwnedBy:       "z.ai",
+			IsBuiltin:     true,
+			ContextLength: defaultContextLength,
+		}
+	}
+	if m.UpstreamID != "" {
+		base.UpstreamModelID = m.UpstreamID
+	}
+	if m.DisplayName != "" {
+		base.DisplayName = m.DisplayName
+	}
+	if m.UpstreamName != "" {
+		base.UpstreamModelName = m.UpstreamName
+	} else if base.UpstreamModelName == "" {
+		base.UpstreamModelName = base.DisplayName
+	}
+	if m.Description != "" {
+		base.Description = m.Description
+	}
+	if m.OwnedBy != "" {
+		base.OwnedBy = m.OwnedBy
+	}
+	if m.ContextLength != nil {
+		base.ContextLength = *m.ContextLength
+	}
+	if m.MCPServers != nil {
+		base.MCPServers = append([]string{}, m.MCPServers...)
+	}
+	if m.Features.Thinking != nil {
+		base.EnableThinking = *m.Features.Thinking
+	}
+	if m.Features.WebSearch != nil {
+		base.WebSearch = *m.Features.WebSearch
+	}
+	if m.Features.AutoWebSearch != nil {
+		base.AutoWebSearch = *m.Features.AutoWebSearch
+	}
+	if m.Features.Vision != nil {
+		base.Vision = *m.Features.Vision
+	}
+	return base
+}
+
+func parseModelCatalog(data []byte) (*ModelCatalog, error) {
+	var catalog ModelCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, err
+	}
+	for i, m := range catalog.Models {
+		if strings.TrimSpace(m.ID) == "" {
+			return nil, fmt.Errorf("第 %d 个模型缺少 id", i+1)
+		}
+	}
+	return &catalog, nil
+}
+
+// loadBuiltinCatalog 加载内置模型目录
+func loadBuiltinCatalog() {
+	data, err := assetsFS.ReadFile(builtinCatalogAsset)
+	if err != nil {
+		LogError("读取内置模型目录失败: %v", err)
+		builtinCatalog = &ModelCatalog{}
+		return
+	}
+	catalog, err := parseModelCatalog(data)
+	if err != nil {
+		LogError("解析内置模型目录失败: %v", err)
+		builtinCatalog = &ModelCatalog{}
+		return
+	}
+	builtinCatalog = catalog
+}
+
+// loadFileCatalog 加载 MODEL_CATALOG 指定的模型目录文件，文件不存在时视为空
+// 解析失败时保留上一次成功加载的内容
+func loadFileCatalog() {
+	path := Cfg.ModelCatalog
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			mappingsLock.Lock()
+			fileCatalog = nil
+			mappingsLock.Unlock()
+			return
+		}
+		LogError("读取模型目录失败: %v", err)
+		return
+	}
+	// .yaml/.yml 文件先转换为 JSON，字段名与 JSON 格式一致
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			LogError("解析模型目录 %s 失败，保留原配置: %v", path, err)
+			return
+		}
+	}
+	catalog, err := parseModelCatalog(data)
+	if err != nil {
+		LogError("解析模型目录 %s 失败，保留原配置: %v", path, err)
+		return
+	}
+	mappingsLock.Lock()
+	fileCatalog = catalog
+	mappingsLock.Unlock()
+	LogInfo("已加载模型目录 %s: %d 个模型", path, len(catalog.Models))
+}
+
+// rebuildModelMappingsLocked 按优先级 文件目录 > 内置目录 > 动态拉取 重建映射表，调用方需持有写锁
+func rebuildModelMappingsLocked() {
+	merged := make(map[string]ModelMapping)
+	for id, m := range dynamicMappings {
+		merged[id] = m
+	}
+	disabled := make(map[string]bool)
+	for _, catalog := range []*ModelCatalog{builtinCatalog, fileCatalog} {
+		if catalog == nil {
+			continue
+		}
+		for _, cm := range catalog.Models {
+			base, exists := merged[cm.ID]
+			merged[cm.ID] = cm.apply(base, exists)
+			disabled[cm.ID] = cm.Disabled
+		}
+	}
+	for id, m := range merged {
+		if disabled[id] {
+			delete(merged, id)
+			continue
+		}
+		if m.UpstreamModelID == "" {
+			LogWarn("模型 %s 缺少 upstream_id，已忽略", id)
+			delete(merged, id)
+		}
+	}
+	modelMappings = merged
+}
+
+// isCatalogModelLocked 判断模型是否已在目录中定义（大小写不敏感），调用方需持有锁
+func isCatalogModelLocked(modelID string) bool {
+	for _, catalog := range []*ModelCatalog{builtinCatalog, fileCatalog} {
+		if catalog == nil {
+			continue
+		}
+		for _, cm := range catalog.Models {
+			if strings.EqualFold(cm.ID, modelID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// catalogAliases 返回目录文件中的别名（按名称排序以保证通配匹配顺序稳定）和降级链
+func catalogAliases() ([]modelAlias, []fallbackChain) {
+	mappingsLock.RLock()
+	defer mappingsLock.RUnlock()
+	var aliases []modelAlias
+	for _, catalog := range []*ModelCatalog{builtinCatalog, fileCatalog} {
+		if catalog == nil {
+			continue
+		}
+		patterns := make([]string, 0, len(catalog.Aliases))
+		for p := range catalog.Aliases {
+			patterns = append(patterns, p)
+		}
+		sort.Strings(patterns)
+		for _, p := range patterns {
+			aliases = append(aliases, modelAlias{pattern: p, target: catalog.Aliases[p]})
+		}
+	}
+
+	// 目录文件中的降级链优先于内置目录；JSON 对象无序，按名称排序保证匹配结果稳定
+	var fallbacks []fallbackChain
+	for _, catalog := range []*ModelCatalog{fileCatalog, builtinCatalog} {
+		if catalog == nil {
+			continue
+		}
+		models := make([]string, 0, len(catalog.Fallbacks))
+		for m := range catalog.Fallbacks {
+			models = append(models, m)
+		}
+		sort.Strings(models)
+		for _, m := range models {
+			fallbacks = append(fallbacks, fallbackChain{pattern: m, chain: catalog.Fallbacks[m]})
+		}
+	}
+	return aliases, fallbacks
+}
+
+// reloadModelCatalog 重新加载目录文件并重建映射表和别名
+func reloadModelCatalog() {
+	loadFileCatalog()
+	mappingsLock.Lock()
+	rebuildModelMappingsLocked()
+	count := len(modelMappings)
+	mappingsLock.Unlock()
+	initModelAliases()
+	LogDebug("模型映射已重建: %d 个模型", count)
+}
+
+// startCatalogWatcher 监听模型目录文件变化并热加载
+func startCatalogWatcher() error {
+	if Cfg.ModelCatalog == "" {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	catalogWatcher = watcher
+	name := filepath.Base(Cfg.ModelCatalog)
+	dir := filepath.Dir(Cfg.ModelCatalog)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Base(event.Name) != name {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+					LogInfo("检测到模型目录变化，重新加载...")
+					time.Sleep(100 * time.Millisecond) // 等待文件写入完成
+					reloadModelCatalog()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				LogError("模型目录监听错误: %v", err)
+			}
+		}
+	}()
+
+	return watcher.Add(dir)
+}
+
+// stopModelCatalogWatcher 停止模型目录监听
+func stopModelCatalogWatcher() {
+	if catalogWatcher != nil {
+		catalogWatcher.Close()
+	}
+}
+
+// initModelCatalog 加载内置和文件目录并启动热加载
+func initModelCatalog() {
+	catalogOnce.Do(func() {
+		loadBuiltinCatalog()
+		reloadModelCatalog()
+		if err := startCatalogWatcher(); err != nil {
+			LogWarn("启动模型目录监听失败: %v", err)
+		}
+	})
+}
@@ -2,7 +2,9 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,12 +23,15 @@ type ModelMapping struct {
 	DisplayName       string
 	UpstreamModelID   string
 	UpstreamModelName string
+	Description       string
 	EnableThinking    bool
 	WebSearch         bool
 	AutoWebSearch     bool
+	Vision            bool
 	MCPServers        []string
 	OwnedBy           string
 	IsBuiltin         bool
+	ContextLength     int // 上下文窗口（token），0 表示不限制
 }
 
 var (
@@ -34,177 +39,26 @@ var (
 	mappingsLock  sync.RWMutex
 )
 
-func initBuiltinMappings() {
-	mappingsLock.Lock()
-	defer mappingsLock.Unlock()
-	modelMappings[Cfg.PrimaryModel] = ModelMapping{
-		DisplayName:       Cfg.PrimaryModel,
-		UpstreamModelID:   "0727-360B-API",
-		UpstreamModelName: "GLM-4.5",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings[Cfg.ThinkingModel] = ModelMapping{
-		DisplayName:       Cfg.ThinkingModel,
-		UpstreamModelID:   "0727-360B-API",
-		UpstreamModelName: "GLM-4.5-Thinking",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings[Cfg.SearchModel] = ModelMapping{
-		DisplayName:       Cfg.SearchModel,
-		UpstreamModelID:   "0727-360B-API",
-		UpstreamModelName: "GLM-4.5-Search",
-		EnableThinking:    true,
-		WebSearch:         true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search", "deep-web-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings[Cfg.AirModel] = ModelMapping{
-		DisplayName:       Cfg.AirModel,
-		UpstreamModelID:   "0727-106B-API",
-		UpstreamModelName: "GLM-4.5-Air",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings[Cfg.PrimaryModelNew] = ModelMapping{
-		DisplayName:       Cfg.PrimaryModelNew,
-		UpstreamModelID:   "GLM-4-6-API-V1",
-		UpstreamModelName: "GLM-4.6",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings[Cfg.ThinkingModelNew] = ModelMapping{
-		DisplayName:       Cfg.ThinkingModelNew,
-		UpstreamModelID:   "GLM-4-6-API-V1",
-		UpstreamModelName: "GLM-4.6-Thinking",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings[Cfg.SearchModelNew] = ModelMapping{
-		DisplayName:       Cfg.SearchModelNew,
-		UpstreamModelID:   "GLM-4-6-API-V1",
-		UpstreamModelName: "GLM-4.6-Search",
-		EnableThinking:    true,
-		WebSearch:         true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search", "deep-web-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-4.7"] = ModelMapping{
-		DisplayName:       "GLM-4.7",
-		UpstreamModelID:   "glm-4.7",
-		UpstreamModelName: "GLM-4.7",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-4.7-Thinking"] = ModelMapping{
-		DisplayName:       "GLM-4.7-Thinking",
-		UpstreamModelID:   "glm-4.7",
-		UpstreamModelName: "GLM-4.7-Thinking",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-4.7-Search"] = ModelMapping{
-		DisplayName:       "GLM-4.7-Search",
-		UpstreamModelID:   "glm-4.7",
-		UpstreamModelName: "GLM-4.7-Search",
-		EnableThinking:    true,
-		WebSearch:         true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search", "deep-web-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-4.5-V"] = ModelMapping{
-		DisplayName:       "GLM-4.5-V",
-		UpstreamModelID:   "glm-4.5v",
-		UpstreamModelName: "GLM-4.5-V",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
+// applyModelSuffix 按 -thinking/-search 后缀开启对应功能
+func applyModelSuffix(mapping ModelMapping, enableThinking, enableSearch bool) ModelMapping {
+	if enableThinking {
+		mapping.EnableThinking = true
 	}
-	modelMappings["GLM-4.6-V"] = ModelMapping{
-		DisplayName:       "GLM-4.6-V",
-		UpstreamModelID:   "glm-4.6v",
-		UpstreamModelName: "GLM-4.6-V",
-		EnableThinking:    true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search", "vlm-image-search", "vlm-image-recognition", "vlm-image-processing"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-5"] = ModelMapping{
-		DisplayName:       "GLM-5",
-		UpstreamModelID:   "glm-5",
-		UpstreamModelName: "GLM-5",
-		EnableThinking:    false,
-		AutoWebSearch:     false,
-		MCPServers:        []string{},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-5-Thinking"] = ModelMapping{
-		DisplayName:       "GLM-5-Thinking",
-		UpstreamModelID:   "glm-5",
-		UpstreamModelName: "GLM-5-Thinking",
-		EnableThinking:    true,
-		AutoWebSearch:     false,
-		MCPServers:        []string{},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
-	}
-	modelMappings["GLM-5-Search"] = ModelMapping{
-		DisplayName:       "GLM-5-Search",
-		UpstreamModelID:   "glm-5",
-		UpstreamModelName: "GLM-5-Search",
-		EnableThinking:    true,
-		WebSearch:         true,
-		AutoWebSearch:     true,
-		MCPServers:        []string{"advanced-search", "deep-web-search"},
-		OwnedBy:           "z.ai",
-		IsBuiltin:         true,
+	if enableSearch {
+		mapping.WebSearch = true
+		mapping.AutoWebSearch = true
 	}
+	return mapping
 }
+
+// GetModelMapping 获取模型映射，支持别名和 -thinking/-search 后缀
 func GetModelMapping(modelID string) (ModelMapping, bool) {
+	modelID = ResolveModelAlias(modelID)
 	baseModel, enableThinking, enableSearch := ParseModelName(modelID)
 	mappingsLock.RLock()
 	defer mappingsLock.RUnlock()
 	if mapping, ok := modelMappings[baseModel]; ok {
-		if enableThinking {
-			mapping.EnableThinking = true
-		}
-		if enableSearch {
-			mapping.WebSearch = true
-			mapping.AutoWebSearch = true
-		}
-		return mapping, true
+		return applyModelSuffix(mapping, enableThinking, enableSearch), true
 	}
 	if mapping, ok := modelMappings[modelID]; ok {
 		return mapping, true
@@ -220,15 +74,21 @@ func GetUpstreamConfig(requestedModel string) *ModelMapping {
 }
 
 func fetchLatestModels() {
+	recordWorkerRun(WorkerModelFetcher, probeLatestModels())
+}
+
+// probeLatestModels 获取上游模型列表，同时作为上游可用性探测
+func probeLatestModels() error {
 	token, err := GetAnonymousToken()
+	recordAnonymousProbe(err == nil)
 	if err != nil {
 		LogDebug("Failed to get token for model fetching: %v", err)
-		return
+		return fmt.Errorf("anonymous token: %v", err)
 	}
 	req, err := http.NewRequest("GET", "https://chat.z.ai/api/models", nil)
 	if err != nil {
 		LogDebug("Failed to create model request: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Accept", "application/json")
@@ -236,38 +96,45 @@ func fetchLatestModels() {
 	resp, err := client.Do(req)
 	if err != nil {
 		LogDebug("Failed to fetch models: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		LogDebug("Model API returned status %d", resp.StatusCode)
-		return
+		return fmt.Errorf("model API returned status %d", resp.StatusCode)
 	}
 	var modelsResp ZAIModelsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
 		LogDebug("Failed to decode models response: %v", err)
-		return
+		return err
 	}
 
 	// 更新动态映射
 	updateDynamicMappings(modelsResp.Data)
 
 	LogInfo("Fetched %d models from API", len(modelsResp.Data))
+	return nil
 }
 
+// defaultContextLength 未知模型的默认上下文窗口
+const defaultContextLength = 128000
+
 // inferModelConfig 根据模型名称自动推断配置
-func inferModelConfig(modelID string) (enableThinking bool, autoWebSearch bool, mcpServers []string) {
+func inferModelConfig(modelID string) (enableThinking bool, autoWebSearch bool, vision bool, mcpServers []string, contextLength int) {
 	idLower := strings.ToLower(modelID)
 	enableThinking = true
 	autoWebSearch = true
 	mcpServers = []string{"advanced-search"}
+	contextLength = defaultContextLength
 	if strings.Contains(idLower, "-v") {
+		vision = true
 		mcpServers = append(mcpServers, "vlm-image-search", "vlm-image-recognition", "vlm-image-processing")
 	}
 	return
 }
 
 // updateDynamicMappings 更新动态模型映射，将从 API 拉取的新模型注册到映射表
+// 目录中已定义的模型不会被动态配置覆盖
 func updateDynamicMappings(models []ZAIModel) {
 	mappingsLock.Lock()
 	defer mappingsLock.Unlock()
@@ -278,17 +145,7 @@ func updateDynamicMappings(models []ZAIModel) {
 		if !strings.HasPrefix(idLower, "glm") {
 			continue
 		}
-		if _, exists := modelMappings[model.ID]; exists {
-			continue
-		}
-		alreadyMapped := false
-		for existingID := range modelMappings {
-			if strings.EqualFold(existingID, model.ID) {
-				alreadyMapped = true
-				break
-			}
-		}
-		if alreadyMapped {
+		if isCatalogModelLocked(model.ID) {
 			continue
 		}
 		// 上游模型ID 格式化为显示名
@@ -300,19 +157,24 @@ func updateDynamicMappings(models []ZAIModel) {
 		if ownedBy == "" || ownedBy == "openai" {
 			ownedBy = "z.ai"
 		}
-		enableThinking, autoWebSearch, mcpServers := inferModelConfig(model.ID)
-		modelMappings[model.ID] = ModelMapping{
+		enableThinking, autoWebSearch, vision, mcpServers, contextLength := inferModelConfig(model.ID)
+		if _, exists := dynamicMappings[model.ID]; !exists {
+			newCount++
+		}
+		dynamicMappings[model.ID] = ModelMapping{
 			DisplayName:       displayName,
 			UpstreamModelID:   model.ID,
 			UpstreamModelName: displayName,
 			EnableThinking:    enableThinking,
 			AutoWebSearch:     autoWebSearch,
+			Vision:            vision,
 			MCPServers:        mcpServers,
 			OwnedBy:           ownedBy,
 			IsBuiltin:         false,
+			ContextLength:     contextLength,
 		}
-		newCount++
 	}
+	rebuildModelMappingsLocked()
 	if newCount > 0 {
 		LogInfo("Registered %d new dynamic models", newCount)
 	}
@@ -333,42 +195,123 @@ func isBaseSuffixModel(modelID string) bool {
 		!strings.HasSuffix(idLower, "-thinking-search")
 }
 
-// GetAvailableModels 获取所有可用模型，包括内置 + 动态 + 后缀组合
+// modelVariant 返回后缀对应的变体名称
+func modelVariant(enableThinking, enableSearch bool) string {
+	switch {
+	case enableThinking && enableSearch:
+		return "thinking-search"
+	case enableThinking:
+		return "thinking"
+	case enableSearch:
+		return "search"
+	}
+	return ""
+}
+
+// newModelInfo 由模型映射生成 /v1/models 返回的模型信息
+func newModelInfo(id string, m ModelMapping) ModelInfo {
+	return ModelInfo{
+		ID:               id,
+		Object:           "model",
+		OwnedBy:          m.OwnedBy,
+		Name:             m.DisplayName,
+		Description:      m.Description,
+		UpstreamID:       m.UpstreamModelID,
+		ContextLength:    m.ContextLength,
+		SupportsVision:   m.Vision,
+		SupportsThinking: m.EnableThinking,
+		WebSearch:        m.WebSearch || m.AutoWebSearch,
+		MCPServers:       m.MCPServers,
+		Builtin:          m.IsBuiltin,
+	}
+}
+
+// sortedMappingIDsLocked 返回排序后的模型 ID：内置模型在前，其余按名称排序，调用方需持有锁
+func sortedMappingIDsLocked() []string {
+	ids := make([]string, 0, len(modelMappings))
+	for id := range modelMappings {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := modelMappings[ids[i]], modelMappings[ids[j]]
+		if a.IsBuiltin != b.IsBuiltin {
+			return a.IsBuiltin
+		}
+		return strings.ToLower(ids[i]) < strings.ToLower(ids[j])
+	})
+	return ids
+}
+
+// GetAvailableModels 获取所有可用模型，包括内置 + 动态 + 后缀组合 + 别名，顺序稳定
 func GetAvailableModels() []ModelInfo {
+	aliases := getExactAliases()
+
 	mappingsLock.RLock()
 	defer mappingsLock.RUnlock()
 
 	seen := make(map[string]bool)
 	var models []ModelInfo
 
-	addModel := func(id, ownedBy string) {
-		key := strings.ToLower(id)
+	addModel := func(info ModelInfo) {
+		key := strings.ToLower(info.ID)
 		if seen[key] {
 			return
 		}
 		seen[key] = true
-		models = append(models, ModelInfo{
-			ID:      id,
-			Object:  "model",
-			OwnedBy: ownedBy,
-		})
+		models = append(models, info)
 	}
 
-	for id, m := range modelMappings {
-		addModel(id, m.OwnedBy)
+	for _, id := range sortedMappingIDsLocked() {
+		m := modelMappings[id]
+		addModel(newModelInfo(id, m))
 		if isBaseSuffixModel(id) {
 			for _, suffix := range modelSuffixes {
-				addModel(id+suffix, m.OwnedBy)
+				_, thinking, search := ParseModelName(id + suffix)
+				info := newModelInfo(id+suffix, applyModelSuffix(m, thinking, search))
+				info.Parent = id
+				info.Variant = modelVariant(thinking, search)
+				addModel(info)
 			}
 		}
 	}
 
+	// 精确别名也作为可用模型展示
+	for _, a := range aliases {
+		baseModel, thinking, search := ParseModelName(a.target)
+		if m, ok := modelMappings[baseModel]; ok {
+			info := newModelInfo(a.pattern, applyModelSuffix(m, thinking, search))
+			info.Parent = baseModel
+			info.Variant = modelVariant(thinking, search)
+			info.AliasOf = a.target
+			addModel(info)
+		}
+	}
+
 	return models
 }
 
+// GetModelInfo 获取单个模型的信息，支持别名和后缀组合
+func GetModelInfo(modelID string) (ModelInfo, bool) {
+	mapping, ok := GetModelMapping(modelID)
+	if !ok {
+		return ModelInfo{}, false
+	}
+	resolved := ResolveModelAlias(modelID)
+	info := newModelInfo(modelID, mapping)
+	baseModel, thinking, search := ParseModelName(resolved)
+	if baseModel != modelID {
+		info.Parent = baseModel
+	}
+	info.Variant = modelVariant(thinking, search)
+	if resolved != modelID {
+		info.AliasOf = resolved
+	}
+	return info, true
+}
+
 // StartModelFetcher 启动模型获取定时器
 func StartModelFetcher() {
-	initBuiltinMappings()
+	initModelCatalog()
 
 	// 初次获取
 	go fetchLatestModels()
@@ -376,8 +319,14 @@ func StartModelFetcher() {
 	// 定期更新（每5分钟）
 	ticker := time.NewTicker(5 * time.Minute)
 	go func() {
-		for range ticker.C {
-			fetchLatestModels()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fetchLatestModels()
+			case <-workersStop:
+				return
+			}
 		}
 	}()
 }
@@ -7,31 +7,6 @@ import (
 	"strings"
 )
 
-var BaseModelMapping = map[string]string{
-	"GLM-4.5":      "0727-360B-API",
-	"GLM-4.6":      "GLM-4-6-API-V1",
-	"GLM-4.7":      "glm-4.7",
-	"GLM-5":        "glm-5",
-	"GLM-4.5-V":    "glm-4.5v",
-	"GLM-4.6-V":    "glm-4.6v",
-	"GLM-4.5-Air":  "0727-106B-API",
-	"0808-360B-DR": "0808-360B-DR",
-}
-var ModelList = []string{
-	"GLM-4.5",
-	"GLM-4.6",
-	"GLM-4.7",
-	"GLM-5",
-	"GLM-4.5-thinking",
-	"GLM-4.6-thinking",
-	"GLM-4.7-thinking",
-	"GLM-4.5-V",
-	"GLM-4.6-V",
-	"GLM-4.6-V-thinking",
-	"GLM-4.5-Air",
-	"0808-360B-DR",
-}
-
 func ParseModelName(model string) (baseModel string, enableThinking bool, enableSearch bool) {
 	enableThinking = false
 	enableSearch = false
@@ -51,33 +26,19 @@ func ParseModelName(model string) (baseModel string, enableThinking bool, enable
 	return baseModel, enableThinking, enableSearch
 }
 
-func IsThinkingModel(model string) bool {
-	_, enableThinking, _ := ParseModelName(model)
-	return enableThinking
-}
-
-func IsSearchModel(model string) bool {
-	_, _, enableSearch := ParseModelName(model)
-	return enableSearch
-}
-
-func GetTargetModel(model string) string {
-	baseModel, _, _ := ParseModelName(model)
-	if target, ok := BaseModelMapping[baseModel]; ok {
-		return target
+// thinkingVariant 返回模型的 -thinking 变体，不存在时返回原模型
+func thinkingVariant(model string) string {
+	if _, thinking, _ := ParseModelName(strings.ToLower(model)); thinking {
+		return model
+	}
+	if IsValidModel(model + "-thinking") {
+		return model + "-thinking"
 	}
 	return model
 }
 
 func IsValidModel(model string) bool {
-	baseModel, _, _ := ParseModelName(model)
-	if _, ok := BaseModelMapping[baseModel]; ok {
-		return true
-	}
-	if GetUpstreamConfig(model) != nil {
-		return true
-	}
-	return false
+	return GetUpstreamConfig(model) != nil
 }
 
 type ContentPart struct {
@@ -210,18 +171,24 @@ type ChatRequest struct {
 	PresencePenalty  *float64    `json:"presence_penalty,omitempty"`
 	FrequencyPenalty *float64    `json:"frequency_penalty,omitempty"`
 	Stop             interface{} `json:"stop,omitempty"`
+	Seed             *int        `json:"seed,omitempty"`
 	User             string      `json:"user,omitempty"`
 	StreamOptions    *struct {
 		IncludeUsage bool `json:"include_usage,omitempty"`
 	} `json:"stream_options,omitempty"`
+	WebSearchOptions *WebSearchOptions `json:"web_search_options,omitempty"`
+	// 扩展字段：指定 MCP 服务器和上游功能开关
+	MCPServers []string         `json:"mcp_servers,omitempty"`
+	Features   *RequestFeatures `json:"features,omitempty"`
 }
 
 type ChatCompletionChunk struct {
-	ID      string   `json:"id"`
-	Object  string   `json:"object"`
-	Created int64    `json:"created"`
-	Model   string   `json:"model"`
-	Choices []Choice `json:"choices"`
+	ID       string   `json:"id"`
+	Object   string   `json:"object"`
+	Created  int64    `json:"created"`
+	Model    string   `json:"model"`
+	Choices  []Choice `json:"choices"`
+	Warnings []string `json:"warnings,omitempty"` // 仅首个分块携带
 }
 
 type Choice struct {
@@ -233,23 +200,68 @@ type Choice struct {
 }
 
 type Delta struct {
-	Role             string     `json:"role,omitempty"`
-	Content          string     `json:"content,omitempty"`
-	ReasoningContent string     `json:"reasoning_content,omitempty"`
-	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	Role             string        `json:"role,omitempty"`
+	Content          string        `json:"content,omitempty"`
+	ReasoningContent string        `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall    `json:"tool_calls,omitempty"`
+	Annotations      []Annotation  `json:"annotations,omitempty"`
+	Attachments      []Attachment  `json:"attachments,omitempty"`
+	Images           []ContentPart `json:"images,omitempty"` // 上游生成的图片
 }
 
 type MessageResp struct {
-	Role             string     `json:"role"`
-	Content          string     `json:"content"`
-	ReasoningContent string     `json:"reasoning_content,omitempty"`
-	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	Role             string        `json:"role"`
+	Content          string        `json:"content"`
+	ReasoningContent string        `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall    `json:"tool_calls,omitempty"`
+	Annotations      []Annotation  `json:"annotations,omitempty"`
+	Attachments      []Attachment  `json:"attachments,omitempty"`
+	Images           []ContentPart `json:"images,omitempty"` // 上游生成的图片
+}
+
+// Annotation OpenAI 风格的消息注解，目前仅有 url_citation
+type Annotation struct {
+	Type        string       `json:"type"`
+	URLCitation *URLCitation `json:"url_citation,omitempty"`
+}
+
+// URLCitation 引用来源，start_index/end_index 为被引用文本在 content 中的字符区间
+type URLCitation struct {
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+}
+
+// Attachment 结构化附件（扩展字段），如图片搜索结果
+type Attachment struct {
+	Type         string `json:"type"`
+	Title        string `json:"title,omitempty"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
 }
 
 type Usage struct {
-	PromptTokens     int64 `json:"prompt_tokens"`
-	CompletionTokens int64 `json:"completion_tokens"`
-	TotalTokens      int64 `json:"total_tokens"`
+	PromptTokens            int64                    `json:"prompt_tokens"`
+	CompletionTokens        int64                    `json:"completion_tokens"`
+	TotalTokens             int64                    `json:"total_tokens"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+type CompletionTokensDetails struct {
+	ReasoningTokens int64 `json:"reasoning_tokens"`
+}
+
+// NewUsage 构造用量信息，completionTokens 已包含 reasoningTokens
+func NewUsage(promptTokens, completionTokens, reasoningTokens int64) *Usage {
+	return &Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+		CompletionTokensDetails: &CompletionTokensDetails{
+			ReasoningTokens: reasoningTokens,
+		},
+	}
 }
 
 type ChatCompletionResponse struct {
@@ -260,6 +272,7 @@ type ChatCompletionResponse struct {
 	Choices           []Choice `json:"choices"`
 	Usage             *Usage   `json:"usage,omitempty"`
 	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+	Warnings          []string `json:"warnings,omitempty"`
 }
 
 type ChatCompletionChunkResponse struct {
@@ -278,9 +291,21 @@ type ModelsResponse struct {
 }
 
 type ModelInfo struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	OwnedBy string `json:"owned_by"`
+	ID               string   `json:"id"`
+	Object           string   `json:"object"`
+	OwnedBy          string   `json:"owned_by"`
+	Name             string   `json:"name,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Parent           string   `json:"parent,omitempty"`   // 后缀变体或别名对应的基础模型
+	Variant          string   `json:"variant,omitempty"`  // thinking / search / thinking-search
+	AliasOf          string   `json:"alias_of,omitempty"` // 别名解析后的模型
+	UpstreamID       string   `json:"upstream_id"`
+	ContextLength    int      `json:"context_length,omitempty"`
+	SupportsVision   bool     `json:"supports_vision"`
+	SupportsThinking bool     `json:"supports_thinking"`
+	WebSearch        bool     `json:"web_search"`
+	MCPServers       []string `json:"mcp_servers,omitempty"`
+	Builtin          bool     `json:"builtin"`
 }
 
 var searchRefPattern = regexp.MustCompile(`【turn\d+search(\d+)】`)
@@ -293,17 +318,37 @@ type SearchResult struct {
 	RefID string `json:"ref_id"`
 }
 
+// 引用输出模式
+const (
+	CitationModeMarkdown    = "markdown"    // 引用标记替换为 markdown 链接，并输出来源列表
+	CitationModeAnnotations = "annotations" // 正文不含引用标记，引用以 annotations 返回
+)
+
 type SearchRefFilter struct {
 	buffer        string
 	searchResults map[string]SearchResult
+
+	// annotations 模式下的正文位置跟踪（按字符计）
+	annotations   bool
+	contentRunes  int
+	spanStart     int // 当前句子起点
+	lastSpan      [2]int
+	lastRune      rune
+	pendingAnnots []Annotation
 }
 
-func NewSearchRefFilter() *SearchRefFilter {
+func NewSearchRefFilter(citationMode string) *SearchRefFilter {
 	return &SearchRefFilter{
 		searchResults: make(map[string]SearchResult),
+		annotations:   strings.EqualFold(citationMode, CitationModeAnnotations),
 	}
 }
 
+// AnnotationsEnabled 是否以 annotations 形式返回引用
+func (f *SearchRefFilter) AnnotationsEnabled() bool {
+	return f.annotations
+}
+
 func (f *SearchRefFilter) AddSearchResults(results []SearchResult) {
 	for _, r := range results {
 		f.searchResults[r.RefID] = r
@@ -325,6 +370,11 @@ func (f *SearchRefFilter) Process(content string) string {
 		return ""
 	}
 
+	if f.annotations {
+		ready := f.holdPrefix(content)
+		return searchRefPattern.ReplaceAllString(ready, "")
+	}
+
 	content = searchRefPattern.ReplaceAllStringFunc(content, func(match string) string {
 		runes := []rune(match)
 		refID := string(runes[1 : len(runes)-1])
@@ -357,6 +407,9 @@ func (f *SearchRefFilter) Process(content string) string {
 func (f *SearchRefFilter) Flush() string {
 	result := f.buffer
 	f.buffer = ""
+	if f.annotations {
+		return searchRefPattern.ReplaceAllString(result, "")
+	}
 	if result != "" {
 		result = searchRefPattern.ReplaceAllStringFunc(result, func(match string) string {
 			runes := []rune(match)
@@ -370,8 +423,112 @@ func (f *SearchRefFilter) Flush() string {
 	return result
 }
 
+// holdPrefix 缓存结尾可能是未完整引用标记的部分，返回可以输出的内容
+func (f *SearchRefFilter) holdPrefix(content string) string {
+	maxPrefixLen := 20
+	if len(content) < maxPrefixLen {
+		maxPrefixLen = len(content)
+	}
+	for i := 1; i <= maxPrefixLen; i++ {
+		suffix := content[len(content)-i:]
+		if searchRefPrefixPattern.MatchString(suffix) {
+			f.buffer = suffix
+			return content[:len(content)-i]
+		}
+	}
+	return content
+}
+
+// ProcessContent 处理正文内容：annotations 模式下移除引用标记并记录引用区间，否则同 Process
+func (f *SearchRefFilter) ProcessContent(content string) string {
+	if !f.annotations {
+		return f.Process(content)
+	}
+	content = f.buffer + content
+	f.buffer = ""
+	return f.annotate(f.holdPrefix(content))
+}
+
+// FlushContent 输出正文中缓存的剩余内容
+func (f *SearchRefFilter) FlushContent() string {
+	if !f.annotations {
+		return f.Flush()
+	}
+	result := f.buffer
+	f.buffer = ""
+	return f.annotate(result)
+}
+
+// annotate 移除引用标记，将标记前的句子作为引用区间
+func (f *SearchRefFilter) annotate(content string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range searchRefPattern.FindAllStringSubmatchIndex(content, -1) {
+		f.advance(content[last:loc[0]])
+		sb.WriteString(content[last:loc[0]])
+		last = loc[1]
+
+		runes := []rune(content[loc[0]:loc[1]])
+		refID := string(runes[1 : len(runes)-1])
+		result, ok := f.searchResults[refID]
+		if !ok {
+			continue
+		}
+		span := [2]int{f.spanStart, f.contentRunes}
+		if span[0] == span[1] {
+			// 标记紧跟在句末标点之后，引用上一句
+			span = f.lastSpan
+		}
+		f.pendingAnnots = append(f.pendingAnnots, Annotation{
+			Type: "url_citation",
+			URLCitation: &URLCitation{
+				StartIndex: span[0],
+				EndIndex:   span[1],
+				URL:        result.URL,
+				Title:      result.Title,
+			},
+		})
+	}
+	f.advance(content[last:])
+	sb.WriteString(content[last:])
+	return sb.String()
+}
+
+// advance 累计已输出的正文字符数并记录句子边界
+func (f *SearchRefFilter) advance(text string) {
+	for _, r := range text {
+		f.contentRunes++
+		boundary := false
+		switch r {
+		case '\n', '。', '！', '？', '!', '?':
+			boundary = true
+		case ' ':
+			boundary = f.lastRune == '.'
+		}
+		if boundary {
+			if f.contentRunes-1 > f.spanStart {
+				f.lastSpan = [2]int{f.spanStart, f.contentRunes}
+			}
+			f.spanStart = f.contentRunes
+		}
+		f.lastRune = r
+	}
+}
+
+// TakeAnnotations 取出尚未输出的引用注解
+func (f *SearchRefFilter) TakeAnnotations() []Annotation {
+	annots := f.pendingAnnots
+	f.pendingAnnots = nil
+	return annots
+}
+
+// ResetAnnotations 丢弃已记录的引用（正文被改写导致位置失效时使用）
+func (f *SearchRefFilter) ResetAnnotations() {
+	f.pendingAnnots = nil
+}
+
 func (f *SearchRefFilter) GetSearchResultsMarkdown() string {
-	if len(f.searchResults) == 0 {
+	if f.annotations || len(f.searchResults) == 0 {
 		return ""
 	}
 
@@ -580,6 +737,20 @@ func parseImageSearchText(text string) ImageSearchResult {
 	return result
 }
 
+// ImageSearchAttachments 将图片搜索结果转换为结构化附件
+func ImageSearchAttachments(results []ImageSearchResult) []Attachment {
+	var attachments []Attachment
+	for _, r := range results {
+		attachments = append(attachments, Attachment{
+			Type:         "image_search_result",
+			Title:        r.Title,
+			URL:          r.Link,
+			ThumbnailURL: r.Thumbnail,
+		})
+	}
+	return attachments
+}
+
 func FormatImageSearchResults(results []ImageSearchResult) string {
 	if len(results) == 0 {
 		return ""
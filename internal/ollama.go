@@ -0,0 +1,574 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaDefaultTag Ollama 模型名的默认标签
+const ollamaDefaultTag = ":latest"
+
+// OllamaOptions Ollama 生成参数（仅支持部分字段）
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// OllamaToolCall Ollama 工具调用，arguments 为 JSON 对象
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+type OllamaToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// OllamaMessage Ollama 对话消息，images 为 base64 数组
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // tool 角色：对应的工具名
+}
+
+// OllamaChatRequest /api/chat 请求
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Tools    []Tool          `json:"tools,omitempty"`
+	Stream   *bool           `json:"stream,omitempty"` // 默认 true
+	Think    *bool           `json:"think,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+// OllamaGenerateRequest /api/generate 请求
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Suffix  string         `json:"suffix,omitempty"`
+	System  string         `json:"system,omitempty"`
+	Images  []string       `json:"images,omitempty"`
+	Stream  *bool          `json:"stream,omitempty"` // 默认 true
+	Think   *bool          `json:"think,omitempty"`
+	Options *OllamaOptions `json:"options,omitempty"`
+}
+
+// OllamaMetrics 结束时返回的统计信息（时间单位为纳秒）
+type OllamaMetrics struct {
+	TotalDuration   int64 `json:"total_duration,omitempty"`
+	LoadDuration    int64 `json:"load_duration,omitempty"`
+	PromptEvalCount int64 `json:"prompt_eval_count,omitempty"`
+	EvalCount       int64 `json:"eval_count,omitempty"`
+	EvalDuration    int64 `json:"eval_duration,omitempty"`
+}
+
+// OllamaChatResponse /api/chat 响应，流式输出时每行一个
+type OllamaChatResponse struct {
+	Model      string         `json:"model"`
+	CreatedAt  string         `json:"created_at"`
+	Message    *OllamaMessage `json:"message"`
+	Done       bool           `json:"done"`
+	DoneReason string         `json:"done_reason,omitempty"`
+	OllamaMetrics
+}
+
+// OllamaGenerateResponse /api/generate 响应，流式输出时每行一个
+type OllamaGenerateResponse struct {
+	Model      string `json:"model"`
+	CreatedAt  string `json:"created_at"`
+	Response   string `json:"response"`
+	Thinking   string `json:"thinking,omitempty"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+	OllamaMetrics
+}
+
+// OllamaModelDetails 模型详情
+type OllamaModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// OllamaModel /api/tags 中的模型
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaShowResponse /api/show 响应
+type OllamaShowResponse struct {
+	Modelfile    string                 `json:"modelfile"`
+	Parameters   string                 `json:"parameters"`
+	Template     string                 `json:"template"`
+	Details      OllamaModelDetails     `json:"details"`
+	ModelInfo    map[string]interface{} `json:"model_info"`
+	Capabilities []string               `json:"capabilities"`
+	ModifiedAt   string                 `json:"modified_at"`
+}
+
+// writeOllamaError Ollama 风格的错误响应
+func writeOllamaError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// ollamaErrorBody 将 OpenAI 格式的错误转换为 Ollama 格式
+func ollamaErrorBody(_ int, body []byte) []byte {
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Message == "" {
+		return body
+	}
+	data, _ := json.Marshal(map[string]string{"error": errResp.Error.Message})
+	return append(data, '\n')
+}
+
+// ollamaModelName 去掉 Ollama 的默认标签
+func ollamaModelName(model string) string {
+	return strings.TrimSuffix(model, ollamaDefaultTag)
+}
+
+// ollamaThinkingModel think=true 时切换到模型的思考变体
+func ollamaThinkingModel(model string, think *bool) string {
+	model = ollamaModelName(model)
+	if think == nil || !*think {
+		return model
+	}
+	return thinkingVariant(model)
+}
+
+// ollamaImageParts 将 base64 图片转换为 data URL 内容块，由对话流程统一上传
+func ollamaImageParts(images []string) ([]ContentPart, error) {
+	var parts []ContentPart
+	for i, img := range images {
+		data, err := base64.StdEncoding.DecodeString(img)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 张图片不是有效的 base64", i+1)
+		}
+		contentType := http.DetectContentType(data)
+		if !strings.HasPrefix(contentType, "image/") {
+			contentType = "image/png"
+		}
+		parts = append(parts, ContentPart{
+			Type:     "image_url",
+			ImageURL: &MediaURL{URL: "data:" + contentType + ";base64," + img},
+		})
+	}
+	return parts, nil
+}
+
+// ollamaContent 组合文本和图片为消息内容
+func ollamaContent(text string, images []string) (interface{}, error) {
+	if len(images) == 0 {
+		return text, nil
+	}
+	parts, err := ollamaImageParts(images)
+	if err != nil {
+		return nil, err
+	}
+	if text != "" {
+		parts = append([]ContentPart{{Type: "text", Text: text}}, parts...)
+	}
+	return parts, nil
+}
+
+// ollamaToChatMessages 将 Ollama 消息转换为内部消息
+func ollamaToChatMessages(messages []OllamaMessage) ([]Message, error) {
+	var result []Message
+	// 上一条 assistant 消息中尚未有结果的工具调用，Ollama 的工具结果没有调用 ID，按 tool_name 对应
+	var pending []ToolCall
+	for _, m := range messages {
+		content, err := ollamaContent(m.Content, m.Images)
+		if err != nil {
+			return nil, err
+		}
+		msg := Message{Role: m.Role, Content: content}
+		for _, tc := range m.ToolCalls {
+			var args interface{}
+			json.Unmarshal(tc.Function.Arguments, &args)
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       generateCallID(),
+				Type:     "function",
+				Function: ToolCallFunction{Name: tc.Function.Name, Arguments: normalizeArguments(args)},
+			})
+		}
+		switch m.Role {
+		case "assistant":
+			pending = append([]ToolCall(nil), msg.ToolCalls...)
+		case "tool":
+			msg.ToolCallID, pending = matchOllamaToolResult(pending, m.ToolName)
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+// matchOllamaToolResult 为工具结果找到对应的调用 ID：优先匹配同名的第一个调用，未指定 tool_name 时按顺序对应
+// 返回调用 ID 和剩余未匹配的调用
+func matchOllamaToolResult(pending []ToolCall, name string) (string, []ToolCall) {
+	for i, tc := range pending {
+		if name == "" || tc.Function.Name == name {
+			return tc.ID, append(pending[:i:i], pending[i+1:]...)
+		}
+	}
+	return "", pending
+}
+
+// ollamaToolCalls 将工具调用的参数字符串转换为 JSON 对象
+func ollamaToolCalls(calls []ToolCall) []OllamaToolCall {
+	var result []OllamaToolCall
+	for _, tc := range calls {
+		args := json.RawMessage(tc.Function.Arguments)
+		if !json.Valid(args) {
+			args = json.RawMessage("{}")
+		}
+		result = append(result, OllamaToolCall{Function: OllamaToolCallFunction{Name: tc.Function.Name, Arguments: args}})
+	}
+	return result
+}
+
+// ollamaDoneReason 映射结束原因
+func ollamaDoneReason(reason string) string {
+	if reason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// applyOllamaOptions 将生成参数写入对话请求
+func applyOllamaOptions(chatReq *ChatRequest, opts *OllamaOptions) {
+	if opts == nil {
+		return
+	}
+	chatReq.Temperature = opts.Temperature
+	chatReq.TopP = opts.TopP
+	chatReq.MaxTokens = opts.NumPredict
+	if len(opts.Stop) > 0 {
+		chatReq.Stop = opts.Stop
+	}
+}
+
+// ollamaStream 将对话接口的流式 chunk 转换为 Ollama NDJSON
+type ollamaStream struct {
+	w            http.ResponseWriter
+	model        string
+	generate     bool // /api/generate 格式
+	showThinking bool
+	start        time.Time
+	toolCalls    []ToolCall
+	finishReason string
+	usage        *Usage
+	done         bool
+}
+
+func (s *ollamaStream) onChunk(chunk *ChatCompletionChunkResponse) {
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil {
+			s.finishReason = *choice.FinishReason
+		}
+		if choice.Delta == nil {
+			continue
+		}
+		s.toolCalls = append(s.toolCalls, choice.Delta.ToolCalls...)
+		thinking := ""
+		if s.showThinking {
+			thinking = choice.Delta.ReasoningContent
+		}
+		if choice.Delta.Content != "" || thinking != "" {
+			s.write(choice.Delta.Content, thinking, nil, false)
+		}
+	}
+}
+
+func (s *ollamaStream) onDone() {
+	if s.done {
+		return
+	}
+	s.done = true
+	if len(s.toolCalls) > 0 && !s.generate {
+		s.write("", "", ollamaToolCalls(s.toolCalls), false)
+	}
+	s.write("", "", nil, true)
+}
+
+func (s *ollamaStream) metrics() OllamaMetrics {
+	elapsed := time.Since(s.start).Nanoseconds()
+	m := OllamaMetrics{TotalDuration: elapsed, EvalDuration: elapsed}
+	if s.usage != nil {
+		m.PromptEvalCount = s.usage.PromptTokens
+		m.EvalCount = s.usage.CompletionTokens
+	}
+	return m
+}
+
+func (s *ollamaStream) write(content, thinking string, toolCalls []OllamaToolCall, done bool) {
+	var v interface{}
+	createdAt := time.Now().UTC().Format(time.RFC3339Nano)
+	if s.generate {
+		resp := OllamaGenerateResponse{Model: s.model, CreatedAt: createdAt, Response: content, Thinking: thinking, Done: done}
+		if done {
+			resp.DoneReason = ollamaDoneReason(s.finishReason)
+			resp.OllamaMetrics = s.metrics()
+		}
+		v = resp
+	} else {
+		resp := OllamaChatResponse{
+			Model:     s.model,
+			CreatedAt: createdAt,
+			Message:   &OllamaMessage{Role: "assistant", Content: content, Thinking: thinking, ToolCalls: toolCalls},
+			Done:      done,
+		}
+		if done {
+			resp.DoneReason = ollamaDoneReason(s.finishReason)
+			resp.OllamaMetrics = s.metrics()
+		}
+		v = resp
+	}
+	data, _ := json.Marshal(v)
+	s.w.Write(append(data, '\n'))
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// serveOllamaChat 执行对话请求并按 Ollama 格式输出
+func serveOllamaChat(w http.ResponseWriter, r *http.Request, apiKey string, chatReq *ChatRequest, model string, think *bool, generate bool) {
+	// 统计信息需要用量数据
+	chatReq.StreamOptions = &struct {
+		IncludeUsage bool `json:"include_usage,omitempty"`
+	}{IncludeUsage: true}
+	s := &ollamaStream{
+		w:            w,
+		model:        model,
+		generate:     generate,
+		showThinking: think == nil || *think,
+		start:        time.Now(),
+	}
+	chatHTTPReq := newInternalChatRequest(r, newInternalCaller(r, apiKey), chatReq)
+
+	if chatReq.Stream {
+		sw := newChatStreamWriter(w, "application/x-ndjson", s.onChunk, s.onDone)
+		sw.convertError = ollamaErrorBody
+		HandleChatCompletions(sw, chatHTTPReq)
+		return
+	}
+
+	buf := newResponseBuffer()
+	HandleChatCompletions(buf, chatHTTPReq)
+	if buf.statusCode != http.StatusOK {
+		copyResponseHeaders(w.Header(), buf.header)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(buf.statusCode)
+		w.Write(ollamaErrorBody(buf.statusCode, buf.body.Bytes()))
+		return
+	}
+	copyResponseHeaders(w.Header(), buf.header)
+
+	var chatResp ChatCompletionResponse
+	if err := json.Unmarshal(buf.body.Bytes(), &chatResp); err != nil || len(chatResp.Choices) == 0 || chatResp.Choices[0].Message == nil {
+		writeOllamaError(w, http.StatusBadGateway, "无法解析上游响应")
+		return
+	}
+	msg := chatResp.Choices[0].Message
+	if chatResp.Choices[0].FinishReason != nil {
+		s.finishReason = *chatResp.Choices[0].FinishReason
+	}
+	s.usage = chatResp.Usage
+	thinking := ""
+	if s.showThinking {
+		thinking = msg.ReasoningContent
+	}
+	createdAt := time.Now().UTC().Format(time.RFC3339Nano)
+	if generate {
+		writeJSON(w, OllamaGenerateResponse{
+			Model:         model,
+			CreatedAt:     createdAt,
+			Response:      msg.Content,
+			Thinking:      thinking,
+			Done:          true,
+			DoneReason:    ollamaDoneReason(s.finishReason),
+			OllamaMetrics: s.metrics(),
+		})
+		return
+	}
+	writeJSON(w, OllamaChatResponse{
+		Model:     model,
+		CreatedAt: createdAt,
+		Message: &OllamaMessage{
+			Role:      "assistant",
+			Content:   msg.Content,
+			Thinking:  thinking,
+			ToolCalls: ollamaToolCalls(msg.ToolCalls),
+		},
+		Done:          true,
+		DoneReason:    ollamaDoneReason(s.finishReason),
+		OllamaMetrics: s.metrics(),
+	})
+}
+
+// HandleOllamaChat Ollama /api/chat
+func HandleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req OllamaChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "无效的请求格式")
+		return
+	}
+	messages, err := ollamaToChatMessages(req.Messages)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chatReq := &ChatRequest{
+		Model:    ollamaThinkingModel(req.Model, req.Think),
+		Messages: messages,
+		Stream:   req.Stream == nil || *req.Stream,
+		Tools:    req.Tools,
+	}
+	applyOllamaOptions(chatReq, req.Options)
+	serveOllamaChat(w, r, apiKey, chatReq, req.Model, req.Think, false)
+}
+
+// HandleOllamaGenerate Ollama /api/generate
+func HandleOllamaGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req OllamaGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "无效的请求格式")
+		return
+	}
+	content, err := ollamaContent(req.Prompt, req.Images)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var messages []Message
+	system := req.System
+	if req.Suffix != "" {
+		system = strings.TrimSpace(system + "\n\n" + suffixInstruction(req.Suffix))
+	}
+	if system != "" {
+		messages = append(messages, Message{Role: "system", Content: system})
+	}
+	messages = append(messages, Message{Role: "user", Content: content})
+
+	chatReq := &ChatRequest{
+		Model:    ollamaThinkingModel(req.Model, req.Think),
+		Messages: messages,
+		Stream:   req.Stream == nil || *req.Stream,
+	}
+	applyOllamaOptions(chatReq, req.Options)
+	serveOllamaChat(w, r, apiKey, chatReq, req.Model, req.Think, true)
+}
+
+// ollamaModelDetails 模型详情
+func ollamaModelDetails(info ModelInfo) OllamaModelDetails {
+	return OllamaModelDetails{
+		ParentModel: info.Parent,
+		Format:      "api",
+		Family:      "glm",
+		Families:    []string{"glm"},
+	}
+}
+
+// HandleOllamaTags Ollama /api/tags
+func HandleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	modifiedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	models := []OllamaModel{}
+	for _, info := range GetAvailableModels() {
+		sum := sha256.Sum256([]byte(info.ID))
+		models = append(models, OllamaModel{
+			Name:       info.ID + ollamaDefaultTag,
+			Model:      info.ID + ollamaDefaultTag,
+			ModifiedAt: modifiedAt,
+			Digest:     hex.EncodeToString(sum[:]),
+			Details:    ollamaModelDetails(info),
+		})
+	}
+	writeJSON(w, map[string]interface{}{"models": models})
+}
+
+// HandleOllamaShow Ollama /api/show
+func HandleOllamaShow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "无效的请求格式")
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+	info, ok := GetModelInfo(ollamaModelName(name))
+	if !ok {
+		writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", name))
+		return
+	}
+
+	capabilities := []string{"completion", "tools"}
+	if info.SupportsVision {
+		capabilities = append(capabilities, "vision")
+	}
+	if info.SupportsThinking {
+		capabilities = append(capabilities, "thinking")
+	}
+	modelInfo := map[string]interface{}{
+		"general.architecture": "glm",
+		"general.basename":     info.ID,
+	}
+	if info.ContextLength > 0 {
+		modelInfo["glm.context_length"] = info.ContextLength
+	}
+	writeJSON(w, OllamaShowResponse{
+		Template:     "{{ .Prompt }}",
+		Details:      ollamaModelDetails(info),
+		ModelInfo:    modelInfo,
+		Capabilities: capabilities,
+		ModifiedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
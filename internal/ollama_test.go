@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOllamaToChatMessagesToolResults(t *testing.T) {
+	call := func(name, args string) OllamaToolCall {
+		return OllamaToolCall{Function: OllamaToolCallFunction{Name: name, Arguments: json.RawMessage(args)}}
+	}
+	tests := []struct {
+		name     string
+		messages []OllamaMessage
+		// 每条 tool 消息对应的调用下标（按 assistant 消息中的顺序），-1 表示没有对应的调用
+		want []int
+	}{
+		{
+			name: "matched by tool_name",
+			messages: []OllamaMessage{
+				{Role: "user", Content: "天气和时间"},
+				{Role: "assistant", ToolCalls: []OllamaToolCall{call("get_weather", `{"city":"北京"}`), call("get_time", `{}`)}},
+				{Role: "tool", ToolName: "get_time", Content: "12:00"},
+				{Role: "tool", ToolName: "get_weather", Content: "晴"},
+			},
+			want: []int{1, 0},
+		},
+		{
+			name: "same tool called twice",
+			messages: []OllamaMessage{
+				{Role: "assistant", ToolCalls: []OllamaToolCall{call("get_weather", `{"city":"北京"}`), call("get_weather", `{"city":"上海"}`)}},
+				{Role: "tool", ToolName: "get_weather", Content: "晴"},
+				{Role: "tool", ToolName: "get_weather", Content: "雨"},
+			},
+			want: []int{0, 1},
+		},
+		{
+			name: "without tool_name in order",
+			messages: []OllamaMessage{
+				{Role: "assistant", ToolCalls: []OllamaToolCall{call("a", `{}`), call("b", `{}`)}},
+				{Role: "tool", Content: "ra"},
+				{Role: "tool", Content: "rb"},
+			},
+			want: []int{0, 1},
+		},
+		{
+			name: "unknown tool_name",
+			messages: []OllamaMessage{
+				{Role: "assistant", ToolCalls: []OllamaToolCall{call("a", `{}`)}},
+				{Role: "tool", ToolName: "b", Content: "rb"},
+			},
+			want: []int{-1},
+		},
+		{
+			name: "more results than calls",
+			messages: []OllamaMessage{
+				{Role: "assistant", ToolCalls: []OllamaToolCall{call("a", `{}`)}},
+				{Role: "tool", ToolName: "a", Content: "r1"},
+				{Role: "tool", ToolName: "a", Content: "r2"},
+			},
+			want: []int{0, -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := ollamaToChatMessages(tt.messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var calls []ToolCall
+			var got []int
+			for i, m := range messages {
+				if m.Role != tt.messages[i].Role {
+					t.Fatalf("message %d role = %s, want %s", i, m.Role, tt.messages[i].Role)
+				}
+				switch m.Role {
+				case "assistant":
+					calls = m.ToolCalls
+					for _, tc := range calls {
+						if tc.ID == "" || !json.Valid([]byte(tc.Function.Arguments)) {
+							t.Fatalf("invalid tool call %+v", tc)
+						}
+					}
+				case "tool":
+					idx := -1
+					for j, tc := range calls {
+						if tc.ID == m.ToolCallID {
+							idx = j
+						}
+					}
+					if m.ToolCallID != "" && idx == -1 {
+						t.Fatalf("tool_call_id %s does not match any call", m.ToolCallID)
+					}
+					got = append(got, idx)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tool results matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaContent(t *testing.T) {
+	png := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+	tests := []struct {
+		name      string
+		text      string
+		images    []string
+		wantText  string
+		wantParts int
+		wantErr   bool
+	}{
+		{name: "text only", text: "hi", wantText: "hi"},
+		{name: "text and image", text: "看图", images: []string{png}, wantParts: 2},
+		{name: "image only", images: []string{png}, wantParts: 1},
+		{name: "invalid base64", images: []string{"not base64!"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := ollamaContent(tt.text, tt.images)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantParts == 0 {
+				if content != tt.wantText {
+					t.Errorf("content = %v, want %q", content, tt.wantText)
+				}
+				return
+			}
+			parts, ok := content.([]ContentPart)
+			if !ok || len(parts) != tt.wantParts {
+				t.Fatalf("content = %#v, want %d parts", content, tt.wantParts)
+			}
+			img := parts[len(parts)-1]
+			if img.ImageURL == nil || !strings.HasPrefix(img.ImageURL.URL, "data:image/png;base64,") {
+				t.Errorf("image part = %+v", img)
+			}
+		})
+	}
+}
+
+func TestOllamaModelNames(t *testing.T) {
+	think, noThink := true, false
+	tests := []struct {
+		model string
+		think *bool
+		want  string
+	}{
+		{"GLM-4.7:latest", nil, "GLM-4.7"},
+		{"GLM-4.7", &noThink, "GLM-4.7"},
+		{"GLM-4.7:latest", &think, thinkingVariant("GLM-4.7")},
+	}
+	for _, tt := range tests {
+		if got := ollamaThinkingModel(tt.model, tt.think); got != tt.want {
+			t.Errorf("ollamaThinkingModel(%s) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	reasoning := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{"reasoning_content":"想一想"}}]}`
+	toolCall := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"北京\"}"}}]}}]}`
+	toolStop := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`
+	usage := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":7,"total_tokens":12}}`
+	lengthStop := `{"id":"chatcmpl-1","created":1,"model":"GLM-4.7","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`
+
+	tests := []struct {
+		name          string
+		generate      bool
+		showThinking  bool
+		input         string
+		wantContent   string
+		wantThinking  string
+		wantToolCalls int
+		wantReason    string
+	}{
+		{name: "chat", input: chatSSE(chatDelta("你好"), chatDelta("！"), chatStop, usage, "[DONE]"), wantContent: "你好！", wantReason: "stop"},
+		{name: "chat with thinking", showThinking: true, input: chatSSE(reasoning, chatDelta("答"), chatStop, "[DONE]"), wantContent: "答", wantThinking: "想一想", wantReason: "stop"},
+		{name: "thinking hidden", input: chatSSE(reasoning, chatDelta("答"), chatStop, "[DONE]"), wantContent: "答", wantReason: "stop"},
+		{name: "tool calls", input: chatSSE(toolCall, toolStop, "[DONE]"), wantToolCalls: 1, wantReason: "stop"},
+		{name: "generate", generate: true, input: chatSSE(chatDelta("abc"), lengthStop, usage, "[DONE]"), wantContent: "abc", wantReason: "length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s := &ollamaStream{w: rec, model: "GLM-4.7:latest", generate: tt.generate, showThinking: tt.showThinking}
+			sw := newChatStreamWriter(rec, "application/x-ndjson", s.onChunk, s.onDone)
+			sw.Header().Set("Content-Type", "text/event-stream")
+			sw.WriteHeader(http.StatusOK)
+			sw.Write([]byte(tt.input))
+
+			var content, thinking, reason string
+			var toolCalls, doneCount int
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var line struct {
+					Message    *OllamaMessage `json:"message"`
+					Response   string         `json:"response"`
+					Thinking   string         `json:"thinking"`
+					Done       bool           `json:"done"`
+					DoneReason string         `json:"done_reason"`
+					EvalCount  int64          `json:"eval_count"`
+				}
+				if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+					t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+				}
+				if tt.generate {
+					if line.Message != nil {
+						t.Fatal("generate response contains message")
+					}
+					content += line.Response
+					thinking += line.Thinking
+				} else {
+					content += line.Message.Content
+					thinking += line.Message.Thinking
+					toolCalls += len(line.Message.ToolCalls)
+				}
+				if line.Done {
+					doneCount++
+					reason = line.DoneReason
+				}
+			}
+			if content != tt.wantContent || thinking != tt.wantThinking {
+				t.Errorf("content = %q, thinking = %q; want %q, %q", content, thinking, tt.wantContent, tt.wantThinking)
+			}
+			if toolCalls != tt.wantToolCalls {
+				t.Errorf("tool calls = %d, want %d", toolCalls, tt.wantToolCalls)
+			}
+			if doneCount != 1 || reason != tt.wantReason {
+				t.Errorf("done lines = %d, reason = %q; want 1, %q", doneCount, reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestOllamaErrorBody(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"error":{"message":"模型不存在","type":"invalid_request_error"}}`, `{"error":"模型不存在"}` + "\n"},
+		{"not json", "not json"},
+	}
+	for _, tt := range tests {
+		if got := string(ollamaErrorBody(http.StatusNotFound, []byte(tt.body))); got != tt.want {
+			t.Errorf("ollamaErrorBody(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
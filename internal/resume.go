@@ -0,0 +1,395 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamEvent 一个已输出的 SSE 事件
+type streamEvent struct {
+	seq  int64
+	data []byte // 含 "data: " 前缀，不含结尾空行
+}
+
+// resumableStream 单个流式响应的回放缓冲区，按 completionID 索引
+type resumableStream struct {
+	mu       sync.Mutex
+	id       string
+	owner    string
+	events   []streamEvent
+	size     int
+	maxSize  int
+	nextSeq  int64
+	dropped  int64 // 因超出缓冲区上限被丢弃的最大序号
+	done     bool
+	failed   bool          // 非 SSE 响应（错误），不可续传
+	notify   chan struct{} // 有新事件或结束时关闭并替换
+	readers  int           // 正在续传的读取方数量
+	lastRead time.Time     // 最近一个读取方离开的时间
+}
+
+// streamStore 可续传的流式响应
+type streamStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	streams map[string]*resumableStream
+}
+
+var (
+	streamStoreInstance *streamStore
+	streamStoreOnce     sync.Once
+)
+
+// getStreamStore 获取续传缓冲区，未启用时返回 nil
+func getStreamStore() *streamStore {
+	streamStoreOnce.Do(func() {
+		if !Cfg.StreamResumeEnabled {
+			return
+		}
+		streamStoreInstance = &streamStore{
+			ttl:     time.Duration(Cfg.StreamResumeTTL) * time.Second,
+			maxSize: Cfg.StreamResumeBufferKB * 1024,
+			streams: make(map[string]*resumableStream),
+		}
+	})
+	return streamStoreInstance
+}
+
+// start 登记一个新的流式响应
+func (s *streamStore) start(id, owner string) *resumableStream {
+	rs := &resumableStream{id: id, owner: owner, maxSize: s.maxSize, nextSeq: 1, notify: make(chan struct{})}
+	s.mu.Lock()
+	s.streams[id] = rs
+	s.mu.Unlock()
+	return rs
+}
+
+// get 查找调用方自己的流式响应
+func (s *streamStore) get(id, owner string) *resumableStream {
+	s.mu.Lock()
+	rs := s.streams[id]
+	s.mu.Unlock()
+	if rs == nil || rs.owner != owner {
+		return nil
+	}
+	return rs
+}
+
+// finish 标记响应结束，保留 TTL 后删除；失败的响应立即删除
+func (s *streamStore) finish(rs *resumableStream) {
+	rs.mu.Lock()
+	rs.done = true
+	failed := rs.failed
+	close(rs.notify)
+	rs.mu.Unlock()
+
+	remove := func() {
+		s.mu.Lock()
+		if s.streams[rs.id] == rs {
+			delete(s.streams, rs.id)
+		}
+		s.mu.Unlock()
+	}
+	if failed || s.ttl <= 0 {
+		remove()
+		return
+	}
+	time.AfterFunc(s.ttl, remove)
+}
+
+// append 记录事件并返回其序号，超出缓冲区上限时丢弃最早的事件
+func (rs *resumableStream) append(data []byte) int64 {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	seq := rs.nextSeq
+	rs.nextSeq++
+	rs.events = append(rs.events, streamEvent{seq: seq, data: data})
+	rs.size += len(data)
+	for rs.maxSize > 0 && rs.size > rs.maxSize && len(rs.events) > 1 {
+		rs.size -= len(rs.events[0].data)
+		rs.dropped = rs.events[0].seq
+		rs.events = rs.events[1:]
+	}
+	close(rs.notify)
+	rs.notify = make(chan struct{})
+	return seq
+}
+
+// since 返回序号大于 after 的事件；ok 为 false 表示所需事件已被丢弃
+func (rs *resumableStream) since(after int64) (events []streamEvent, done bool, notify chan struct{}, ok bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if after < rs.dropped {
+		return nil, rs.done, rs.notify, false
+	}
+	for _, e := range rs.events {
+		if e.seq > after {
+			events = append(events, e)
+		}
+	}
+	return events, rs.done, rs.notify, true
+}
+
+// attach 登记一个续传读取方，返回的函数在读取结束时调用
+func (rs *resumableStream) attach() func() {
+	rs.mu.Lock()
+	rs.readers++
+	rs.mu.Unlock()
+	return func() {
+		rs.mu.Lock()
+		rs.readers--
+		rs.lastRead = time.Now()
+		rs.mu.Unlock()
+	}
+}
+
+// outliveClient 返回不随客户端断开而取消的上下文，使上游在客户端断线后继续生成；
+// 断开后 grace 内没有读取方续传（或最后一个读取方离开超过 grace）时取消，中止上游生成
+func (rs *resumableStream) outliveClient(clientCtx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(clientCtx))
+	stop := context.AfterFunc(clientCtx, func() {
+		rs.mu.Lock()
+		rs.lastRead = time.Now()
+		rs.mu.Unlock()
+		go rs.cancelWhenUnread(ctx, cancel, grace)
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// cancelWhenUnread 等待读取方续传，无人读取超过 grace 时取消 ctx
+func (rs *resumableStream) cancelWhenUnread(ctx context.Context, cancel context.CancelFunc, grace time.Duration) {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		rs.mu.Lock()
+		readers, idle := rs.readers, time.Since(rs.lastRead)
+		rs.mu.Unlock()
+		if readers == 0 && idle >= grace {
+			LogDebug("[Resume] 客户端断开后无人续传，中止上游生成: id=%s", rs.id)
+			cancel()
+			return
+		}
+		wait := grace
+		if readers == 0 {
+			wait = grace - idle
+		}
+		timer.Reset(wait)
+	}
+}
+
+// streamEventID SSE 事件 ID：<completionID>:<序号>
+func streamEventID(id string, seq int64) string {
+	return fmt.Sprintf("%s:%d", id, seq)
+}
+
+// parseStreamEventID 解析 Last-Event-ID
+func parseStreamEventID(eventID string) (string, int64, bool) {
+	i := strings.LastIndex(eventID, ":")
+	if i <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseInt(eventID[i+1:], 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return eventID[:i], seq, true
+}
+
+// resumableWriter 为每个 SSE 事件添加 id 字段并写入回放缓冲区。
+// 客户端断开后继续记录，供重连的客户端续传（见 outliveClient）。
+type resumableWriter struct {
+	w           http.ResponseWriter
+	stream      *resumableStream
+	buf         []byte
+	wroteHeader bool
+	passthrough bool
+	clientGone  bool
+}
+
+func newResumableWriter(w http.ResponseWriter, rs *resumableStream) *resumableWriter {
+	return &resumableWriter{w: w, stream: rs}
+}
+
+func (rw *resumableWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *resumableWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.passthrough = code != http.StatusOK || !strings.HasPrefix(rw.w.Header().Get("Content-Type"), "text/event-stream")
+	if rw.passthrough {
+		rw.stream.mu.Lock()
+		rw.stream.failed = true
+		rw.stream.mu.Unlock()
+	}
+	rw.w.WriteHeader(code)
+}
+
+func (rw *resumableWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.passthrough {
+		return rw.w.Write(p)
+	}
+	rw.buf = append(rw.buf, p...)
+	for {
+		i := bytes.Index(rw.buf, []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		event := append([]byte(nil), rw.buf[:i]...)
+		rw.buf = rw.buf[i+2:]
+		if !bytes.HasPrefix(event, []byte("data: ")) {
+			rw.writeClient(append(event, '\n', '\n'))
+			continue
+		}
+		seq := rw.stream.append(event)
+		rw.writeClient([]byte(fmt.Sprintf("id: %s\n%s\n\n", streamEventID(rw.stream.id, seq), event)))
+	}
+	return len(p), nil
+}
+
+// writeClient 写入客户端，客户端断开后只记录不再写入
+func (rw *resumableWriter) writeClient(p []byte) {
+	if rw.clientGone {
+		return
+	}
+	if _, err := rw.w.Write(p); err != nil {
+		rw.clientGone = true
+		LogDebug("[Resume] 客户端已断开，继续缓冲: id=%s", rw.stream.id)
+	}
+}
+
+func (rw *resumableWriter) Flush() {
+	if rw.clientGone {
+		return
+	}
+	if flusher, ok := rw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// resumeFromLastEventID 请求带有本服务生成的 Last-Event-ID 时续传对应的响应，而不是重新生成
+func resumeFromLastEventID(w http.ResponseWriter, r *http.Request, owner string) bool {
+	store := getStreamStore()
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if store == nil || lastEventID == "" {
+		return false
+	}
+	id, seq, ok := parseStreamEventID(lastEventID)
+	if !ok {
+		return false
+	}
+	rs := store.get(id, owner)
+	if rs == nil {
+		return false
+	}
+	serveResumedStream(w, r, rs, seq)
+	return true
+}
+
+// HandleChatCompletionStream 续传流式响应：GET /v1/chat/completions/{id}/stream。
+// 从 Last-Event-ID（或 last_event_id 参数）之后开始回放，上游仍在生成时继续推送新事件。
+func HandleChatCompletionStream(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v1/chat/completions/")
+	id := strings.TrimSuffix(rest, "/stream")
+	if id == rest || id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, ErrTypeNotFound, fmt.Sprintf("Invalid URL (%s %s)", r.Method, r.URL.Path), "not_found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeInvalidRequestError(w, "Only GET method is allowed")
+		return
+	}
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	store := getStreamStore()
+	if store == nil {
+		writeError(w, http.StatusNotFound, ErrTypeNotFound, "流式续传未启用", "stream_resume_disabled")
+		return
+	}
+	rs := store.get(id, requestOwner(r, apiKey))
+	if rs == nil {
+		writeError(w, http.StatusNotFound, ErrTypeNotFound, fmt.Sprintf("流式响应 '%s' 不存在或已过期", id), "stream_not_found")
+		return
+	}
+
+	var after int64
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventID != "" {
+		eventStream, seq, ok := parseStreamEventID(lastEventID)
+		if !ok || eventStream != id {
+			writeInvalidRequestError(w, fmt.Sprintf("无效的 Last-Event-ID '%s'", lastEventID))
+			return
+		}
+		after = seq
+	}
+	serveResumedStream(w, r, rs, after)
+}
+
+// serveResumedStream 回放序号大于 after 的事件，并等待后续事件直到响应结束或客户端断开
+func serveResumedStream(w http.ResponseWriter, r *http.Request, rs *resumableStream, after int64) {
+	events, done, notify, ok := rs.since(after)
+	if !ok {
+		writeError(w, http.StatusGone, ErrTypeInvalidRequest, "所需事件已超出回放缓冲区，无法续传", "stream_buffer_exceeded")
+		return
+	}
+	LogDebug("[Resume] 续传流式响应: id=%s, after=%d, done=%v", rs.id, after, done)
+	defer rs.attach()()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		for _, e := range events {
+			if _, err := fmt.Fprintf(w, "id: %s\n%s\n\n", streamEventID(rs.id, e.seq), e.data); err != nil {
+				return
+			}
+			after = e.seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+		if events, done, notify, ok = rs.since(after); !ok {
+			// 客户端读取过慢，缓冲区已覆盖未发送的事件
+			LogWarn("[Resume] 回放缓冲区溢出: id=%s", rs.id)
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseStreamEventID(t *testing.T) {
+	tests := []struct {
+		in     string
+		wantID string
+		seq    int64
+		ok     bool
+	}{
+		{in: streamEventID("chatcmpl-abc", 7), wantID: "chatcmpl-abc", seq: 7, ok: true},
+		{in: "a:b:3", wantID: "a:b", seq: 3, ok: true},
+		{in: "chatcmpl-abc"},
+		{in: ":3"},
+		{in: "id:-1"},
+		{in: "id:x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			id, seq, ok := parseStreamEventID(tt.in)
+			if id != tt.wantID || seq != tt.seq || ok != tt.ok {
+				t.Errorf("parseStreamEventID = %q, %d, %v; want %q, %d, %v", id, seq, ok, tt.wantID, tt.seq, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResumableStreamSince(t *testing.T) {
+	s := &streamStore{maxSize: 10, streams: make(map[string]*resumableStream)}
+	rs := s.start("id", "owner")
+	for _, data := range []string{"aaaa", "bbbb", "cccc"} {
+		rs.append([]byte(data))
+	}
+	// 超出 10 字节后第 1 个事件被丢弃
+	if _, _, _, ok := rs.since(0); ok {
+		t.Error("since(0) should fail after the first event was dropped")
+	}
+	events, done, _, ok := rs.since(1)
+	if !ok || done || len(events) != 2 || events[0].seq != 2 || string(events[1].data) != "cccc" {
+		t.Errorf("since(1) = %+v, done=%v, ok=%v", events, done, ok)
+	}
+
+	if s.get("id", "other") != nil {
+		t.Error("stream visible to another owner")
+	}
+	s.finish(rs)
+	if s.get("id", "owner") != nil {
+		t.Error("stream kept after finish with zero TTL")
+	}
+	if _, done, _, _ := rs.since(3); !done {
+		t.Error("finished stream not marked done")
+	}
+}
+
+func TestResumableStreamOutliveClient(t *testing.T) {
+	const grace = 50 * time.Millisecond
+	tests := []struct {
+		name       string
+		attach     bool
+		wantCancel bool
+	}{
+		{name: "no reader", wantCancel: true},
+		{name: "reader attached", attach: true, wantCancel: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &resumableStream{id: "id", notify: make(chan struct{})}
+			clientCtx, disconnect := context.WithCancel(context.Background())
+			ctx, cancel := rs.outliveClient(clientCtx, grace)
+			defer cancel()
+			if tt.attach {
+				defer rs.attach()()
+			}
+			disconnect()
+			if ctx.Err() != nil {
+				t.Fatal("context cancelled together with the client")
+			}
+			select {
+			case <-ctx.Done():
+				if !tt.wantCancel {
+					t.Error("context cancelled while a reader is attached")
+				}
+			case <-time.After(4 * grace):
+				if tt.wantCancel {
+					t.Error("context not cancelled after grace without readers")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	acmeManager *autocert.Manager
+	certWatcher *fsnotify.Watcher
+)
+
+// certReloader 从文件加载证书，文件变化后自动重新加载
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// GetCertificate 返回当前证书
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch 监听证书所在目录，证书或私钥变化后重新加载；加载失败时继续使用旧证书
+func (c *certReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	certWatcher = watcher
+	names := map[string]bool{
+		filepath.Base(c.certFile): true,
+		filepath.Base(c.keyFile):  true,
+		"..data":                  true, // Kubernetes Secret 通过替换 ..data 链接更新
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !names[filepath.Base(event.Name)] {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					time.Sleep(100 * time.Millisecond) // 等待证书和私钥都写入完成
+					if err := c.load(); err != nil {
+						LogError("[TLS] 重新加载证书失败，继续使用旧证书: %v", err)
+						continue
+					}
+					LogInfo("[TLS] 证书已重新加载: %s", c.certFile)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				LogError("[TLS] 证书监听错误: %v", err)
+			}
+		}
+	}()
+
+	dirs := map[string]bool{filepath.Dir(c.certFile): true, filepath.Dir(c.keyFile): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServerTLSConfig 按配置构造 TLS 配置：ACME_DOMAINS 优先，其次 TLS_CERT_FILE/TLS_KEY_FILE；未启用 TLS 时返回 nil
+func ServerTLSConfig() (*tls.Config, error) {
+	var cfg *tls.Config
+	switch {
+	case len(Cfg.ACMEDomains) > 0:
+		if err := os.MkdirAll(Cfg.ACMECacheDir, 0700); err != nil {
+			return nil, fmt.Errorf("创建 ACME 缓存目录失败: %v", err)
+		}
+		acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(Cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(Cfg.ACMECacheDir),
+			Email:      Cfg.ACMEEmail,
+			Client:     &acme.Client{DirectoryURL: Cfg.ACMEDirectoryURL},
+		}
+		cfg = acmeManager.TLSConfig()
+		LogInfo("[TLS] ACME 已启用: domains=%v, directory=%s", Cfg.ACMEDomains, Cfg.ACMEDirectoryURL)
+	case Cfg.TLSCertFile != "" || Cfg.TLSKeyFile != "":
+		if Cfg.TLSCertFile == "" || Cfg.TLSKeyFile == "" {
+			return nil, fmt.Errorf("TLS_CERT_FILE 和 TLS_KEY_FILE 需要同时设置")
+		}
+		reloader := &certReloader{certFile: Cfg.TLSCertFile, keyFile: Cfg.TLSKeyFile}
+		if err := reloader.load(); err != nil {
+			return nil, fmt.Errorf("加载证书失败: %v", err)
+		}
+		if err := reloader.watch(); err != nil {
+			LogWarn("[TLS] 启动证书监听失败，证书变更需重启生效: %v", err)
+		}
+		cfg = &tls.Config{GetCertificate: reloader.GetCertificate}
+		LogInfo("[TLS] 使用证书文件: %s", Cfg.TLSCertFile)
+	default:
+		return nil, nil
+	}
+
+	cfg.MinVersion = tls.VersionTLS12
+	if !Cfg.HTTP2Enabled {
+		var protos []string
+		for _, p := range cfg.NextProtos {
+			if p != "h2" {
+				protos = append(protos, p)
+			}
+		}
+		cfg.NextProtos = protos
+	}
+	return cfg, nil
+}
+
+// ACMEHTTPHandler 处理 HTTP-01 验证请求，其余请求重定向到 HTTPS；未启用 ACME 时返回 nil
+func ACMEHTTPHandler() http.Handler {
+	if acmeManager == nil {
+		return nil
+	}
+	return acmeManager.HTTPHandler(nil)
+}
+
+// stopCertWatcher 停止证书文件监听
+func stopCertWatcher() {
+	if certWatcher != nil {
+		certWatcher.Close()
+	}
+}
+
+// ListenUnix 监听 Unix socket，清理上次未删除的 socket 文件并设置权限
+func ListenUnix(path, mode string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 UNIX_SOCKET_MODE '%s'", mode)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
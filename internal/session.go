@@ -0,0 +1,419 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sessionHeader 客户端指定会话 ID 的请求头
+	sessionHeader = "X-Session-ID"
+	// sessionKeyMaxLen 会话 ID 最大长度
+	sessionKeyMaxLen = 128
+	// sessionSweepEvery 每保存多少次清理一次过期会话
+	sessionSweepEvery = 100
+)
+
+// 会话状态，通过 X-Session 响应头返回
+const (
+	SessionStateNew      = "new"      // 新建上游对话
+	SessionStateContinue = "continue" // 复用上游对话，只发送新消息
+	SessionStateRebuild  = "rebuild"  // 上游对话失效，使用本地历史重建
+)
+
+// upstreamChat 上游对话标识；ChatID 为空时由 makeUpstreamRequest 新建
+type upstreamChat struct {
+	ChatID    string
+	ParentID  string // 上一条助手消息 ID
+	UserMsgID string // 本轮用户消息 ID（由 makeUpstreamRequest 填充）
+	MessageID string // 本轮助手消息 ID（由 makeUpstreamRequest 填充）
+}
+
+// chatSession 持久化的会话状态
+type chatSession struct {
+	Key       string    `json:"key"`
+	Owner     string    `json:"owner"`
+	Model     string    `json:"model"`
+	TokenRef  string    `json:"token_ref"` // 上游对话绑定的 token 的哈希，token 本身不落盘
+	ChatID    string    `json:"chat_id"`
+	ParentID  string    `json:"parent_id"`
+	History   []Message `json:"history"` // 客户端视角的完整对话，用于比对和重建
+	UpdatedAt int64     `json:"updated_at"`
+}
+
+// sessionTurn 一次请求对应的会话轮次
+type sessionTurn struct {
+	store    *sessionStore
+	lock     *sessionLock
+	session  *chatSession
+	State    string
+	History  []Message // 本轮完整对话（不含本轮回复）
+	New      []Message // 本轮新增的消息
+	token    string    // 复用对话时使用的 token
+	released bool
+}
+
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// sessionStore 会话存储，每个会话一个 JSON 文件
+type sessionStore struct {
+	mu        sync.Mutex
+	dir       string
+	ttl       time.Duration
+	locks     map[string]*sessionLock
+	saveCount int64
+	// tokens 不在 token 池中的 token（匿名 token），只保存在内存中，重启后对应会话改为重建
+	tokens map[string]string
+}
+
+var (
+	sessionStoreInstance *sessionStore
+	sessionStoreOnce     sync.Once
+)
+
+// getSessionStore 获取会话存储，未启用时返回 nil
+func getSessionStore() *sessionStore {
+	sessionStoreOnce.Do(func() {
+		if Cfg.SessionMode != "header" && Cfg.SessionMode != "user" {
+			return
+		}
+		if err := os.MkdirAll(Cfg.SessionDir, 0755); err != nil {
+			LogError("[Session] 创建会话目录失败: %v", err)
+			return
+		}
+		sessionStoreInstance = &sessionStore{
+			dir:    Cfg.SessionDir,
+			ttl:    time.Duration(Cfg.SessionTTL) * time.Second,
+			locks:  make(map[string]*sessionLock),
+			tokens: make(map[string]string),
+		}
+		LogInfo("[Session] 会话模式已启用: mode=%s, dir=%s", Cfg.SessionMode, Cfg.SessionDir)
+	})
+	return sessionStoreInstance
+}
+
+// sessionKey 从请求头或 user 字段获取会话 ID，explicit 表示来自 X-Session-ID 请求头
+func sessionKey(r *http.Request, req *ChatRequest) (key string, explicit bool) {
+	key = strings.TrimSpace(r.Header.Get(sessionHeader))
+	explicit = key != ""
+	if key == "" && Cfg.SessionMode == "user" {
+		key = strings.TrimSpace(req.User)
+	}
+	if len(key) > sessionKeyMaxLen {
+		return "", false
+	}
+	return key, explicit
+}
+
+// rememberToken 记录会话绑定的 token，返回保存到磁盘的引用
+func (s *sessionStore) rememberToken(token string) string {
+	ref := tokenKey(token)
+	if GetTokenManager().lookupToken(ref) == "" && (GetBackupToken() == "" || tokenKey(GetBackupToken()) != ref) {
+		s.mu.Lock()
+		s.tokens[ref] = token
+		s.mu.Unlock()
+	}
+	return ref
+}
+
+// resolveToken 根据引用找回 token：token 池、备用 token、内存中的匿名 token
+func (s *sessionStore) resolveToken(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	if token := GetTokenManager().lookupToken(ref); token != "" {
+		return token
+	}
+	if backup := GetBackupToken(); backup != "" && tokenKey(backup) == ref {
+		return backup
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[ref]
+}
+
+func (s *sessionStore) id(owner, key string) string {
+	sum := sha256.Sum256([]byte(owner + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sessionStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// acquire 获取会话锁，同一会话的请求串行处理
+func (s *sessionStore) acquire(id string) *sessionLock {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sessionLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+	l.mu.Lock()
+	return l
+}
+
+func (s *sessionStore) release(id string, l *sessionLock) {
+	l.mu.Unlock()
+	s.mu.Lock()
+	l.refs--
+	if l.refs == 0 {
+		delete(s.locks, id)
+	}
+	s.mu.Unlock()
+}
+
+func (s *sessionStore) load(id string) *chatSession {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return nil
+	}
+	var session chatSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		os.Remove(s.path(id))
+		return nil
+	}
+	if s.expired(&session) {
+		os.Remove(s.path(id))
+		return nil
+	}
+	return &session
+}
+
+func (s *sessionStore) expired(session *chatSession) bool {
+	return s.ttl > 0 && time.Since(time.Unix(session.UpdatedAt, 0)) > s.ttl
+}
+
+func (s *sessionStore) save(id string, session *chatSession) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return
+	}
+	tmp := s.path(id) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		LogError("[Session] 保存会话失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path(id)); err != nil {
+		LogError("[Session] 保存会话失败: %v", err)
+		os.Remove(tmp)
+		return
+	}
+	if atomic.AddInt64(&s.saveCount, 1)%sessionSweepEvery == 0 {
+		go s.sweep()
+	}
+}
+
+// sweep 清理过期的会话文件，以及不再被会话引用的匿名 token
+func (s *sessionStore) sweep() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	removed := 0
+	live := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		session := s.load(strings.TrimSuffix(e.Name(), ".json"))
+		if session == nil {
+			removed++
+			continue
+		}
+		live[session.TokenRef] = true
+	}
+	s.mu.Lock()
+	for ref := range s.tokens {
+		if !live[ref] {
+			delete(s.tokens, ref)
+		}
+	}
+	s.mu.Unlock()
+	if removed > 0 {
+		LogDebug("[Session] 已清理 %d 个过期会话", removed)
+	}
+}
+
+// begin 开始一轮会话：加锁、加载会话并计算本轮新增的消息
+func (s *sessionStore) begin(owner, key, model string, messages []Message, explicit bool) *sessionTurn {
+	id := s.id(owner, key)
+	turn := &sessionTurn{store: s, lock: s.acquire(id)}
+	session := s.load(id)
+	if session == nil {
+		session = &chatSession{Key: key, Owner: owner}
+	}
+	turn.session = session
+
+	history, added, ok := diffSessionMessages(session.History, messages, explicit)
+	if !ok {
+		// 客户端历史与本地记录不一致（编辑或重新生成），开启新对话
+		history, added = messages, messages
+		session.ChatID, session.ParentID = "", ""
+	}
+	turn.History, turn.New = history, added
+	turn.State = SessionStateNew
+	if session.ChatID != "" && session.Model == model && len(added) > 0 {
+		if turn.token = s.resolveToken(session.TokenRef); turn.token != "" {
+			turn.State = SessionStateContinue
+		}
+	}
+	return turn
+}
+
+// Messages 返回需要发送给上游的消息：复用对话时只发送新消息
+func (t *sessionTurn) Messages() []Message {
+	if t.State == SessionStateContinue {
+		return t.New
+	}
+	return t.History
+}
+
+// Token 复用对话时返回对话绑定的 token
+func (t *sessionTurn) Token() string {
+	if t.State == SessionStateContinue {
+		return t.token
+	}
+	return ""
+}
+
+// Chat 返回本轮使用的上游对话标识
+func (t *sessionTurn) Chat() *upstreamChat {
+	if t.State == SessionStateContinue {
+		return &upstreamChat{ChatID: t.session.ChatID, ParentID: t.session.ParentID}
+	}
+	return &upstreamChat{}
+}
+
+// Rebuild 上游对话失效后改为携带完整历史新建对话
+func (t *sessionTurn) Rebuild() {
+	t.State = SessionStateRebuild
+	t.session.ChatID, t.session.ParentID = "", ""
+}
+
+// Commit 记录本轮回复并保存会话
+func (t *sessionTurn) Commit(model, token string, chat *upstreamChat, reply MessageResp) {
+	s := t.session
+	s.Model = model
+	s.TokenRef = t.store.rememberToken(token)
+	s.ChatID = chat.ChatID
+	s.ParentID = chat.MessageID
+	s.History = append(append([]Message{}, t.History...), Message{
+		Role:      "assistant",
+		Content:   reply.Content,
+		ToolCalls: reply.ToolCalls,
+	})
+	s.UpdatedAt = time.Now().Unix()
+	t.store.save(t.store.id(s.Owner, s.Key), s)
+}
+
+// Release 释放会话锁
+func (t *sessionTurn) Release() {
+	if t.released {
+		return
+	}
+	t.released = true
+	t.store.release(t.store.id(t.session.Owner, t.session.Key), t.lock)
+}
+
+// diffSessionMessages 比对本地历史与请求消息，返回完整对话和新增消息。
+// 请求以本地历史开头时，其余部分为新增消息；通过 X-Session-ID 显式指定会话（explicit）且请求不含助手消息时，
+// 视为只发送了新一轮消息。仅以 user 字段关联的请求必须携带完整历史，否则视为新对话。
+func diffSessionMessages(history, messages []Message, explicit bool) (full, added []Message, ok bool) {
+	if len(history) == 0 {
+		return messages, messages, true
+	}
+	if len(messages) > len(history) && messagesMatch(history, messages[:len(history)]) {
+		return messages, messages[len(history):], true
+	}
+	if !explicit {
+		return nil, nil, false
+	}
+	for _, m := range messages {
+		if m.Role == "assistant" {
+			return nil, nil, false
+		}
+	}
+	// 只发送了新消息时，重复的系统提示词不再追加
+	added = messages
+	for len(added) > 0 && added[0].Role == "system" && containsMessage(history, added[0]) {
+		added = added[1:]
+	}
+	if len(added) == 0 {
+		return nil, nil, false
+	}
+	full = append(append([]Message{}, history...), added...)
+	return full, added, true
+}
+
+func messagesMatch(a, b []Message) bool {
+	for i := range a {
+		if messageFingerprint(a[i]) != messageFingerprint(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsMessage(messages []Message, m Message) bool {
+	fp := messageFingerprint(m)
+	for _, h := range messages {
+		if messageFingerprint(h) == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// messageFingerprint 消息指纹，忽略客户端回传时可能变化的格式差异
+func messageFingerprint(m Message) string {
+	text, images, videos := m.ParseContentFull()
+	var b strings.Builder
+	b.WriteString(m.Role)
+	b.WriteByte(0)
+	b.WriteString(strings.TrimSpace(text))
+	for _, u := range append(images, videos...) {
+		b.WriteByte(0)
+		b.WriteString(u)
+	}
+	b.WriteByte(0)
+	b.WriteString(m.ToolCallID)
+	for _, tc := range m.ToolCalls {
+		b.WriteByte(0)
+		b.WriteString(tc.ID)
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionReplayKey 上游对话失效后重放请求时携带的上下文键
+type sessionReplayKey struct{}
+
+// replaySessionTurn 使用本地完整历史重新处理请求
+func replaySessionTurn(w http.ResponseWriter, r *http.Request, req *ChatRequest, turn *sessionTurn) {
+	turn.Rebuild()
+	replay := *req
+	replay.Messages = turn.History
+	data, _ := json.Marshal(&replay)
+	ctx := context.WithValue(r.Context(), sessionReplayKey{}, turn)
+	r2 := r.Clone(ctx)
+	r2.Body = io.NopCloser(bytes.NewReader(data))
+	r2.ContentLength = int64(len(data))
+	HandleChatCompletions(w, r2)
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSessionMessages(t *testing.T) {
+	sys := Message{Role: "system", Content: "你是助手"}
+	u1 := Message{Role: "user", Content: "你好"}
+	a1 := Message{Role: "assistant", Content: "你好！"}
+	u2 := Message{Role: "user", Content: "今天天气如何"}
+	a1Parts := Message{Role: "assistant", Content: []interface{}{map[string]interface{}{"type": "text", "text": "你好！ "}}}
+	history := []Message{sys, u1, a1}
+
+	tests := []struct {
+		name      string
+		history   []Message
+		messages  []Message
+		explicit  bool
+		wantFull  []Message
+		wantAdded []Message
+		wantOK    bool
+	}{
+		{name: "no history", messages: []Message{u1}, wantFull: []Message{u1}, wantAdded: []Message{u1}, wantOK: true},
+		{name: "full history", history: history, messages: []Message{sys, u1, a1, u2}, wantFull: []Message{sys, u1, a1, u2}, wantAdded: []Message{u2}, wantOK: true},
+		{name: "full history with reformatted content", history: history, messages: []Message{sys, u1, a1Parts, u2}, wantFull: []Message{sys, u1, a1Parts, u2}, wantAdded: []Message{u2}, wantOK: true},
+		{name: "edited history", history: history, messages: []Message{sys, u2, a1, u1}},
+		{name: "same messages without new turn", history: history, messages: []Message{sys, u1, a1}, explicit: true},
+		{name: "new messages only with explicit session", history: history, messages: []Message{u2}, explicit: true, wantFull: []Message{sys, u1, a1, u2}, wantAdded: []Message{u2}, wantOK: true},
+		{name: "repeated system prompt dropped", history: history, messages: []Message{sys, u2}, explicit: true, wantFull: []Message{sys, u1, a1, u2}, wantAdded: []Message{u2}, wantOK: true},
+		{name: "only system prompt", history: history, messages: []Message{sys}, explicit: true},
+		{name: "new messages only without explicit session", history: history, messages: []Message{u2}},
+		{name: "diverged history with assistant message", history: history, messages: []Message{u2, a1, u2}, explicit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			full, added, ok := diffSessionMessages(tt.history, tt.messages, tt.explicit)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(full, tt.wantFull) {
+				t.Errorf("full = %v, want %v", full, tt.wantFull)
+			}
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("added = %v, want %v", added, tt.wantAdded)
+			}
+		})
+	}
+}
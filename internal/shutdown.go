@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 优雅关闭分为两个阶段：
+//  1. 排空（drain）：不再接收新请求、批处理不再派发新请求，等待进行中的请求完成；
+//  2. 中断（cutoff）：超过期限后关闭剩余的上游响应，流式响应以错误事件结束。
+var (
+	drainCtx, beginDrain   = context.WithCancel(context.Background())
+	cutoffCtx, cutInFlight = context.WithCancel(context.Background())
+
+	// workersStop 关闭后后台定时任务退出
+	workersStop     = make(chan struct{})
+	workersStopOnce sync.Once
+
+	// hijackedConns 已升级的 WebSocket 连接数，http.Server.Shutdown 不会等待这些连接
+	hijackedConns int64
+)
+
+// BeginDrain 进入排空阶段
+func BeginDrain() {
+	if drainCtx.Err() != nil {
+		return
+	}
+	LogInfo("[Shutdown] 停止接收新请求，等待进行中的请求完成")
+	beginDrain()
+	getBatchManager().stopDispatch()
+}
+
+// Draining 是否正在关闭
+func Draining() bool {
+	return drainCtx.Err() != nil
+}
+
+// CutInFlight 中断所有仍在进行的上游请求
+func CutInFlight() {
+	if cutoffCtx.Err() != nil {
+		return
+	}
+	LogWarn("[Shutdown] 排空超时，中断剩余请求")
+	cutInFlight()
+}
+
+// WaitHijacked 等待 WebSocket 连接关闭
+func WaitHijacked() {
+	for atomic.LoadInt64(&hijackedConns) > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// WaitBatchRunner 等待正在执行的批处理请求结束，未完成的部分在重启后继续
+func WaitBatchRunner() {
+	getBatchManager().waitStopped()
+}
+
+// StopBackgroundWorkers 停止后台任务：token 校验与文件监听、版本与模型更新、模型目录和证书监听
+func StopBackgroundWorkers() {
+	workersStopOnce.Do(func() {
+		close(workersStop)
+		GetTokenManager().Stop()
+		stopModelCatalogWatcher()
+		stopCertWatcher()
+	})
+}
@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,6 +27,8 @@ type Telemetry struct {
 	minuteOutputTok int64
 	requestTimes    []time.Time
 	modelStats      map[string]*ModelStats
+	cacheHits       int64
+	cacheMisses     int64
 	mu              sync.Mutex
 }
 
@@ -54,6 +59,15 @@ func RecordRequest(inputTokens, outputTokens int64, model string) {
 	telemetry.mu.Unlock()
 }
 
+// RecordCacheLookup 记录响应缓存命中情况
+func RecordCacheLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&telemetry.cacheHits, 1)
+	} else {
+		atomic.AddInt64(&telemetry.cacheMisses, 1)
+	}
+}
+
 func GetRPM() int {
 	telemetry.mu.Lock()
 	defer telemetry.mu.Unlock()
@@ -82,7 +96,10 @@ type TelemetryData struct {
 	TotalCalls      int64                  `json:"total_calls"`
 	SuccessCalls    int64                  `json:"success_calls"`
 	SuccessRate     float64                `json:"success_rate"`
+	CacheHits       int64                  `json:"cache_hits"`
+	CacheMisses     int64                  `json:"cache_misses"`
 	ModelStats      map[string]*ModelStats `json:"model_stats,omitempty"`
+	Queue           *QueueStats            `json:"queue,omitempty"`
 }
 
 func GetTelemetryData() TelemetryData {
@@ -127,8 +144,96 @@ func GetTelemetryData() TelemetryData {
 		TotalCalls:      tmStats.TotalCalls,
 		SuccessCalls:    tmStats.SuccessCalls,
 		SuccessRate:     tmStats.SuccessRate,
+		CacheHits:       atomic.LoadInt64(&telemetry.cacheHits),
+		CacheMisses:     atomic.LoadInt64(&telemetry.cacheMisses),
 		ModelStats:      modelStatsCopy,
+		Queue:           GetQueueStats(),
+	}
+}
+
+// persistedTelemetry 持久化的累计计数，重启后继续累计
+type persistedTelemetry struct {
+	TotalRequests   int64                  `json:"total_requests"`
+	TotalInputTok   int64                  `json:"total_input_tokens"`
+	TotalOutputTok  int64                  `json:"total_output_tokens"`
+	CacheHits       int64                  `json:"cache_hits"`
+	CacheMisses     int64                  `json:"cache_misses"`
+	TotalCalls      int64                  `json:"total_calls"`
+	SuccessCalls    int64                  `json:"success_calls"`
+	MultimodalCalls int64                  `json:"multimodal_calls"`
+	ModelStats      map[string]*ModelStats `json:"model_stats,omitempty"`
+	SavedAt         int64                  `json:"saved_at"`
+}
+
+// LoadTelemetry 从 TELEMETRY_FILE 恢复累计计数
+func LoadTelemetry() {
+	if Cfg.TelemetryFile == "" {
+		return
+	}
+	data, err := os.ReadFile(Cfg.TelemetryFile)
+	if err != nil {
+		return
+	}
+	var saved persistedTelemetry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		LogWarn("[Telemetry] 统计文件损坏: %v", err)
+		return
+	}
+	atomic.StoreInt64(&telemetry.TotalRequests, saved.TotalRequests)
+	atomic.StoreInt64(&telemetry.TotalInputTok, saved.TotalInputTok)
+	atomic.StoreInt64(&telemetry.TotalOutputTok, saved.TotalOutputTok)
+	atomic.StoreInt64(&telemetry.cacheHits, saved.CacheHits)
+	atomic.StoreInt64(&telemetry.cacheMisses, saved.CacheMisses)
+	telemetry.mu.Lock()
+	for model, stats := range saved.ModelStats {
+		telemetry.modelStats[model] = stats
+	}
+	telemetry.mu.Unlock()
+	GetTokenManager().restoreCounters(saved.TotalCalls, saved.SuccessCalls, saved.MultimodalCalls)
+	LogInfo("[Telemetry] 已恢复统计数据: total_requests=%d", saved.TotalRequests)
+}
+
+// SaveTelemetry 将累计计数写入 TELEMETRY_FILE
+func SaveTelemetry() {
+	if Cfg.TelemetryFile == "" {
+		return
+	}
+	tmStats := GetTokenManager().GetStats()
+	saved := persistedTelemetry{
+		TotalRequests:   atomic.LoadInt64(&telemetry.TotalRequests),
+		TotalInputTok:   atomic.LoadInt64(&telemetry.TotalInputTok),
+		TotalOutputTok:  atomic.LoadInt64(&telemetry.TotalOutputTok),
+		CacheHits:       atomic.LoadInt64(&telemetry.cacheHits),
+		CacheMisses:     atomic.LoadInt64(&telemetry.cacheMisses),
+		TotalCalls:      tmStats.TotalCalls,
+		SuccessCalls:    tmStats.SuccessCalls,
+		MultimodalCalls: tmStats.MultimodalCount,
+		ModelStats:      make(map[string]*ModelStats),
+		SavedAt:         time.Now().Unix(),
+	}
+	telemetry.mu.Lock()
+	for model, stats := range telemetry.modelStats {
+		copied := *stats
+		saved.ModelStats[model] = &copied
+	}
+	telemetry.mu.Unlock()
+
+	data, _ := json.MarshalIndent(saved, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(Cfg.TelemetryFile), 0755); err != nil {
+		LogError("[Telemetry] 保存统计数据失败: %v", err)
+		return
+	}
+	tmp := Cfg.TelemetryFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		LogError("[Telemetry] 保存统计数据失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, Cfg.TelemetryFile); err != nil {
+		LogError("[Telemetry] 保存统计数据失败: %v", err)
+		os.Remove(tmp)
+		return
 	}
+	LogInfo("[Telemetry] 统计数据已保存: %s", Cfg.TelemetryFile)
 }
 
 func formatDuration(d time.Duration) string {
@@ -257,6 +257,11 @@ func (tm *TokenManager) validateAllTokens() {
 	// 更新有效 token 列表
 	tm.rebuildValidTokens()
 	LogInfo("Token 验证完成，失效 %d 个，剩余有效 %d 个", invalidCount, len(tm.validTokens))
+	if len(tokens) > 0 && invalidCount == len(tokens) {
+		recordWorkerRun(WorkerTokenValidator, fmt.Errorf("all %d tokens are invalid", invalidCount))
+	} else {
+		recordWorkerRun(WorkerTokenValidator, nil)
+	}
 
 	// 自动删除失效 token
 	if invalidCount > 0 {
@@ -409,6 +414,18 @@ func (tm *TokenManager) GetToken() string {
 	return token
 }
 
+// lookupToken 根据 tokenKey 查找 token 池中的有效 token，未找到时返回空字符串
+func (tm *TokenManager) lookupToken(ref string) string {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	for _, token := range tm.validTokens {
+		if tokenKey(token) == ref {
+			return token
+		}
+	}
+	return ""
+}
+
 // RecordCall 记录调用
 func (tm *TokenManager) RecordCall(success bool, isMultimodal bool) {
 	atomic.AddInt64(&tm.totalCalls, 1)
@@ -420,6 +437,13 @@ func (tm *TokenManager) RecordCall(success bool, isMultimodal bool) {
 	}
 }
 
+// restoreCounters 恢复持久化的调用计数
+func (tm *TokenManager) restoreCounters(total, success, multimodal int64) {
+	atomic.StoreInt64(&tm.totalCalls, total)
+	atomic.StoreInt64(&tm.successCalls, success)
+	atomic.StoreInt64(&tm.multimodalCount, multimodal)
+}
+
 // GetStats 获取统计数据
 func (tm *TokenManager) GetStats() TokenManagerStats {
 	tm.mu.RLock()
@@ -5,12 +5,24 @@ import (
 	"unicode/utf8"
 )
 
-// CountTokens 精确计算文本的token数
-// 使用优化的算法：基于字符类型加权计算
+// CountTokens 计算文本的token数
+// 优先使用 GLM 词表 BPE 分词，词表不可用时回退到估算
 func CountTokens(text string) int64 {
 	if text == "" {
 		return 0
 	}
+	if tok := GetBPETokenizer(); tok != nil {
+		return tok.Count(text)
+	}
+	return EstimateTokens(text)
+}
+
+// EstimateTokens 估算文本的token数
+// 基于字符类型加权计算
+func EstimateTokens(text string) int64 {
+	if text == "" {
+		return 0
+	}
 
 	var tokens float64
 	for _, r := range text {
@@ -37,14 +49,28 @@ func CountTokens(text string) int64 {
 	}
 	return result
 }
+
+// CountMessagesTokens 计算消息列表的token数（含助手消息的工具调用和工具结果的调用 ID）
+// 使用 GLM 词表时按 [gMASK]<sop><|role|>\n内容...<|assistant|> 模板计数
 func CountMessagesTokens(messages []Message) int64 {
 	var total int64
 
+	if GetBPETokenizer() != nil {
+		total += 2
+		for _, msg := range messages {
+			text, _ := msg.ParseContent()
+			total += 1 + CountTokens("\n"+text)
+			total += CountToolCallTokens(msg.ToolCalls) + CountTokens(msg.ToolCallID)
+		}
+		return total + 1
+	}
+
 	for _, msg := range messages {
 		total += 4
 		total += CountTokens(msg.Role)
 		text, _ := msg.ParseContent()
 		total += CountTokens(text)
+		total += CountToolCallTokens(msg.ToolCalls) + CountTokens(msg.ToolCallID)
 	}
 	total += 3
 
@@ -59,7 +85,7 @@ func CountToolsTokens(tools []Tool) int64 {
 	for _, tool := range tools {
 		// type 字段
 		total += CountTokens(tool.Type)
-		total += 3 
+		total += 3
 		total += CountTokens(tool.Function.Name)
 		total += CountTokens(tool.Function.Description)
 
@@ -0,0 +1,38 @@
+package internal
+
+import "testing"
+
+func TestCountMessagesTokensToolCalls(t *testing.T) {
+	calls := []ToolCall{{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"北京"}`}}}
+	tests := []struct {
+		name  string
+		plain Message
+		tool  Message
+		extra int64
+	}{
+		{
+			name:  "assistant tool calls",
+			plain: Message{Role: "assistant", Content: ""},
+			tool:  Message{Role: "assistant", Content: "", ToolCalls: calls},
+			extra: CountToolCallTokens(calls),
+		},
+		{
+			name:  "tool result call id",
+			plain: Message{Role: "tool", Content: "晴"},
+			tool:  Message{Role: "tool", Content: "晴", ToolCallID: "call_1"},
+			extra: CountTokens("call_1"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.extra <= 0 {
+				t.Fatalf("extra = %d, want > 0", tt.extra)
+			}
+			base := CountMessagesTokens([]Message{tt.plain})
+			got := CountMessagesTokens([]Message{tt.tool})
+			if got != base+tt.extra {
+				t.Errorf("CountMessagesTokens = %d, want %d + %d", got, base, tt.extra)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"net/textproto"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,12 +21,16 @@ import (
 // ErrRequestFailed 统一的请求失败错误
 var ErrRequestFailed = errors.New("请求失败")
 
+// ErrMediaTooLarge 媒体文件超出大小限制
+var ErrMediaTooLarge = errors.New("媒体文件超出大小限制")
+
 // MediaType 媒体类型
 type MediaType string
 
 const (
 	MediaTypeImage MediaType = "image"
 	MediaTypeVideo MediaType = "video"
+	MediaTypeFile  MediaType = "file" // PDF、Office 等文档
 )
 
 // FileUploadResponse z.ai 文件上传响应
@@ -124,6 +129,12 @@ func parseBase64Data(dataURL string) (data []byte, contentType string, err error
 		}
 	}
 
+	// 解码前按编码长度估算大小，避免解码超大数据
+	if maxBytes := maxUploadFileBytes(); maxBytes > 0 && int64(base64.StdEncoding.DecodedLen(len(parts[1]))) > maxBytes+2 {
+		LogError("base64 data too large: limit %d bytes", maxBytes)
+		return nil, "", ErrMediaTooLarge
+	}
+
 	// 解码 base64
 	data, err = base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
@@ -176,27 +187,19 @@ func downloadFromURL(url string) (data []byte, contentType string, filename stri
 	}
 	LogDebug("[Download] Starting: %s", urlPreview)
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		LogError("[Download] create request error: %v", err)
-		return nil, "", "", ErrRequestFailed
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-	req.Header.Set("Accept", "image/*, video/*, */*")
+	header := make(http.Header)
+	header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	header.Set("Accept", "image/*, video/*, */*")
 	if strings.Contains(url, "qq.com") {
-		req.Header.Set("Referer", "https://qq.com/")
+		header.Set("Referer", "https://qq.com/")
 	}
 
-	resp, err := client.Do(req)
+	resp, err := getSafeFetcher().Get(url, header)
 	if err != nil {
 		LogError("[Download] request error: %v", err)
+		if errors.Is(err, ErrFetchBlocked) {
+			return nil, "", "", ErrFetchBlocked
+		}
 		return nil, "", "", ErrRequestFailed
 	}
 	defer resp.Body.Close()
@@ -206,11 +209,24 @@ func downloadFromURL(url string) (data []byte, contentType string, filename stri
 		return nil, "", "", ErrRequestFailed
 	}
 
-	data, err = io.ReadAll(resp.Body)
+	maxBytes := maxUploadFileBytes()
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		LogError("[Download] file too large: %d bytes (limit %d)", resp.ContentLength, maxBytes)
+		return nil, "", "", ErrMediaTooLarge
+	}
+	reader := io.Reader(resp.Body)
+	if maxBytes > 0 {
+		reader = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	data, err = io.ReadAll(reader)
 	if err != nil {
 		LogError("[Download] read body error: %v", err)
 		return nil, "", "", ErrRequestFailed
 	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		LogError("[Download] file too large: exceeds limit %d bytes", maxBytes)
+		return nil, "", "", ErrMediaTooLarge
+	}
 
 	contentType = resp.Header.Get("Content-Type")
 	LogDebug("[Download] Success: size=%d, contentType=%s", len(data), contentType)
@@ -343,30 +359,22 @@ func isUnsupportedMediaURL(url string) bool {
 
 // UploadMedia 通用媒体上传（支持图片和视频，支持 base64 和 URL）
 func UploadMedia(token string, mediaURL string, mediaType MediaType) (*UpstreamFile, error) {
+	return uploadMediaWithBudget(token, "", mediaURL, mediaType, nil)
+}
+
+// loadMedia 读取 base64 或 URL 媒体数据，返回数据、MIME 类型、文件名和实际媒体类型
+func loadMedia(mediaURL string, mediaType MediaType) ([]byte, string, string, MediaType, error) {
 	var fileData []byte
 	var filename string
 	var contentType string
 
-	// 记录上传开始
-	urlPreview := mediaURL
-	if len(urlPreview) > 100 {
-		urlPreview = urlPreview[:100] + "..."
-	}
-	LogDebug("[Upload] Starting upload: type=%s, url=%s", mediaType, urlPreview)
-
-	// 跳过不支持的URL
-	if isUnsupportedMediaURL(mediaURL) {
-		LogDebug("[Upload] Skipping unsupported media URL: %s", urlPreview)
-		return nil, nil
-	}
-
 	if strings.HasPrefix(mediaURL, "data:") {
 		// Base64 编码
 		var err error
 		fileData, contentType, err = parseBase64Data(mediaURL)
 		if err != nil {
 			LogDebug("[Upload] Base64 parse failed: %v", err)
-			return nil, err
+			return nil, "", "", mediaType, err
 		}
 		LogDebug("[Upload] Base64 parsed: contentType=%s, dataSize=%d bytes", contentType, len(fileData))
 		// 根据 MIME 类型确定默认
@@ -385,7 +393,7 @@ func UploadMedia(token string, mediaURL string, mediaType MediaType) (*UpstreamF
 		fileData, contentType, filename, err = downloadFromURL(mediaURL)
 		if err != nil {
 			LogDebug("[Upload] URL download failed: %v", err)
-			return nil, err
+			return nil, "", "", mediaType, err
 		}
 		LogDebug("[Upload] Downloaded from URL: filename=%s, contentType=%s, size=%d bytes", filename, contentType, len(fileData))
 		// 检查文件名有效性
@@ -409,6 +417,49 @@ func UploadMedia(token string, mediaURL string, mediaType MediaType) (*UpstreamF
 			mediaType = detectedType
 		}
 	}
+	return fileData, contentType, filename, mediaType, nil
+}
+
+// uploadMediaWithBudget 上传单个媒体文件，budget 不为空时计入单次请求的总大小限制
+// mediaURL 为 file-... 时读取 owner 在 /v1/files 中上传的文件
+func uploadMediaWithBudget(token string, owner string, mediaURL string, mediaType MediaType, budget *uploadBudget) (*UpstreamFile, error) {
+	// 记录上传开始
+	urlPreview := mediaURL
+	if len(urlPreview) > 100 {
+		urlPreview = urlPreview[:100] + "..."
+	}
+	LogDebug("[Upload] Starting upload: type=%s, url=%s", mediaType, urlPreview)
+
+	if isFileID(mediaURL) {
+		return getFileStore().upstreamFile(token, mediaURL, owner, budget)
+	}
+
+	// 跳过不支持的URL
+	if isUnsupportedMediaURL(mediaURL) {
+		LogDebug("[Upload] Skipping unsupported media URL: %s", urlPreview)
+		return nil, nil
+	}
+
+	fileData, contentType, filename, mediaType, err := loadMedia(mediaURL, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	if err := budget.reserve(int64(len(fileData))); err != nil {
+		LogError("[Upload] request media size limit exceeded: %v", err)
+		return nil, err
+	}
+
+	return uploadData(token, fileData, filename, contentType, mediaType)
+}
+
+// uploadData 上传已读取的文件数据，相同 token 下内容相同的文件直接复用已上传的文件 ID
+func uploadData(token string, fileData []byte, filename string, contentType string, mediaType MediaType) (*UpstreamFile, error) {
+	cacheKey := uploadCacheKey(token, fileData)
+	if cached, ok := getUploadCache().get(cacheKey); ok {
+		LogDebug("[Upload] Reusing uploaded file: id=%s", cached.ID)
+		cached.ItemID = uuid.New().String()
+		return cached, nil
+	}
 
 	// 上传到 z.ai
 	LogDebug("[Upload] Uploading to z.ai: filename=%s, contentType=%s, size=%d bytes", filename, contentType, len(fileData))
@@ -419,7 +470,7 @@ func UploadMedia(token string, mediaURL string, mediaType MediaType) (*UpstreamF
 	}
 	LogDebug("[Upload] Upload success: id=%s, cdnURL=%s", uploadResp.ID, uploadResp.Meta.CdnURL)
 
-	return &UpstreamFile{
+	file := &UpstreamFile{
 		Type:   string(mediaType),
 		File:   *uploadResp,
 		ID:     uploadResp.ID,
@@ -430,7 +481,9 @@ func UploadMedia(token string, mediaURL string, mediaType MediaType) (*UpstreamF
 		Error:  "",
 		ItemID: uuid.New().String(),
 		Media:  string(mediaType),
-	}, nil
+	}
+	getUploadCache().set(cacheKey, file)
+	return file, nil
 }
 
 // UploadImageFromURL 从 URL 或 base64 上传图片到 z.ai
@@ -445,51 +498,111 @@ func UploadVideoFromURL(token string, videoURL string) (*UpstreamFile, error) {
 
 // UploadImages 批量上传图片
 func UploadImages(token string, imageURLs []string) ([]*UpstreamFile, error) {
-	LogDebug("[UploadImages] Starting batch upload: count=%d", len(imageURLs))
-	var files []*UpstreamFile
-	for i, url := range imageURLs {
-		LogDebug("[UploadImages] Uploading image %d/%d", i+1, len(imageURLs))
-		file, err := UploadImageFromURL(token, url)
-		if err != nil {
-			LogError("upload image failed: %s - %v", url[:min(50, len(url))], err)
-			continue
-		}
-		if file == nil {
-			LogDebug("[UploadImages] Image %d skipped (unsupported URL)", i+1)
-			continue
-		}
-		LogDebug("[UploadImages] Image %d uploaded: id=%s", i+1, file.ID)
-		files = append(files, file)
-	}
-	LogDebug("[UploadImages] Batch upload complete: success=%d/%d", len(files), len(imageURLs))
-	return files, nil
+	images, _, err := UploadMediaFiles(token, imageURLs, nil)
+	return images, err
 }
 
 // UploadVideos 批量上传视频
 func UploadVideos(token string, videoURLs []string) ([]*UpstreamFile, error) {
-	LogDebug("[UploadVideos] Starting batch upload: count=%d", len(videoURLs))
-	var files []*UpstreamFile
-	for i, url := range videoURLs {
-		LogDebug("[UploadVideos] Uploading video %d/%d", i+1, len(videoURLs))
-		file, err := UploadVideoFromURL(token, url)
-		if err != nil {
-			LogError("upload video failed: %s - %v", url[:min(50, len(url))], err)
-			continue
+	_, videos, err := UploadMediaFiles(token, nil, videoURLs)
+	return videos, err
+}
+
+// UploadResult 单个媒体的上传结果
+type UploadResult struct {
+	URL       string
+	MediaType MediaType
+	File      *UpstreamFile // 上传成功时不为空
+	Err       error         // 上传失败或不支持时不为空
+}
+
+// ErrUnsupportedMedia 不支持的媒体链接
+var ErrUnsupportedMedia = errors.New("不支持的媒体链接")
+
+// UploadMediaResults 使用有限并发的工作池上传图片和视频，结果按 图片在前、视频在后 的输入顺序一一对应
+// owner 为文件归属，用于读取 file-... 引用的本地文件
+func UploadMediaResults(token string, owner string, imageURLs, videoURLs []string) []UploadResult {
+	var results []UploadResult
+	for _, u := range imageURLs {
+		results = append(results, UploadResult{URL: u, MediaType: MediaTypeImage})
+	}
+	for _, u := range videoURLs {
+		results = append(results, UploadResult{URL: u, MediaType: MediaTypeVideo})
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	budget := newUploadBudget()
+	workers := Cfg.UploadConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *UploadResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			file, err := uploadMediaWithBudget(token, owner, r.URL, r.MediaType, budget)
+			switch {
+			case err != nil:
+				LogError("upload %s failed: %s - %v", r.MediaType, r.URL[:min(50, len(r.URL))], err)
+				r.Err = err
+			case file == nil:
+				r.Err = ErrUnsupportedMedia
+			default:
+				r.File = file
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// failedUploads 返回失败的上传结果
+func failedUploads(results []UploadResult) []UploadResult {
+	var failed []UploadResult
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
 		}
-		if file == nil {
-			LogDebug("[UploadVideos] Video %d skipped (unsupported URL)", i+1)
+	}
+	return failed
+}
+
+// describeUploadFailures 生成失败媒体的说明，如 "2/3 media failed: image#2 (媒体文件超出大小限制); video#1 (请求失败)"
+func describeUploadFailures(results []UploadResult) string {
+	var parts []string
+	counts := make(map[MediaType]int)
+	failed := 0
+	for _, r := range results {
+		counts[r.MediaType]++
+		if r.Err == nil {
 			continue
 		}
-		LogDebug("[UploadVideos] Video %d uploaded: id=%s", i+1, file.ID)
-		files = append(files, file)
+		failed++
+		parts = append(parts, fmt.Sprintf("%s#%d (%v)", r.MediaType, counts[r.MediaType], r.Err))
 	}
-	LogDebug("[UploadVideos] Batch upload complete: success=%d/%d", len(files), len(videoURLs))
-	return files, nil
+	return fmt.Sprintf("%d/%d media failed: %s", failed, len(results), strings.Join(parts, "; "))
 }
 
-// UploadMediaFiles 批量上传媒体文件（图片+视频）
+// UploadMediaFiles 并发上传媒体文件（图片+视频），共享单次请求的大小限制
+// 返回结果按输入顺序排列，跳过失败和不支持的文件
 func UploadMediaFiles(token string, imageURLs, videoURLs []string) ([]*UpstreamFile, []*UpstreamFile, error) {
-	images, _ := UploadImages(token, imageURLs)
-	videos, _ := UploadVideos(token, videoURLs)
+	var images, videos []*UpstreamFile
+	for _, r := range UploadMediaResults(token, "", imageURLs, videoURLs) {
+		if r.File == nil {
+			continue
+		}
+		if r.MediaType == MediaTypeVideo {
+			videos = append(videos, r.File)
+		} else {
+			images = append(images, r.File)
+		}
+	}
+	LogDebug("[UploadMediaFiles] Batch upload complete: images=%d/%d, videos=%d/%d", len(images), len(imageURLs), len(videos), len(videoURLs))
 	return images, videos, nil
 }
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxUploadFileBytes 单个媒体文件大小上限，0 表示不限制
+func maxUploadFileBytes() int64 {
+	return int64(Cfg.UploadMaxFileMB) * 1024 * 1024
+}
+
+// uploadBudget 单次请求所有媒体文件的总大小限制
+type uploadBudget struct {
+	mu    sync.Mutex
+	used  int64
+	limit int64
+}
+
+func newUploadBudget() *uploadBudget {
+	return &uploadBudget{limit: int64(Cfg.UploadMaxRequestMB) * 1024 * 1024}
+}
+
+// reserve 占用 n 字节额度，超出限制时返回 ErrMediaTooLarge；nil 或未设置限制时不做检查
+func (b *uploadBudget) reserve(n int64) error {
+	if b == nil || b.limit <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return fmt.Errorf("%w: 单次请求媒体总大小超过 %d MB", ErrMediaTooLarge, Cfg.UploadMaxRequestMB)
+	}
+	b.used += n
+	return nil
+}
+
+// uploadCacheEntry 上传结果缓存项
+type uploadCacheEntry struct {
+	key       string
+	file      UpstreamFile
+	expiresAt time.Time
+}
+
+// uploadCache 按 token + 内容哈希缓存已上传的文件，带 TTL 的 LRU
+type uploadCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+var (
+	uploadCacheInstance *uploadCache
+	uploadCacheOnce     sync.Once
+)
+
+func getUploadCache() *uploadCache {
+	uploadCacheOnce.Do(func() {
+		uploadCacheInstance = &uploadCache{
+			ttl:        time.Duration(Cfg.UploadCacheTTL) * time.Second,
+			maxEntries: Cfg.UploadCacheMaxEntries,
+			ll:         list.New(),
+			items:      make(map[string]*list.Element),
+		}
+	})
+	return uploadCacheInstance
+}
+
+// uploadCacheKey 上传的文件只对同一 token 可见，因此按 token 区分
+func uploadCacheKey(token string, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(token))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get 返回缓存文件的副本
+func (c *uploadCache) get(key string) (*UpstreamFile, bool) {
+	if c.ttl <= 0 || c.maxEntries <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*uploadCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	file := entry.file
+	return &file, true
+}
+
+func (c *uploadCache) set(key string, file *UpstreamFile) {
+	if c.ttl <= 0 || c.maxEntries <= 0 || file == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*uploadCacheEntry)
+		entry.file = *file
+		entry.expiresAt = time.Now().Add(c.ttl)
+		c.ll.MoveToFront(elem)
+		return
+	}
+	elem := c.ll.PushFront(&uploadCacheEntry{key: key, file: *file, expiresAt: time.Now().Add(c.ttl)})
+	c.items[key] = elem
+	for c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*uploadCacheEntry).key)
+	}
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"regexp"
@@ -13,6 +15,9 @@ var (
 	versionLock sync.RWMutex
 )
 
+// errNoFeVersion 首页中未找到前端版本号
+var errNoFeVersion = errors.New("fe version not found in page")
+
 func GetFeVersion() string {
 	versionLock.RLock()
 	defer versionLock.RUnlock()
@@ -20,36 +25,57 @@ func GetFeVersion() string {
 }
 
 func fetchFeVersion() {
+	recordWorkerRun(WorkerVersionUpdater, updateFeVersion())
+}
+
+func updateFeVersion() error {
 	resp, err := http.Get("https://chat.z.ai/")
 	if err != nil {
 		LogError("Failed to fetch fe version: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		LogError("Failed to read fe version response: %v", err)
-		return
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
 	}
 
 	re := regexp.MustCompile(`prod-fe-[\.\d]+`)
 	match := re.FindString(string(body))
-	if match != "" {
-		versionLock.Lock()
-		feVersion = match
-		versionLock.Unlock()
-		LogInfo("Updated fe version: %s", match)
+	if match == "" {
+		return errNoFeVersion
 	}
+	versionLock.Lock()
+	feVersion = match
+	versionLock.Unlock()
+	LogInfo("Updated fe version: %s", match)
+	return nil
 }
 
 func StartVersionUpdater() {
 	fetchFeVersion()
 
-	ticker := time.NewTicker(1 * time.Hour)
+	ticker := time.NewTicker(1 * time.Minute)
+	lastFetch := time.Now()
 	go func() {
-		for range ticker.C {
-			fetchFeVersion()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// 未获取到版本时每分钟重试，否则每小时更新
+				if GetFeVersion() != "" && time.Since(lastFetch) < time.Hour {
+					continue
+				}
+				fetchFeVersion()
+				lastFetch = time.Now()
+			case <-workersStop:
+				return
+			}
 		}
 	}()
 }
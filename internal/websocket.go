@@ -0,0 +1,352 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsMaxMessageBytes = 64 << 20 // 单个请求帧上限（含 base64 图片）
+	wsPingInterval    = 30 * time.Second
+	wsPongWait        = 90 * time.Second
+	wsWriteWait       = 10 * time.Second
+)
+
+// WebSocket 帧类型
+const (
+	WSFrameRequest  = "request"  // 客户端：发起对话请求
+	WSFrameCancel   = "cancel"   // 客户端：取消请求
+	WSFrameChunk    = "chunk"    // 服务端：chat.completion.chunk
+	WSFrameDone     = "done"     // 服务端：请求结束
+	WSFrameError    = "error"    // 服务端：请求失败
+	WSFrameCanceled = "canceled" // 服务端：请求已取消
+)
+
+// WSClientFrame 客户端帧
+type WSClientFrame struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Request json.RawMessage `json:"request,omitempty"` // 与 /v1/chat/completions 请求体相同，stream 固定为 true
+}
+
+// WSServerFrame 服务端帧
+type WSServerFrame struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Error  *APIError       `json:"error,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     checkWSOrigin,
+}
+
+// checkWSOrigin 校验浏览器发起连接的 Origin：同源或在 WS_ALLOWED_ORIGINS 中才允许，
+// 防止其他网页借用浏览器中的 api_key 链接建立连接；非浏览器客户端不带 Origin，直接放行
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range Cfg.WSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	LogWarn("[WebSocket] 拒绝来源 %s 的连接: ip=%s", origin, GetClientIP(r))
+	return false
+}
+
+// wsConn 一个 WebSocket 连接上的多路请求
+type wsConn struct {
+	conn     *websocket.Conn
+	apiKey   string
+	r        *http.Request
+	ctx      context.Context
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+	wg       sync.WaitGroup
+	closing  sync.Once
+}
+
+// HandleWebSocket 对话 WebSocket 接口：一个连接上可并发多个请求，按客户端指定的 ID 区分
+func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// 浏览器无法设置 Authorization 请求头，允许通过 api_key 参数传递
+	// 读取后从 URL 中移除，避免 Key 出现在访问日志和后续处理中
+	if query := r.URL.Query(); query.Has("api_key") {
+		if key := query.Get("api_key"); key != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		query.Del("api_key")
+		r.URL.RawQuery = query.Encode()
+		r.RequestURI = r.URL.RequestURI()
+	}
+	apiKey, ok := authenticateRequest(w, r)
+	if !ok {
+		return
+	}
+	if Draining() {
+		writeError(w, http.StatusServiceUnavailable, ErrTypeServer, "服务正在关闭", "server_shutting_down")
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		LogWarn("[WebSocket] 升级失败: %v", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &wsConn{
+		conn:     conn,
+		apiKey:   apiKey,
+		r:        r,
+		ctx:      ctx,
+		inflight: make(map[string]context.CancelFunc),
+	}
+	LogDebug("[WebSocket] 连接建立: ip=%s", GetClientIP(r))
+	atomic.AddInt64(&hijackedConns, 1)
+	defer atomic.AddInt64(&hijackedConns, -1)
+	// 服务关闭时等待进行中的请求结束后关闭连接
+	stopDrain := context.AfterFunc(drainCtx, c.closeIfIdle)
+	defer stopDrain()
+
+	go c.pingLoop()
+	c.readLoop()
+
+	// 连接断开后取消所有进行中的请求
+	cancel()
+	c.wg.Wait()
+	conn.Close()
+	LogDebug("[WebSocket] 连接关闭: ip=%s", GetClientIP(r))
+}
+
+func (c *wsConn) readLoop() {
+	c.conn.SetReadLimit(wsMaxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var frame WSClientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			c.sendError("", http.StatusBadRequest, "无效的消息格式", "invalid_request")
+			continue
+		}
+		switch frame.Type {
+		case WSFrameRequest:
+			c.start(frame)
+		case WSFrameCancel:
+			c.cancel(frame.ID)
+		default:
+			c.sendError(frame.ID, http.StatusBadRequest, fmt.Sprintf("未知的消息类型 '%s'", frame.Type), "invalid_request")
+		}
+	}
+}
+
+func (c *wsConn) pingLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			c.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// start 在独立的 goroutine 中执行请求
+func (c *wsConn) start(frame WSClientFrame) {
+	if frame.ID == "" {
+		c.sendError("", http.StatusBadRequest, "缺少请求 id", "invalid_request")
+		return
+	}
+	var chatReq ChatRequest
+	if err := json.Unmarshal(frame.Request, &chatReq); err != nil {
+		c.sendError(frame.ID, http.StatusBadRequest, "无效的请求格式", "invalid_request")
+		return
+	}
+	chatReq.Stream = true
+
+	if Draining() {
+		c.sendError(frame.ID, http.StatusServiceUnavailable, "服务正在关闭，请重新连接", "server_shutting_down")
+		return
+	}
+
+	c.mu.Lock()
+	if _, exists := c.inflight[frame.ID]; exists {
+		c.mu.Unlock()
+		c.sendError(frame.ID, http.StatusConflict, fmt.Sprintf("请求 '%s' 正在进行中", frame.ID), "duplicate_request_id")
+		return
+	}
+	if Cfg.WSMaxInflight > 0 && len(c.inflight) >= Cfg.WSMaxInflight {
+		c.mu.Unlock()
+		c.sendError(frame.ID, http.StatusTooManyRequests, fmt.Sprintf("单个连接最多同时处理 %d 个请求", Cfg.WSMaxInflight), "too_many_requests")
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.inflight[frame.ID] = cancel
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.inflight, frame.ID)
+			c.mu.Unlock()
+			cancel()
+			if Draining() {
+				c.closeIfIdle()
+			}
+		}()
+		c.run(ctx, frame.ID, &chatReq)
+	}()
+}
+
+// cancel 取消请求，中止上游生成
+func (c *wsConn) cancel(id string) {
+	c.mu.Lock()
+	cancel, ok := c.inflight[id]
+	c.mu.Unlock()
+	if !ok {
+		c.sendError(id, http.StatusNotFound, fmt.Sprintf("请求 '%s' 不存在或已结束", id), "request_not_found")
+		return
+	}
+	cancel()
+}
+
+// run 执行对话请求，chunk 原样转发；取消时 context 结束并中止上游请求
+func (c *wsConn) run(ctx context.Context, id string, chatReq *ChatRequest) {
+	r := c.r.WithContext(ctx)
+	req := newInternalChatRequest(r, newInternalCaller(r, c.apiKey), chatReq)
+
+	fw := &wsFrameWriter{c: c, id: id, header: make(http.Header), status: http.StatusOK}
+	sw := newChatStreamWriter(fw, "text/event-stream", nil, func() {})
+	sw.onData = func(data []byte) {
+		if ctx.Err() == nil {
+			c.send(WSServerFrame{Type: WSFrameChunk, ID: id, Data: data})
+		}
+	}
+	HandleChatCompletions(sw, req)
+
+	switch {
+	case ctx.Err() != nil:
+		if c.ctx.Err() == nil {
+			c.send(WSServerFrame{Type: WSFrameCanceled, ID: id})
+		}
+	case !fw.failed:
+		c.send(WSServerFrame{Type: WSFrameDone, ID: id})
+	}
+}
+
+// closeIfIdle 没有进行中的请求时关闭连接（服务关闭时使用）
+func (c *wsConn) closeIfIdle() {
+	c.mu.Lock()
+	idle := len(c.inflight) == 0
+	c.mu.Unlock()
+	if !idle {
+		return
+	}
+	c.closing.Do(func() {
+		c.writeMu.Lock()
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(wsWriteWait))
+		c.writeMu.Unlock()
+		c.conn.Close()
+	})
+}
+
+func (c *wsConn) send(frame WSServerFrame) {
+	data, _ := json.Marshal(frame)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		LogDebug("[WebSocket] 发送失败: %v", err)
+	}
+}
+
+func (c *wsConn) sendError(id string, status int, message, code string) {
+	c.send(WSServerFrame{
+		Type:   WSFrameError,
+		ID:     id,
+		Status: status,
+		Error:  &APIError{Message: message, Type: errorTypeForStatus(status), Code: code},
+	})
+}
+
+// errorTypeForStatus 按状态码选择错误类型
+func errorTypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrTypeAuthentication
+	case status == http.StatusNotFound:
+		return ErrTypeNotFound
+	case status == http.StatusTooManyRequests:
+		return ErrTypeRateLimit
+	case status >= 500:
+		return ErrTypeServer
+	default:
+		return ErrTypeInvalidRequest
+	}
+}
+
+// wsFrameWriter 接收对话接口的非流式输出（错误响应），转换为 error 帧
+type wsFrameWriter struct {
+	c      *wsConn
+	id     string
+	header http.Header
+	status int
+	failed bool
+}
+
+func (fw *wsFrameWriter) Header() http.Header {
+	return fw.header
+}
+
+func (fw *wsFrameWriter) WriteHeader(code int) {
+	fw.status = code
+}
+
+func (fw *wsFrameWriter) Write(p []byte) (int, error) {
+	if fw.status == http.StatusOK {
+		return len(p), nil
+	}
+	fw.failed = true
+	var errResp ErrorResponse
+	if err := json.Unmarshal(p, &errResp); err != nil || errResp.Error.Message == "" {
+		errResp.Error = APIError{Message: string(p), Type: errorTypeForStatus(fw.status)}
+	}
+	fw.c.send(WSServerFrame{Type: WSFrameError, ID: fw.id, Status: fw.status, Error: &errResp.Error})
+	return len(p), nil
+}
+
+func (fw *wsFrameWriter) Flush() {}